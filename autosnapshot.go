@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// snapshotter saves the cache to a snapshot file periodically
+type snapshotter struct {
+	path     string
+	interval time.Duration
+	hook     func(op Op, path string, err error)
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (s *snapshotter) report(op Op, err error) {
+	if s.hook != nil {
+		s.hook(op, s.path, err)
+	}
+}
+
+// restoreSnapshot loads the configured snapshot, ignoring a missing file
+func (c *cache[K, V]) restoreSnapshot() {
+	s := c.snapshotter
+	err := c.LoadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	s.report(OpLoad, err)
+}
+
+// saveSnapshot saves the cache to the configured snapshot file
+func (c *cache[K, V]) saveSnapshot() {
+	s := c.snapshotter
+	s.report(OpSave, c.SaveFile(s.path))
+}
+
+// runSnapshotter saves the cache every interval until stopped
+func (c *cache[K, V]) runSnapshotter() {
+	s := c.snapshotter
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.saveSnapshot()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSnapshotter stops the background saves and saves one last time
+func (c *cache[K, V]) stopSnapshotter() {
+	s := c.snapshotter
+	if s == nil || s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	c.saveSnapshot()
+}
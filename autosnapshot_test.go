@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSnapshotHook struct {
+	mu    sync.Mutex
+	calls []Op
+	errs  []error
+}
+
+func (h *testSnapshotHook) hook(op Op, path string, err error) {
+	h.mu.Lock()
+	h.calls = append(h.calls, op)
+	h.errs = append(h.errs, err)
+	h.mu.Unlock()
+}
+
+func (h *testSnapshotHook) count(op Op) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, v := range h.calls {
+		if v == op {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWithSnapshot(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	h := &testSnapshotHook{}
+	tc := NewNumber[string, int](DefaultExpiration, 0,
+		WithSnapshot(fname, 5*time.Millisecond), WithSnapshotHook(h.hook))
+	if h.count(OpLoad) != 0 {
+		t.Error("missing snapshot was reported")
+	}
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("expiring", 2, 30*time.Millisecond)
+	<-time.After(20 * time.Millisecond)
+	if h.count(OpSave) == 0 {
+		t.Error("no periodic snapshot was saved")
+	}
+	tc.Set("b", 3, DefaultExpiration)
+	tc.Close()
+	for _, err := range h.errs {
+		if err != nil {
+			t.Error("snapshot failed:", err)
+		}
+	}
+
+	<-time.After(20 * time.Millisecond)
+	oc := NewNumber[string, int](DefaultExpiration, 0, WithSnapshot(fname, 0), WithSnapshotHook(h.hook))
+	defer oc.Close()
+	if h.count(OpLoad) != 1 {
+		t.Error("restore was not reported")
+	}
+	if v, _ := oc.Get("a"); v != 1 {
+		t.Error("a was not restored:", v)
+	}
+	if v, _ := oc.Get("b"); v != 3 {
+		t.Error("b saved by Close was not restored:", v)
+	}
+	if _, found := oc.items["expiring"]; found {
+		t.Error("expired item was restored")
+	}
+}
+
+func TestWithSnapshotCorrupt(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	if err := os.WriteFile(fname, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := &testSnapshotHook{}
+	tc := New[string, int](DefaultExpiration, 0, WithSnapshot(fname, 0), WithSnapshotHook(h.hook))
+	defer tc.Close()
+	if len(h.errs) != 1 || !errors.Is(h.errs[0], ErrSnapshotMagic) {
+		t.Error("corrupt snapshot was not reported:", h.errs)
+	}
+}
@@ -12,29 +12,29 @@ const (
 	DefaultExpiration time.Duration = 0
 )
 
-func New[K comparable, V any](defaultExpiration, cleanupInterval time.Duration) *Any[K, V] {
+func New[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Any[K, V] {
 	items := make(map[K]Item[V])
-	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items)
+	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
 
-func NewFrom[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V]) *Any[K, V] {
-	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items)
+func NewFrom[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V], opts ...Option) *Any[K, V] {
+	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
 
-func NewAny[K comparable, V any](defaultExpiration, cleanupInterval time.Duration) *Any[K, V] {
+func NewAny[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Any[K, V] {
 	items := make(map[K]Item[V])
-	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items)
+	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
 
-func NewAnyFrom[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V]) *Any[K, V] {
-	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items)
+func NewAnyFrom[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V], opts ...Option) *Any[K, V] {
+	return newCacheAnyWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
 
-func NewNumber[K comparable, V number](defaultExpiration, cleanupInterval time.Duration) *Number[K, V] {
+func NewNumber[K comparable, V number](defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Number[K, V] {
 	items := make(map[K]Item[V])
-	return newCacheNumberWithJanitor(defaultExpiration, cleanupInterval, items)
+	return newCacheNumberWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
 
-func NewNumberFrom[K comparable, V number](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V]) *Number[K, V] {
-	return newCacheNumberWithJanitor(defaultExpiration, cleanupInterval, items)
+func NewNumberFrom[K comparable, V number](defaultExpiration, cleanupInterval time.Duration, items map[K]Item[V], opts ...Option) *Number[K, V] {
+	return newCacheNumberWithJanitor(defaultExpiration, cleanupInterval, items, opts)
 }
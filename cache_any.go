@@ -6,11 +6,16 @@ import (
 )
 
 // newCacheAnyWithJanitor create new cache with janitor
-func newCacheAnyWithJanitor[K comparable, V any](de time.Duration, ci time.Duration, m map[K]Item[V]) *Any[K, V] {
-	c := newCache(de, m)
+func newCacheAnyWithJanitor[K comparable, V any](de time.Duration, ci time.Duration, m map[K]Item[V], opts []Option) *Any[K, V] {
+	o := applyOptions(opts)
+	c := newCache(de, m, o)
 	C := &Any[K, V]{c}
+	// Restore the items before the janitor can sweep them
+	background := c.start()
 	if ci > 0 {
-		runJanitor(c, ci)
+		runJanitor(c, ci, o.janitor)
+	}
+	if background || ci > 0 {
 		runtime.SetFinalizer(C, stopJanitor)
 	}
 	return C
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"runtime"
 	"strconv"
@@ -179,6 +180,28 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestUpdate(t *testing.T) {
+	tc := New[string, string](DefaultExpiration, 0)
+	if _, err := tc.Update("foo", func(v string) (string, error) { return v + "!", nil }); err == nil {
+		t.Error("Updated foo when it shouldn't exist")
+	}
+	tc.Set("foo", "bar", time.Hour)
+	v, err := tc.Update("foo", func(v string) (string, error) { return v + "!", nil })
+	if err != nil || v != "bar!" {
+		t.Error("Update returned", v, err)
+	}
+	if _, e, _ := tc.GetWithExpiration("foo"); e.IsZero() {
+		t.Error("Update dropped the expiration")
+	}
+	refuse := errors.New("refused")
+	if _, err = tc.Update("foo", func(v string) (string, error) { return "", refuse }); err != refuse {
+		t.Error("Update didn't return the error of f:", err)
+	}
+	if x, _ := tc.Get("foo"); x != "bar!" {
+		t.Error("failed Update changed foo to", x)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	tc := New[string, any](DefaultExpiration, 0)
 	tc.Set("foo", "bar", DefaultExpiration)
@@ -226,8 +249,8 @@ func TestFlush(t *testing.T) {
 func TestOnEvicted(t *testing.T) {
 	tc := New[string, any](DefaultExpiration, 0)
 	tc.Set("foo", 3, DefaultExpiration)
-	if tc.onEvicted != nil {
-		t.Fatal("tc.onEvicted is not nil")
+	if tc.hasEvictListeners() {
+		t.Fatal("tc has eviction listeners")
 	}
 	works := false
 	tc.OnEvicted(func(k string, v interface{}, hit int) {
@@ -419,7 +442,11 @@ func TestSerializeUnserializable(t *testing.T) {
 	tc.Set("chan", ch, DefaultExpiration)
 	fp := &bytes.Buffer{}
 	err := tc.Save(fp) // this should fail gracefully
-	if err.Error() != "gob NewTypeObject can't handle type: chan bool" {
+	var ee *EncodeError
+	if !errors.As(err, &ee) || ee.Key != "chan" {
+		t.Fatal("Error from Save does not name the chan item:", err)
+	}
+	if ee.Err.Error() != "gob NewTypeObject can't handle type: chan bool" {
 		t.Error("Error from Save was not gob NewTypeObject can't handle type chan bool:", err)
 	}
 }
@@ -758,3 +785,27 @@ func TestGetWithExpiration(t *testing.T) {
 		t.Error("expiration for e is in the past")
 	}
 }
+func TestPeekRemove(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("expired", 2, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if item, found := tc.Peek("a"); !found || item.Value != 1 || item.Hit != 0 {
+		t.Error("unexpected Peek of a:", item, found)
+	}
+	if _, found := tc.Peek("expired"); found {
+		t.Error("Peek returned an expired item")
+	}
+	if s := tc.Stats(); s.Hits != 0 || s.Misses != 0 {
+		t.Error("Peek counted in stats:", s)
+	}
+	if v, found := tc.Remove("a"); !found || v != 1 {
+		t.Error("unexpected Remove of a:", v, found)
+	}
+	if _, found := tc.Remove("a"); found {
+		t.Error("Remove of a removed key found it")
+	}
+	if _, found := tc.Remove("expired"); found || tc.ItemCount() != 0 {
+		t.Error("Remove of an expired key found it, or didn't delete it")
+	}
+}
@@ -1,38 +1,101 @@
 package cache
 
 import (
-	"encoding/gob"
 	"fmt"
-	"io"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // newCache create new Cache
-func newCache[K comparable, V any](d time.Duration, m map[K]Item[V]) *cache[K, V] {
+func newCache[K comparable, V any](d time.Duration, m map[K]Item[V], o options) *cache[K, V] {
 	if d == 0 {
 		d = NoExpiration
 	}
 	c := &cache[K, V]{
 		defaultExpiration: d,
 		items:             m,
+		capacity:          o.capacity,
+	}
+	c.evicted.onPanic = o.onPanic
+	if o.evictWorkers > 0 {
+		c.evicted.pool = newDispatchPool(o.evictWorkers, o.evictQueue)
+	}
+	if o.walPath != "" {
+		c.wal = &wal[K, V]{path: o.walPath, policy: o.walPolicy, register: isInterface[V]()}
+	}
+	if o.precise {
+		c.expiry = &expiry[K]{}
+	}
+	if o.transport != nil {
+		c.invalidator = newInvalidator(c, o.transport, o.onInvalidError)
+	}
+	if o.snapshotPath != "" {
+		c.snapshotter = &snapshotter{
+			path:     o.snapshotPath,
+			interval: o.snapshotInterval,
+			hook:     o.onSnapshot,
+		}
 	}
 	return c
 }
 
+// start restores the cache and starts the background goroutines requested by
+// the options. It reports whether Close has anything to stop.
+func (c *cache[K, V]) start() bool {
+	if c.wal != nil {
+		c.mu.Lock()
+		err := c.wal.open(c)
+		c.mu.Unlock()
+		if err != nil {
+			c.wal.mu.Lock()
+			c.wal.setErr(err)
+			c.wal.mu.Unlock()
+		}
+	}
+	if c.snapshotter != nil {
+		if c.wal == nil {
+			c.restoreSnapshot()
+		}
+		if c.snapshotter.interval > 0 {
+			c.runSnapshotter()
+		}
+	}
+	if c.invalidator != nil {
+		c.invalidator.start()
+	}
+	if c.expiry != nil {
+		c.mu.Lock()
+		c.rescheduleAll()
+		c.mu.Unlock()
+	}
+	return c.evicted.pool != nil || c.snapshotter != nil || c.wal != nil || c.invalidator != nil || c.expiry != nil
+}
+
 type cache[K comparable, V any] struct {
 	items             map[K]Item[V]
 	mu                sync.RWMutex
-	onEvicted         func(key K, value V, hit int)
+	evicted           evictListeners[K, V]
 	defaultExpiration time.Duration
 	janitor           *janitor // Auto Clean expired item
-}
-
-func (c *cache[K, V]) OnEvicted(f func(key K, value V, hit int)) {
-	c.mu.Lock()
-	c.onEvicted = f
-	c.mu.Unlock()
+	stats             stats
+	tracer            atomic.Pointer[Tracer]
+	events            eventBus[K, V]
+	snapshotter       *snapshotter
+	wal               *wal[K, V]
+	addValue          func(a, b V, sub bool) V // arithmetic of Number, used to replay the log
+	capacity          int
+	disk              *DiskTier[K, V]
+	sink              interface{ close() } // write-behind of Number.SetSink
+	markDirty         func(k K)            // called with every changed key while sink is set
+	invalidator       *invalidator[K, V]
+	budget            *budget[K, V]           // capacity shared with the namespaces
+	size              atomic.Int64            // number of items, kept while budget is set
+	namespaces        map[string]*cache[K, V] // created by Namespace
+	namespaced        bool                    // a namespace, closed by its parent
+	callbacks         map[K]*callback[K, V]   // of SetWithCallback
+	expiry            *expiry[K]              // of WithPreciseExpiration
+	closeOnce         sync.Once
 }
 
 func (c *cache[K, V]) SetJanitor(j *janitor) {
@@ -40,13 +103,53 @@ func (c *cache[K, V]) SetJanitor(j *janitor) {
 }
 
 func (c *cache[K, V]) StopJanitor() {
-	c.janitor.stop <- true
+	if c.janitor != nil {
+		c.janitor.shutdown()
+	}
+}
+
+// Close stops the janitor, waiting for a sweep in progress, and the
+// background goroutines of the cache, writes the remaining changes to the
+// sink of SetSink, publishes the remaining invalidations of WithInvalidation,
+// saves the final snapshot if WithSnapshot was given an interval, and waits
+// for queued OnEvicted listeners to finish, so it must not be called from a
+// listener. Namespaces created with Namespace are closed too. The items stay
+// accessible, but are no longer cleaned up or saved automatically.
+func (c *cache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		c.StopJanitor()
+		c.mu.Lock()
+		sink := c.sink
+		c.sink = nil
+		c.setMarkDirty(nil)
+		if c.expiry != nil {
+			c.stopExpiry()
+		}
+		c.mu.Unlock()
+		if sink != nil {
+			sink.close()
+		}
+		if c.invalidator != nil {
+			c.invalidator.close()
+		}
+		c.stopSnapshotter()
+		if c.wal != nil {
+			c.wal.close()
+		}
+		if c.evicted.pool != nil {
+			c.evicted.pool.stop()
+		}
+		for _, ns := range c.subspaces() {
+			ns.Close()
+		}
+	})
 }
 
 // Set Add an item to the cache, replacing any existing item. If the duration is 0
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
 // (NoExpiration), the item never expires.
 func (c *cache[K, V]) Set(k K, v V, d time.Duration) {
+	sp := c.traceKey(OpSet, k)
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -55,12 +158,15 @@ func (c *cache[K, V]) Set(k K, v V, d time.Duration) {
 		e = time.Now().Add(d).UnixNano()
 	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.items[k] = Item[V]{
+	evs := c.store(k, Item[V]{
 		Value:      v,
 		Expiration: e,
 		Hit:        0,
-	}
+	}, nil)
+	c.mu.Unlock()
+	c.stats.sets.Add(1)
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 }
 
 // SetDefault Add an item to the cache, replacing any existing item, using the default expiration.
@@ -68,7 +174,7 @@ func (c *cache[K, V]) SetDefault(k K, v V) {
 	c.Set(k, v, DefaultExpiration)
 }
 
-func (c *cache[K, V]) set(k K, v V, d time.Duration) {
+func (c *cache[K, V]) set(k K, v V, d time.Duration) []Event[K, V] {
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -76,10 +182,116 @@ func (c *cache[K, V]) set(k K, v V, d time.Duration) {
 	if d > 0 {
 		e = time.Now().Add(d).UnixNano()
 	}
-	c.items[k] = Item[V]{
+	c.stats.sets.Add(1)
+	return c.store(k, Item[V]{
 		Value:      v,
 		Expiration: e,
 		Hit:        0,
+	}, nil)
+}
+
+// store writes item under k, logs it and appends the resulting event to evs
+// if anybody is watching. c.mu must be held.
+func (c *cache[K, V]) store(k K, item Item[V], evs []Event[K, V]) []Event[K, V] {
+	evs = c.write(k, item, evs)
+	c.logWAL(walRecord[K, V]{Op: walSet, Key: k, Value: item.Value, Expiration: item.Expiration})
+	return evs
+}
+
+// write is store without logging, for callers that log the mutation
+// themselves. Only evicting another item to make room is logged. c.mu must be
+// held.
+func (c *cache[K, V]) write(k K, item Item[V], evs []Event[K, V]) []Event[K, V] {
+	old, found := c.items[k]
+	if !found || old.Expired() {
+		evs = c.removed(evs, k, old.Value)
+	}
+	if !found {
+		evs = c.makeRoom(evs)
+		if c.disk != nil {
+			c.disk.delete(k)
+		}
+	}
+	if c.watching() {
+		evs = append(evs, changeEvent(k, old, found, item.Value))
+	}
+	c.items[k] = item
+	if !found {
+		c.sized(1)
+	}
+	if c.expiry != nil && item.Expiration > 0 {
+		c.schedule(k, item.Expiration)
+	}
+	if c.markDirty != nil {
+		c.markDirty(k)
+	}
+	if c.invalidator != nil {
+		c.invalidator.mark(k)
+	}
+	return evs
+}
+
+// lookup returns the unexpired item stored under k, moving it back from the
+// disk tier into memory if it was evicted. Events of making room for it are
+// appended to evs. c.mu must be held for writing.
+func (c *cache[K, V]) lookup(k K, evs []Event[K, V]) (Item[V], bool, []Event[K, V]) {
+	item, found := c.items[k]
+	if found && !item.Expired() {
+		return item, true, evs
+	}
+	if c.disk == nil {
+		return Item[V]{}, false, evs
+	}
+	promoted, ok := c.disk.take(k)
+	if !ok {
+		return Item[V]{}, false, evs
+	}
+	if !found {
+		evs = c.makeRoom(evs)
+	}
+	c.items[k] = promoted
+	if !found {
+		c.sized(1)
+	}
+	if c.expiry != nil && promoted.Expiration > 0 {
+		c.schedule(k, promoted.Expiration)
+	}
+	c.logWAL(walRecord[K, V]{Op: walSet, Key: k, Value: promoted.Value, Expiration: promoted.Expiration})
+	return promoted, true, evs
+}
+
+// promote is lookup for readers that found nothing in memory
+func (c *cache[K, V]) promote(k K) (Item[V], bool) {
+	c.mu.Lock()
+	item, found, evs := c.lookup(k, nil)
+	c.mu.Unlock()
+	c.publish(evs)
+	return item, found
+}
+
+// SetDiskTier attaches t as the second tier of the cache: items evicted to
+// stay within the capacity set by WithCapacity are written to t and read back
+// on the next lookup. Items, ItemCount and Save only cover the items in
+// memory. The tier is not closed by Close. Passing nil detaches the tier,
+// leaving its items on disk.
+func (c *cache[K, V]) SetDiskTier(t *DiskTier[K, V]) {
+	c.mu.Lock()
+	if c.disk != nil && c.disk != t {
+		c.disk.setDropped(nil)
+	}
+	c.disk = t
+	if t != nil {
+		t.setDropped(c.markDirty)
+	}
+	c.mu.Unlock()
+}
+
+// setMarkDirty sets the function called with every changed or removed key,
+// including those the disk tier drops. c.mu must be held.
+func (c *cache[K, V]) setMarkDirty(f func(k K)) {
+	c.markDirty = f
+	if c.disk != nil {
+		c.disk.setDropped(f)
 	}
 }
 
@@ -110,42 +322,93 @@ func (c *cache[K, V]) UpdateExpiration(k K, d time.Duration) error {
 		e = time.Now().Add(d).UnixNano()
 	}
 	c.mu.Lock()
-	v, found := c.items[k]
-	if !found || v.Expired() {
+	v, found, evs := c.lookup(k, nil)
+	if !found {
 		c.mu.Unlock()
 		return fmt.Errorf("Item %v not found", k)
 	}
 	v.Expiration = e
 	c.items[k] = v
+	if c.markDirty != nil {
+		c.markDirty(k)
+	}
+	if c.expiry != nil && e > 0 {
+		c.schedule(k, e)
+	}
+	c.logWAL(walRecord[K, V]{Op: walExpire, Key: k, Expiration: e})
 	c.mu.Unlock()
+	c.publish(evs)
 	return nil
 }
 
+// Update atomically replaces the value of an unexpired item with the result
+// of f, keeping its expiration, and returns the new value. If f returns an
+// error the item is left unchanged and the error is returned. Returns an
+// error if the item was not found. f is called with the cache locked and
+// must not use the cache.
+func (c *cache[K, V]) Update(k K, f func(v V) (V, error)) (V, error) {
+	sp := c.traceKey(OpUpdate, k)
+	c.mu.Lock()
+	item, found, evs := c.lookup(k, nil)
+	if !found {
+		c.mu.Unlock()
+		c.publish(evs)
+		var v V
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return v, err
+	}
+	v, err := f(item.Value)
+	if err != nil {
+		c.mu.Unlock()
+		c.publish(evs)
+		sp.end(OutcomeError, err, 0)
+		return item.Value, err
+	}
+	item.Value = v
+	evs = c.store(k, item, evs)
+	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
+	return v, nil
+}
+
 // Add an item to the cache only if an item doesn't already exist for the given
 // key, or if the existing item has expired. Returns an error otherwise.
 func (c *cache[K, V]) Add(k K, v V, d time.Duration) error {
+	sp := c.traceKey(OpAdd, k)
 	c.mu.Lock()
-	_, found := c.get(k)
+	_, found, evs := c.lookup(k, nil)
 	if found {
 		c.mu.Unlock()
-		return fmt.Errorf("Item %v already exists", k)
+		c.publish(evs)
+		err := fmt.Errorf("Item %v already exists", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
-	c.set(k, v, d)
+	evs = append(evs, c.set(k, v, d)...)
 	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
 // Set a new value for the cache key only if it already exists, and the existing
 // item hasn't expired. Returns an error otherwise.
 func (c *cache[K, V]) Replace(k K, x V, d time.Duration) error {
+	sp := c.traceKey(OpReplace, k)
 	c.mu.Lock()
-	_, found := c.get(k)
+	_, found, evs := c.lookup(k, nil)
 	if !found {
 		c.mu.Unlock()
-		return fmt.Errorf("Item %v doesn't exist", k)
+		err := fmt.Errorf("Item %v doesn't exist", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
-	c.set(k, x, d)
+	evs = append(evs, c.set(k, x, d)...)
 	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
@@ -153,20 +416,25 @@ func (c *cache[K, V]) Replace(k K, x V, d time.Duration) error {
 // whether the key was found.
 func (c *cache[K, V]) Get(k K) (V, bool) {
 	var v V
+	sp := c.traceKey(OpGet, k)
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
+	if found && item.Expiration > 0 && time.Now().UnixNano() > item.Expiration {
+		found = false
+	}
+	disk := c.disk
+	c.mu.RUnlock()
+	if !found && disk != nil && disk.has(k) {
+		item, found = c.promote(k)
+	}
 	if !found {
-		c.mu.RUnlock()
+		c.stats.misses.Add(1)
+		sp.endLookup(false)
 		return v, false
 	}
-	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
-			c.mu.RUnlock()
-			return v, false
-		}
-	}
-	c.mu.RUnlock()
+	c.stats.hits.Add(1)
+	sp.endLookup(true)
 	return item.Value, true
 }
 
@@ -176,190 +444,218 @@ func (c *cache[K, V]) Get(k K) (V, bool) {
 // whether the key was found.
 func (c *cache[K, V]) GetWithExpiration(k K) (V, time.Time, bool) {
 	var v V
+	sp := c.traceKey(OpGet, k)
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
+	if found && item.Expiration > 0 && time.Now().UnixNano() > item.Expiration {
+		found = false
+	}
+	disk := c.disk
+	c.mu.RUnlock()
+	if !found && disk != nil && disk.has(k) {
+		item, found = c.promote(k)
+	}
 	if !found {
-		c.mu.RUnlock()
+		c.stats.misses.Add(1)
+		sp.endLookup(false)
 		return v, time.Time{}, false
 	}
+	c.stats.hits.Add(1)
+	sp.endLookup(true)
 
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
-			c.mu.RUnlock()
-			return v, time.Time{}, false
-		}
-
 		// Return the item and the expiration time
-		c.mu.RUnlock()
 		return item.Value, time.Unix(0, item.Expiration), true
 	}
 
 	// If expiration <= 0 (i.e. no expiration time set) then return the item
 	// and a zeroed time.Time
-	c.mu.RUnlock()
 	return item.Value, time.Time{}, true
 }
 
 func (c *cache[K, V]) GetWithHit(k K) (V, int, bool) {
 	var v V
+	sp := c.traceKey(OpGet, k)
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
+	if found && item.Expiration > 0 && time.Now().UnixNano() > item.Expiration {
+		found = false
+	}
+	disk := c.disk
+	c.mu.RUnlock()
+	if !found && disk != nil && disk.has(k) {
+		item, found = c.promote(k)
+	}
 	if !found {
-		c.mu.RUnlock()
+		c.stats.misses.Add(1)
+		sp.endLookup(false)
 		return v, 0, false
 	}
 
-	c.mu.RUnlock()
+	c.stats.hits.Add(1)
+	sp.endLookup(true)
 	return item.Value, item.Hit, true
 }
 
 func (c *cache[K, V]) GetWithHitExpiration(k K) (V, int, time.Time, bool) {
 	var v V
+	sp := c.traceKey(OpGet, k)
 	c.mu.RLock()
 	// "Inlining" of get and Expired
 	item, found := c.items[k]
+	if found && item.Expiration > 0 && time.Now().UnixNano() > item.Expiration {
+		found = false
+	}
+	disk := c.disk
+	c.mu.RUnlock()
+	if !found && disk != nil && disk.has(k) {
+		item, found = c.promote(k)
+	}
 	if !found {
-		c.mu.RUnlock()
+		c.stats.misses.Add(1)
+		sp.endLookup(false)
 		return v, 0, time.Time{}, false
 	}
+	c.stats.hits.Add(1)
+	sp.endLookup(true)
 
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
-			c.mu.RUnlock()
-			return v, 0, time.Time{}, false
-		}
-
 		// Return the item and the expiration time
-		c.mu.RUnlock()
 		return item.Value, item.Hit, time.Unix(0, item.Expiration), true
 	}
 
 	// If expiration <= 0 (i.e. no expiration time set) then return the item
 	// and a zeroed time.Time
-	c.mu.RUnlock()
 	return item.Value, item.Hit, time.Time{}, true
 }
 
-// DeleteExpired delete all expired items
+// DeleteExpired delete all expired items, including those of the namespaces
+// created with Namespace
 func (c *cache[K, V]) DeleteExpired() {
+	sp := c.trace(OpDeleteExpired)
 	var evictedItems []keyAndValueModel[K, V]
+	var evs []Event[K, V]
+	n := 0
 	now := time.Now().UnixNano()
 	c.mu.Lock()
+	watching := c.watching()
+	listening := c.hasEvictListeners()
 	for k, v := range c.items {
 		// "Inlining" of expired
 		if v.Expiration > 0 && now > v.Expiration {
-			ov, oh, evicted := c.delete(k)
-			if evicted {
+			ov, oh, _ := c.delete(k)
+			evs = c.removed(evs, k, ov)
+			n++
+			if listening {
 				evictedItems = append(evictedItems, keyAndValueModel[K, V]{k, ov, oh})
 			}
+			if watching {
+				evs = append(evs, removeEvent(EventExpire, k, ov))
+			}
 		}
 	}
+	disk := c.disk
 	c.mu.Unlock()
-	for _, v := range evictedItems {
-		c.onEvicted(v.key, v.value, v.hit)
+	if disk != nil {
+		disk.deleteExpired()
+	}
+	c.stats.expirations.Add(uint64(n))
+	c.notifyEvicted(evictedItems)
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, n)
+	for _, ns := range c.subspaces() {
+		ns.DeleteExpired()
 	}
 }
 
+// delete removes k and returns its value, hit count and whether it was found.
 func (c *cache[K, V]) delete(k K) (V, int, bool) {
-	if c.onEvicted != nil {
-		if v, found := c.items[k]; found {
-			delete(c.items, k)
-			return v.Value, v.Hit, true
+	if v, found := c.items[k]; found {
+		delete(c.items, k)
+		c.sized(-1)
+		if c.markDirty != nil {
+			c.markDirty(k)
 		}
+		return v.Value, v.Hit, true
 	}
-	delete(c.items, k)
 	var v V
 	return v, 0, false
 }
 
 // Delete an item from the cache. Does nothing if the key is not in the cache.
 func (c *cache[K, V]) Delete(k K) {
-	c.mu.Lock()
-	v, hit, evicted := c.delete(k)
-	c.mu.Unlock()
-	if evicted {
-		c.onEvicted(k, v, hit)
-	}
+	c.Remove(k)
 }
 
-// Save Write the cache's items (using Gob) to an io.Writer.
-//
-// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
-// documentation for NewFrom().)
-func (c *cache[K, V]) Save(w io.Writer) (err error) {
-	enc := gob.NewEncoder(w)
-	defer func() {
-		if x := recover(); x != nil {
-			err = fmt.Errorf("Error registering item types with Gob library")
-		}
-	}()
+// Remove deletes an item from the cache like Delete, and returns its value
+// and whether it was in the cache and hadn't expired.
+func (c *cache[K, V]) Remove(k K) (V, bool) {
+	sp := c.traceKey(OpDelete, k)
+	var evs []Event[K, V]
+	// An item on disk is read without holding the lock
+	var stored Item[V]
+	var version uint64
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	for _, v := range c.items {
-		gob.Register(v.Value)
+	disk := c.disk
+	c.mu.RUnlock()
+	if disk != nil {
+		stored, version, _ = disk.peek(k)
 	}
-	err = enc.Encode(&c.items)
-	return
-}
-
-// Save the cache's items to the given filename, creating the file if it
-// doesn't exist, and overwriting it if it does.
-//
-// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
-// documentation for NewFrom().)
-func (c *cache[K, V]) SaveFile(fname string) error {
-	fp, err := os.Create(fname)
-	if err != nil {
-		return err
+	c.mu.Lock()
+	item, live := c.items[k]
+	live = live && !item.Expired()
+	v, hit, found := c.delete(k)
+	if found {
+		evs = c.removed(evs, k, v)
+		c.logWAL(walRecord[K, V]{Op: walDelete, Key: k})
 	}
-	err = c.Save(fp)
-	if err != nil {
-		fp.Close()
-		return err
+	// Other replicas may hold k even if this one doesn't
+	if c.invalidator != nil {
+		c.invalidator.mark(k)
 	}
-	return fp.Close()
-}
-
-// Add (Gob-serialized) cache items from an io.Reader, excluding any items with
-// keys that already exist (and haven't expired) in the current cache.
-//
-// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
-// documentation for NewFrom().)
-func (c *cache[K, V]) Load(r io.Reader) error {
-	dec := gob.NewDecoder(r)
-	items := map[K]Item[V]{}
-	err := dec.Decode(&items)
-	if err == nil {
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		for k, v := range items {
-			ov, found := c.items[k]
-			if !found || ov.Expired() {
-				c.items[k] = v
-			}
+	if c.disk != nil {
+		if disk != c.disk {
+			version = 0
+		}
+		if stored, ok := c.disk.takeVersion(k, version, stored); ok && !found {
+			v, live = stored.Value, true
 		}
 	}
-	return err
+	c.mu.Unlock()
+	if !found {
+		sp.end(OutcomeOK, nil, 0)
+		return v, live
+	}
+	c.stats.deletes.Add(1)
+	if c.hasEvictListeners() {
+		c.notifyEvicted([]keyAndValueModel[K, V]{{k, v, hit}})
+	}
+	if c.watching() {
+		evs = append(evs, removeEvent(EventDelete, k, v))
+	}
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
+	return v, live
 }
 
-// Load and add cache items from the given filename, excluding any items with
-// keys that already exist in the current cache.
-//
-// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
-// documentation for NewFrom().)
-func (c *cache[K, V]) LoadFile(fname string) error {
-	fp, err := os.Open(fname)
-	if err != nil {
-		return err
+// Peek returns the unexpired item stored under k, in memory or in the disk
+// tier, without counting a hit or a miss, increasing its hit count or moving
+// it back into memory. Meant for inspecting the cache, e.g. from admin tools.
+func (c *cache[K, V]) Peek(k K) (Item[V], bool) {
+	c.mu.RLock()
+	item, found := c.items[k]
+	disk := c.disk
+	c.mu.RUnlock()
+	if found && !item.Expired() {
+		return item, true
 	}
-	err = c.Load(fp)
-	if err != nil {
-		fp.Close()
-		return err
+	if disk != nil {
+		item, _, found := disk.peek(k)
+		return item, found
 	}
-	return fp.Close()
+	return Item[V]{}, false
 }
 
 // Copies all unexpired items in the cache into a new map and returns it.
@@ -391,7 +687,30 @@ func (c *cache[K, V]) ItemCount() int {
 
 // Delete all items from the cache.
 func (c *cache[K, V]) Flush() {
+	var evs []Event[K, V]
 	c.mu.Lock()
+	if c.watching() {
+		for k, v := range c.items {
+			if !v.Expired() {
+				evs = append(evs, removeEvent(EventEvict, k, v.Value))
+			}
+		}
+	}
+	if c.markDirty != nil {
+		for k := range c.items {
+			c.markDirty(k)
+		}
+	}
+	if c.invalidator != nil {
+		c.invalidator.markFlush()
+	}
+	evs = c.removedAll(evs)
+	c.sized(-len(c.items))
 	c.items = map[K]Item[V]{}
+	if c.disk != nil {
+		c.disk.clear()
+	}
+	c.logWAL(walRecord[K, V]{Op: walFlush})
 	c.mu.Unlock()
+	c.publish(evs)
 }
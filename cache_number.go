@@ -1,17 +1,25 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"runtime"
 	"time"
 )
 
 // newCacheNumberWithJanitor create new cache with janitor
-func newCacheNumberWithJanitor[K comparable, V number](de time.Duration, ci time.Duration, m map[K]Item[V]) *Number[K, V] {
-	c := newCache(de, m)
+func newCacheNumberWithJanitor[K comparable, V number](de time.Duration, ci time.Duration, m map[K]Item[V], opts []Option) *Number[K, V] {
+	o := applyOptions(opts)
+	c := newCache(de, m, o)
+	c.addValue = addNumber[V]
 	C := &Number[K, V]{c}
+	// Restore the items before the janitor can sweep them
+	background := c.start()
 	if ci > 0 {
-		runJanitor(c, ci)
+		runJanitor(c, ci, o.janitor)
+	}
+	if background || ci > 0 {
 		runtime.SetFinalizer(C, stopJanitor)
 	}
 	return C
@@ -26,15 +34,21 @@ type Number[K comparable, V number] struct {
 // possible to increment it by n. To retrieve the incremented value, use one
 // of the specialized methods, e.g. IncrementInt64.
 func (c *Number[K, V]) Increment(k K, n V) error {
+	sp := c.traceKey(OpIncrement, k)
 	c.mu.Lock()
-	v, found := c.items[k]
-	if !found || v.Expired() {
+	v, found, evs := c.lookup(k, nil)
+	if !found {
 		c.mu.Unlock()
-		return fmt.Errorf("Item %v not found", k)
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
 	v.Value = v.Value + n
-	c.items[k] = v
+	evs = c.write(k, v, evs)
+	c.logWAL(walRecord[K, V]{Op: walIncrement, Key: k, Value: n})
 	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
@@ -43,15 +57,21 @@ func (c *Number[K, V]) Increment(k K, n V) error {
 // possible to decrement it by n. To retrieve the decremented value, use one
 // of the specialized methods, e.g. DecrementInt64.
 func (c *Number[K, V]) Decrement(k K, n V) error {
+	sp := c.traceKey(OpDecrement, k)
 	c.mu.Lock()
-	v, found := c.items[k]
-	if !found || v.Expired() {
+	v, found, evs := c.lookup(k, nil)
+	if !found {
 		c.mu.Unlock()
-		return fmt.Errorf("Item %v not found", k)
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
 	v.Value = v.Value - n
-	c.items[k] = v
+	evs = c.write(k, v, evs)
+	c.logWAL(walRecord[K, V]{Op: walDecrement, Key: k, Value: n})
 	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
@@ -59,6 +79,7 @@ func (c *Number[K, V]) Decrement(k K, n V) error {
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
 // (NoExpiration), the item never expires.
 func (c *Number[K, V]) SetMax(k K, v V, d time.Duration) error {
+	sp := c.traceKey(OpSet, k)
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -67,18 +88,21 @@ func (c *Number[K, V]) SetMax(k K, v V, d time.Duration) error {
 		e = time.Now().Add(d).UnixNano()
 	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, found := c.items[k]
-	if !found || item.Expired() {
-		c.items[k] = Item[V]{
+	item, found, evs := c.lookup(k, nil)
+	if !found {
+		item = Item[V]{
 			Value:      v,
 			Expiration: e,
 			Hit:        0,
 		}
-		return nil
+	} else {
+		item.Value = max(item.Value, v)
 	}
-	item.Value = max(item.Value, v)
-	c.items[k] = item
+	evs = c.store(k, item, evs)
+	c.mu.Unlock()
+	c.stats.sets.Add(1)
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
@@ -86,6 +110,7 @@ func (c *Number[K, V]) SetMax(k K, v V, d time.Duration) error {
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
 // (NoExpiration), the item never expires.
 func (c *Number[K, V]) SetMin(k K, v V, d time.Duration) error {
+	sp := c.traceKey(OpSet, k)
 	var e int64
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
@@ -94,43 +119,147 @@ func (c *Number[K, V]) SetMin(k K, v V, d time.Duration) error {
 		e = time.Now().Add(d).UnixNano()
 	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, found := c.items[k]
-	if !found || item.Expired() {
-		c.items[k] = Item[V]{
+	item, found, evs := c.lookup(k, nil)
+	if !found {
+		item = Item[V]{
 			Value:      v,
 			Expiration: e,
 			Hit:        0,
 		}
-		return nil
+	} else {
+		item.Value = min(item.Value, v)
 	}
-	item.Value = min(item.Value, v)
-	c.items[k] = item
+	evs = c.store(k, item, evs)
+	c.mu.Unlock()
+	c.stats.sets.Add(1)
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
+// ErrOverflow is returned by the checked arithmetic when the result doesn't
+// fit into the value type.
+var ErrOverflow = errors.New("cache: arithmetic overflow")
+
+// AddChecked returns a+b, or ErrOverflow if the result wraps around or, for
+// floating point types, becomes infinite.
+func AddChecked[V number](a, b V) (V, error) {
+	r := a + b
+	if (b > 0 && r < a) || (b < 0 && r > a) || overflowedFloat(a, b, r) {
+		return a, ErrOverflow
+	}
+	return r, nil
+}
+
+// SubChecked returns a-b, or ErrOverflow if the result wraps around or, for
+// floating point types, becomes infinite. Subtracting below zero overflows
+// for unsigned types.
+func SubChecked[V number](a, b V) (V, error) {
+	r := a - b
+	if (b > 0 && r > a) || (b < 0 && r < a) || overflowedFloat(a, b, r) {
+		return a, ErrOverflow
+	}
+	return r, nil
+}
+
+// overflowedFloat reports whether r became infinite from finite operands
+func overflowedFloat[V number](a, b, r V) bool {
+	return math.IsInf(float64(r), 0) && !math.IsInf(float64(a), 0) && !math.IsInf(float64(b), 0)
+}
+
+// IncrementChecked Increment an item by n and return the new value. Returns
+// an error if the item was not found, or ErrOverflow, leaving the item
+// unchanged, if the result doesn't fit into V.
+func (c *Number[K, V]) IncrementChecked(k K, n V) (V, error) {
+	return c.addChecked(k, n, false)
+}
+
+// DecrementChecked Decrement an item by n and return the new value. Returns
+// an error if the item was not found, or ErrOverflow, leaving the item
+// unchanged, if the result doesn't fit into V.
+func (c *Number[K, V]) DecrementChecked(k K, n V) (V, error) {
+	return c.addChecked(k, n, true)
+}
+
+func (c *Number[K, V]) addChecked(k K, n V, sub bool) (V, error) {
+	traced := OpIncrement
+	if sub {
+		traced = OpDecrement
+	}
+	sp := c.traceKey(traced, k)
+	c.mu.Lock()
+	v, found, evs := c.lookup(k, nil)
+	if !found {
+		c.mu.Unlock()
+		c.publish(evs)
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return v.Value, err
+	}
+	var err error
+	op := walIncrement
+	if sub {
+		op = walDecrement
+		v.Value, err = SubChecked(v.Value, n)
+	} else {
+		v.Value, err = AddChecked(v.Value, n)
+	}
+	if err != nil {
+		c.mu.Unlock()
+		c.publish(evs)
+		sp.end(OutcomeError, err, 0)
+		return v.Value, err
+	}
+	evs = c.write(k, v, evs)
+	c.logWAL(walRecord[K, V]{Op: op, Key: k, Value: n})
+	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
+	return v.Value, nil
+}
+
 // UpdateMax Update Value to the maximum value.
 func (c *Number[K, V]) UpdateMax(k K, v V) error {
+	sp := c.traceKey(OpUpdate, k)
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, found := c.items[k]
-	if !found || item.Expired() {
-		return fmt.Errorf("Item %v not found", k)
+	item, found, evs := c.lookup(k, nil)
+	if !found {
+		c.mu.Unlock()
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
 	item.Value = max(item.Value, v)
-	c.items[k] = item
+	evs = c.store(k, item, evs)
+	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
 
 // UpdateMin Update Value to the minimum value.
 func (c *Number[K, V]) UpdateMin(k K, v V) error {
+	sp := c.traceKey(OpUpdate, k)
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, found := c.items[k]
-	if !found || item.Expired() {
-		return fmt.Errorf("Item %v not found", k)
+	item, found, evs := c.lookup(k, nil)
+	if !found {
+		c.mu.Unlock()
+		err := fmt.Errorf("Item %v not found", k)
+		sp.end(OutcomeError, err, 0)
+		return err
 	}
 	item.Value = min(item.Value, v)
-	c.items[k] = item
+	evs = c.store(k, item, evs)
+	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
 	return nil
 }
+
+// addNumber returns a+b, or a-b if sub is set
+func addNumber[V number](a, b V, sub bool) V {
+	if sub {
+		return a - b
+	}
+	return a + b
+}
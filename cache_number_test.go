@@ -1,6 +1,10 @@
 package cache
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"testing"
+)
 
 func TestIncrementWithInt(t *testing.T) {
 	tc := NewNumber[string, int](DefaultExpiration, 0)
@@ -549,3 +553,49 @@ func TestUpdateMin(t *testing.T) {
 	}
 	t.Log(tc.Get("int64"))
 }
+
+func TestIncrementChecked(t *testing.T) {
+	tc := NewNumber[string, uint8](DefaultExpiration, 0)
+	if _, err := tc.IncrementChecked("u", 1); err == nil {
+		t.Error("No error incrementing a missing item")
+	}
+	tc.Set("u", 250, DefaultExpiration)
+	v, err := tc.IncrementChecked("u", 5)
+	if err != nil || v != 255 {
+		t.Error("Increment to 255 returned", v, err)
+	}
+	if _, err = tc.IncrementChecked("u", 1); !errors.Is(err, ErrOverflow) {
+		t.Error("Overflow was not detected:", err)
+	}
+	if _, err = tc.DecrementChecked("u", 0); err != nil {
+		t.Error(err)
+	}
+	tc.Set("u", 1, DefaultExpiration)
+	if _, err = tc.DecrementChecked("u", 2); !errors.Is(err, ErrOverflow) {
+		t.Error("Underflow was not detected:", err)
+	}
+	if x, _ := tc.Get("u"); x != 1 {
+		t.Error("Item was changed by a failed decrement:", x)
+	}
+}
+
+func TestAddChecked(t *testing.T) {
+	if _, err := AddChecked[int8](100, 28); err == nil {
+		t.Error("int8 overflow was not detected")
+	}
+	if _, err := AddChecked[int8](-100, -29); err == nil {
+		t.Error("int8 underflow was not detected")
+	}
+	if v, err := AddChecked[int8](-100, 50); err != nil || v != -50 {
+		t.Error("unexpected result", v, err)
+	}
+	if _, err := SubChecked[int64](math.MinInt64, 1); err == nil {
+		t.Error("int64 underflow was not detected")
+	}
+	if _, err := AddChecked(math.MaxFloat64, math.MaxFloat64); err == nil {
+		t.Error("float64 overflow was not detected")
+	}
+	if v, err := AddChecked(math.Inf(1), 1); err != nil || !math.IsInf(v, 1) {
+		t.Error("infinite operand was treated as overflow", v, err)
+	}
+}
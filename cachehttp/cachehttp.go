@@ -0,0 +1,304 @@
+// Package cachehttp serves an HTTP handler for inspecting and managing a
+// cache, meant to be mounted on an internal admin or debug port.
+//
+// All responses are JSON. The handler serves, relative to where it is
+// mounted:
+//
+//	GET    /stats            counters of the cache and its item count
+//	GET    /keys             keys, filtered by ?pattern= (path.Match syntax) and
+//	                         paginated by ?offset= and ?limit=
+//	GET    /keys/{key}       value, hit count and expiration of a key
+//	DELETE /keys/{key}       delete a key
+//	POST   /flush            delete all items
+//	POST   /delete-expired   delete all expired items
+//	POST   /snapshot         save the cache to the path given to WithSnapshotPath
+//
+// In read-only mode the DELETE and POST endpoints answer 403 Forbidden.
+package cachehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// Cache is the part of a cache the handler needs. Any and Number implement
+// it.
+type Cache[K comparable, V any] interface {
+	cache.Cache[K, V]
+	Stats() cache.Stats
+	Peek(k K) (cache.Item[V], bool)
+	Remove(k K) (V, bool)
+}
+
+// Option configures a Handler.
+type Option func(*options)
+
+type options struct {
+	readOnly     bool
+	snapshotPath string
+	maxLimit     int
+}
+
+// WithReadOnly refuses every request that would change the cache.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithSnapshotPath enables POST /snapshot, which saves the cache to path with
+// SaveFile. The cache must have a SaveFile method.
+func WithSnapshotPath(path string) Option {
+	return func(o *options) {
+		o.snapshotPath = path
+	}
+}
+
+// WithMaxLimit caps the number of keys returned per page. The default is
+// 1000, which is also the page size if ?limit= is missing.
+func WithMaxLimit(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxLimit = n
+		}
+	}
+}
+
+// StringKey is the key parser for caches with string keys.
+func StringKey(s string) (string, error) {
+	return s, nil
+}
+
+// Handler is the admin handler of a single cache.
+type Handler[K comparable, V any] struct {
+	c        Cache[K, V]
+	parseKey func(string) (K, error)
+	o        options
+	mux      *http.ServeMux
+}
+
+// NewHandler returns a handler for c. parseKey turns the {key} path segment
+// into a key, use StringKey for string keys. Keys are listed in their fmt.Sprint
+// form.
+func NewHandler[K comparable, V any](c Cache[K, V], parseKey func(string) (K, error), opts ...Option) *Handler[K, V] {
+	h := &Handler[K, V]{
+		c:        c,
+		parseKey: parseKey,
+		o:        options{maxLimit: 1000},
+		mux:      http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(&h.o)
+	}
+	h.mux.HandleFunc("GET /stats", h.stats)
+	h.mux.HandleFunc("GET /keys", h.keys)
+	h.mux.HandleFunc("GET /keys/{key}", h.get)
+	h.mux.HandleFunc("DELETE /keys/{key}", h.write(h.delete))
+	h.mux.HandleFunc("POST /flush", h.write(h.flush))
+	h.mux.HandleFunc("POST /delete-expired", h.write(h.deleteExpired))
+	h.mux.HandleFunc("POST /snapshot", h.write(h.snapshot))
+	return h
+}
+
+func (h *Handler[K, V]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, errorResponse{Error: fmt.Sprintf(format, args...)})
+}
+
+// write wraps the handler of an endpoint that changes the cache
+func (h *Handler[K, V]) write(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.o.readOnly {
+			writeError(w, http.StatusForbidden, "read-only")
+			return
+		}
+		f(w, r)
+	}
+}
+
+// StatsResponse is the response of GET /stats.
+type StatsResponse struct {
+	Hits        uint64  `json:"hits"`
+	Misses      uint64  `json:"misses"`
+	Sets        uint64  `json:"sets"`
+	Deletes     uint64  `json:"deletes"`
+	Expirations uint64  `json:"expirations"`
+	Evictions   uint64  `json:"evictions"`
+	HitRatio    float64 `json:"hit_ratio"`
+	Items       int     `json:"items"`
+}
+
+func (h *Handler[K, V]) stats(w http.ResponseWriter, r *http.Request) {
+	s := h.c.Stats()
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Hits:        s.Hits,
+		Misses:      s.Misses,
+		Sets:        s.Sets,
+		Deletes:     s.Deletes,
+		Expirations: s.Expirations,
+		Evictions:   s.Evictions,
+		HitRatio:    s.HitRatio(),
+		Items:       h.c.ItemCount(),
+	})
+}
+
+// KeysResponse is the response of GET /keys. Total is the number of keys
+// matching the pattern.
+type KeysResponse struct {
+	Keys   []string `json:"keys"`
+	Total  int      `json:"total"`
+	Offset int      `json:"offset"`
+	Limit  int      `json:"limit"`
+}
+
+// intParam returns the query parameter name, def if it is missing
+func intParam(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return n, nil
+}
+
+func (h *Handler[K, V]) keys(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid pattern %q: %v", pattern, err)
+		return
+	}
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	limit, err := intParam(r, "limit", h.o.maxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	limit = min(limit, h.o.maxLimit)
+
+	keys := []string{}
+	for k := range h.c.Items() {
+		s := fmt.Sprint(k)
+		if ok, _ := path.Match(pattern, s); ok {
+			keys = append(keys, s)
+		}
+	}
+	// Sorted so that pages are stable
+	slices.Sort(keys)
+	total := len(keys)
+	offset = min(offset, total)
+	keys = keys[offset : offset+min(limit, total-offset)]
+	writeJSON(w, http.StatusOK, KeysResponse{Keys: keys, Total: total, Offset: offset, Limit: limit})
+}
+
+// ItemResponse is the response of GET /keys/{key}. Values that can't be
+// encoded as JSON are returned in their fmt.Sprint form. Expiration and TTL
+// are omitted for items that never expire.
+type ItemResponse struct {
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Hit        int             `json:"hit"`
+	Expiration *time.Time      `json:"expiration,omitempty"`
+	TTL        float64         `json:"ttl_seconds,omitempty"`
+}
+
+// parse returns the key of the request, or writes an error
+func (h *Handler[K, V]) parse(w http.ResponseWriter, r *http.Request) (K, bool) {
+	s := r.PathValue("key")
+	k, err := h.parseKey(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid key %q: %v", s, err)
+		return k, false
+	}
+	return k, true
+}
+
+func (h *Handler[K, V]) get(w http.ResponseWriter, r *http.Request) {
+	k, ok := h.parse(w, r)
+	if !ok {
+		return
+	}
+	// Peek, so that inspecting doesn't count as a hit or move the item
+	item, found := h.c.Peek(k)
+	if !found {
+		writeError(w, http.StatusNotFound, "key %q not found", r.PathValue("key"))
+		return
+	}
+	value, err := json.Marshal(item.Value)
+	if err != nil {
+		value, _ = json.Marshal(fmt.Sprint(item.Value))
+	}
+	resp := ItemResponse{Key: fmt.Sprint(k), Value: value, Hit: item.Hit}
+	if item.Expiration > 0 {
+		e := time.Unix(0, item.Expiration)
+		resp.Expiration = &e
+		resp.TTL = time.Until(e).Seconds()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler[K, V]) delete(w http.ResponseWriter, r *http.Request) {
+	k, ok := h.parse(w, r)
+	if !ok {
+		return
+	}
+	if _, found := h.c.Remove(k); !found {
+		writeError(w, http.StatusNotFound, "key %q not found", r.PathValue("key"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+func (h *Handler[K, V]) flush(w http.ResponseWriter, r *http.Request) {
+	n := h.c.ItemCount()
+	h.c.Flush()
+	writeJSON(w, http.StatusOK, map[string]int{"removed": n})
+}
+
+func (h *Handler[K, V]) deleteExpired(w http.ResponseWriter, r *http.Request) {
+	n := h.c.ItemCount()
+	h.c.DeleteExpired()
+	writeJSON(w, http.StatusOK, map[string]int{"removed": max(n-h.c.ItemCount(), 0)})
+}
+
+func (h *Handler[K, V]) snapshot(w http.ResponseWriter, r *http.Request) {
+	saver, ok := h.c.(interface{ SaveFile(string) error })
+	if h.o.snapshotPath == "" || !ok {
+		writeError(w, http.StatusNotImplemented, "snapshots are not configured")
+		return
+	}
+	if err := saver.SaveFile(h.o.snapshotPath); err != nil {
+		writeError(w, http.StatusInternalServerError, "saving snapshot: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"path": h.o.snapshotPath})
+}
@@ -0,0 +1,133 @@
+package cachehttp
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+func do(t *testing.T, h http.Handler, method, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type is %q", method, target, ct)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, target, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	tc := cache.New[string, any](cache.NoExpiration, 0)
+	for i := 0; i < 25; i++ {
+		tc.Set("user:"+strconv.Itoa(i), i, cache.DefaultExpiration)
+	}
+	tc.Set("session", map[string]string{"id": "x"}, time.Hour)
+	tc.Get("session")
+	tc.Get("missing")
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	h := NewHandler(tc, StringKey, WithSnapshotPath(fname), WithMaxLimit(10))
+
+	var stats StatsResponse
+	if code := do(t, h, "GET", "/stats", &stats); code != http.StatusOK {
+		t.Fatal("GET /stats:", code)
+	}
+	if stats.Items != 26 || stats.Hits != 1 || stats.Misses != 1 || stats.HitRatio != 0.5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	var keys KeysResponse
+	do(t, h, "GET", "/keys?pattern=user:*&offset=20", &keys)
+	if keys.Total != 25 || len(keys.Keys) != 5 || keys.Limit != 10 {
+		t.Errorf("unexpected page %+v", keys)
+	}
+	do(t, h, "GET", "/keys?pattern=user:1?&limit=3", &keys)
+	if keys.Total != 10 || strings.Join(keys.Keys, ",") != "user:10,user:11,user:12" {
+		t.Errorf("unexpected page %+v", keys)
+	}
+	if code := do(t, h, "GET", "/keys?pattern=[", nil); code != http.StatusBadRequest {
+		t.Error("invalid pattern:", code)
+	}
+	keys = KeysResponse{}
+	if code := do(t, h, "GET", "/keys?offset="+strconv.Itoa(math.MaxInt), &keys); code != http.StatusOK || len(keys.Keys) != 0 {
+		t.Errorf("offset past the end: %d %+v", code, keys)
+	}
+
+	var item ItemResponse
+	if code := do(t, h, "GET", "/keys/session", &item); code != http.StatusOK {
+		t.Fatal("GET /keys/session:", code)
+	}
+	if string(item.Value) != `{"id":"x"}` || item.Expiration == nil || item.TTL <= 0 || item.TTL > 3600 {
+		t.Errorf("unexpected item %+v", item)
+	}
+	item = ItemResponse{}
+	do(t, h, "GET", "/keys/user:3", &item)
+	if string(item.Value) != "3" || item.Expiration != nil {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if code := do(t, h, "GET", "/keys/missing", nil); code != http.StatusNotFound {
+		t.Error("GET of a missing key:", code)
+	}
+
+	if code := do(t, h, "DELETE", "/keys/user:3", nil); code != http.StatusOK {
+		t.Error("DELETE:", code)
+	}
+	if code := do(t, h, "DELETE", "/keys/user:3", nil); code != http.StatusNotFound {
+		t.Error("DELETE of a deleted key:", code)
+	}
+	if s := tc.Stats(); s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("admin requests counted in stats %+v", s)
+	}
+	if _, found := tc.Get("user:3"); found {
+		t.Error("key was not deleted")
+	}
+	if code := do(t, h, "POST", "/snapshot", nil); code != http.StatusOK {
+		t.Error("POST /snapshot:", code)
+	}
+	if info, err := cache.ReadSnapshotInfo(fname); err != nil || info.Count != 25 {
+		t.Error("unexpected snapshot:", info, err)
+	}
+	var removed map[string]int
+	do(t, h, "POST", "/delete-expired", &removed)
+	if removed["removed"] != 0 {
+		t.Error("unexpected delete-expired response", removed)
+	}
+	do(t, h, "POST", "/flush", &removed)
+	if removed["removed"] != 25 || tc.ItemCount() != 0 {
+		t.Error("unexpected flush response", removed)
+	}
+}
+
+func TestHandlerReadOnly(t *testing.T) {
+	tc := cache.NewNumber[int, int](cache.NoExpiration, 0)
+	tc.Set(1, 10, cache.DefaultExpiration)
+	h := NewHandler(tc, strconv.Atoi, WithReadOnly())
+
+	var item ItemResponse
+	if code := do(t, h, "GET", "/keys/1", &item); code != http.StatusOK || string(item.Value) != "10" {
+		t.Errorf("GET /keys/1: %d %+v", code, item)
+	}
+	if code := do(t, h, "GET", "/keys/x", nil); code != http.StatusBadRequest {
+		t.Error("unparsable key:", code)
+	}
+	for _, req := range [][2]string{{"DELETE", "/keys/1"}, {"POST", "/flush"}, {"POST", "/delete-expired"}, {"POST", "/snapshot"}} {
+		if code := do(t, h, req[0], req[1], nil); code != http.StatusForbidden {
+			t.Errorf("%s %s in read-only mode: %d", req[0], req[1], code)
+		}
+	}
+	if tc.ItemCount() != 1 {
+		t.Error("read-only handler changed the cache")
+	}
+}
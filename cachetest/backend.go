@@ -0,0 +1,88 @@
+package cachetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// MemoryBackend is a cache.Backend keeping its items in memory, a stand-in
+// for a remote store such as Redis in tests of cache.Tiered. Fail makes
+// every call fail until it is cleared. It is safe for concurrent use.
+type MemoryBackend[K comparable, V any] struct {
+	mu    sync.Mutex
+	items map[K]cache.Item[V]
+	err   error
+	ops   int
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend[K comparable, V any]() *MemoryBackend[K, V] {
+	return &MemoryBackend[K, V]{items: map[K]cache.Item[V]{}}
+}
+
+// Get implements cache.Backend.
+func (b *MemoryBackend[K, V]) Get(k K) (cache.Item[V], bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ops++
+	if b.err != nil {
+		return cache.Item[V]{}, false, b.err
+	}
+	item, found := b.items[k]
+	if !found || item.Expired() {
+		return cache.Item[V]{}, false, nil
+	}
+	return item, true, nil
+}
+
+// Set implements cache.Backend.
+func (b *MemoryBackend[K, V]) Set(k K, v V, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ops++
+	if b.err != nil {
+		return b.err
+	}
+	var e int64
+	if ttl > 0 {
+		e = time.Now().Add(ttl).UnixNano()
+	}
+	b.items[k] = cache.Item[V]{Value: v, Expiration: e}
+	return nil
+}
+
+// Delete implements cache.Backend.
+func (b *MemoryBackend[K, V]) Delete(k K) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ops++
+	if b.err != nil {
+		return b.err
+	}
+	delete(b.items, k)
+	return nil
+}
+
+// Fail makes every following call return err, or succeed again if err is
+// nil.
+func (b *MemoryBackend[K, V]) Fail(err error) {
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
+
+// Calls returns the number of Get, Set and Delete calls so far.
+func (b *MemoryBackend[K, V]) Calls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ops
+}
+
+// Len returns the number of items stored, including expired items.
+func (b *MemoryBackend[K, V]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.items)
+}
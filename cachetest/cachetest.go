@@ -0,0 +1,293 @@
+// Package cachetest checks implementations of cache.Cache against the
+// behaviour of the caches in package cache.
+//
+// cache.Tiered is not checked: its Get, Set and Delete return the errors of
+// the second tier, and it has no Add, Replace or Items, so it does not
+// implement cache.Cache. MemoryBackend stands in for its second tier in
+// tests.
+package cachetest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// Factory returns a new, empty cache using defaultExpiration for
+// cache.DefaultExpiration. If the cache has a Close method it is called when
+// the test ends.
+type Factory[K comparable, V any] func(defaultExpiration time.Duration) cache.Cache[K, V]
+
+// Run runs the conformance tests as subtests of t. key and value return
+// distinct keys and values for distinct i.
+func Run[K comparable, V any](t *testing.T, newCache Factory[K, V], key func(i int) K, value func(i int) V) {
+	s := &suite[K, V]{newCache: newCache, key: key, value: value}
+	tests := []struct {
+		name string
+		f    func(t *testing.T)
+	}{
+		{"GetMissing", s.testGetMissing},
+		{"SetGet", s.testSetGet},
+		{"SetDefault", s.testSetDefault},
+		{"Expiration", s.testExpiration},
+		{"GetWithExpiration", s.testGetWithExpiration},
+		{"Add", s.testAdd},
+		{"Replace", s.testReplace},
+		{"UpdateExpiration", s.testUpdateExpiration},
+		{"Delete", s.testDelete},
+		{"DeleteExpired", s.testDeleteExpired},
+		{"Items", s.testItems},
+		{"Flush", s.testFlush},
+		{"Concurrent", s.testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, tt.f)
+	}
+}
+
+// expireAfter is the lifetime of items that are meant to expire during a test
+const expireAfter = 10 * time.Millisecond
+
+type suite[K comparable, V any] struct {
+	newCache Factory[K, V]
+	key      func(int) K
+	value    func(int) V
+}
+
+func (s *suite[K, V]) new(t *testing.T, defaultExpiration time.Duration) cache.Cache[K, V] {
+	c := s.newCache(defaultExpiration)
+	if closer, ok := c.(interface{ Close() }); ok {
+		t.Cleanup(closer.Close)
+	}
+	return c
+}
+
+// expectValue fails t unless the item of k is v
+func (s *suite[K, V]) expectValue(t *testing.T, c cache.Cache[K, V], k K, v V) {
+	t.Helper()
+	got, found := c.Get(k)
+	if !found {
+		t.Errorf("%v was not found", k)
+		return
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("%v is %v, want %v", k, got, v)
+	}
+}
+
+// expectMissing fails t if k is found
+func (s *suite[K, V]) expectMissing(t *testing.T, c cache.Cache[K, V], k K) {
+	t.Helper()
+	if got, found := c.Get(k); found {
+		t.Errorf("%v was found with %v", k, got)
+	}
+}
+
+// wait lets items set with expireAfter expire
+func wait() {
+	time.Sleep(2 * expireAfter)
+}
+
+func (s *suite[K, V]) testGetMissing(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	s.expectMissing(t, c, s.key(0))
+	if _, e, found := c.GetWithExpiration(s.key(0)); found || !e.IsZero() {
+		t.Error("GetWithExpiration found a missing key")
+	}
+}
+
+func (s *suite[K, V]) testSetGet(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	c.Set(s.key(0), s.value(0), cache.DefaultExpiration)
+	c.Set(s.key(1), s.value(1), cache.NoExpiration)
+	s.expectValue(t, c, s.key(0), s.value(0))
+	s.expectValue(t, c, s.key(1), s.value(1))
+	c.Set(s.key(0), s.value(2), cache.DefaultExpiration)
+	s.expectValue(t, c, s.key(0), s.value(2))
+	if n := c.ItemCount(); n != 2 {
+		t.Error("ItemCount is not 2:", n)
+	}
+}
+
+func (s *suite[K, V]) testSetDefault(t *testing.T) {
+	c := s.new(t, expireAfter)
+	c.SetDefault(s.key(0), s.value(0))
+	c.Set(s.key(1), s.value(1), cache.NoExpiration)
+	s.expectValue(t, c, s.key(0), s.value(0))
+	wait()
+	s.expectMissing(t, c, s.key(0))
+	s.expectValue(t, c, s.key(1), s.value(1))
+}
+
+func (s *suite[K, V]) testExpiration(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	c.Set(s.key(0), s.value(0), expireAfter)
+	c.Set(s.key(1), s.value(1), time.Hour)
+	c.Set(s.key(2), s.value(2), cache.DefaultExpiration)
+	wait()
+	s.expectMissing(t, c, s.key(0))
+	s.expectValue(t, c, s.key(1), s.value(1))
+	s.expectValue(t, c, s.key(2), s.value(2))
+}
+
+func (s *suite[K, V]) testGetWithExpiration(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	before := time.Now()
+	c.Set(s.key(0), s.value(0), time.Hour)
+	c.Set(s.key(1), s.value(1), cache.NoExpiration)
+	v, e, found := c.GetWithExpiration(s.key(0))
+	if !found || !reflect.DeepEqual(v, s.value(0)) {
+		t.Errorf("%v is %v, %v", s.key(0), v, found)
+	}
+	if e.Before(before.Add(time.Hour)) || e.After(time.Now().Add(time.Hour)) {
+		t.Error("unexpected expiration time:", e)
+	}
+	if _, e, found = c.GetWithExpiration(s.key(1)); !found || !e.IsZero() {
+		t.Errorf("item without expiration returned %v, %v", e, found)
+	}
+}
+
+func (s *suite[K, V]) testAdd(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	if err := c.Add(s.key(0), s.value(0), cache.DefaultExpiration); err != nil {
+		t.Fatal("Add of a new key failed:", err)
+	}
+	if err := c.Add(s.key(0), s.value(1), cache.DefaultExpiration); err == nil {
+		t.Error("Add of an existing key didn't fail")
+	}
+	s.expectValue(t, c, s.key(0), s.value(0))
+	c.Set(s.key(1), s.value(1), expireAfter)
+	wait()
+	if err := c.Add(s.key(1), s.value(2), cache.DefaultExpiration); err != nil {
+		t.Error("Add over an expired item failed:", err)
+	}
+	s.expectValue(t, c, s.key(1), s.value(2))
+}
+
+func (s *suite[K, V]) testReplace(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	if err := c.Replace(s.key(0), s.value(0), cache.DefaultExpiration); err == nil {
+		t.Error("Replace of a missing key didn't fail")
+	}
+	s.expectMissing(t, c, s.key(0))
+	c.Set(s.key(0), s.value(0), cache.DefaultExpiration)
+	if err := c.Replace(s.key(0), s.value(1), cache.DefaultExpiration); err != nil {
+		t.Error("Replace of an existing key failed:", err)
+	}
+	s.expectValue(t, c, s.key(0), s.value(1))
+	c.Set(s.key(1), s.value(1), expireAfter)
+	wait()
+	if err := c.Replace(s.key(1), s.value(2), cache.DefaultExpiration); err == nil {
+		t.Error("Replace of an expired item didn't fail")
+	}
+}
+
+func (s *suite[K, V]) testUpdateExpiration(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	if err := c.UpdateExpiration(s.key(0), time.Hour); err == nil {
+		t.Error("UpdateExpiration of a missing key didn't fail")
+	}
+	c.Set(s.key(0), s.value(0), cache.NoExpiration)
+	if err := c.UpdateExpiration(s.key(0), expireAfter); err != nil {
+		t.Fatal(err)
+	}
+	if _, e, _ := c.GetWithExpiration(s.key(0)); e.IsZero() {
+		t.Error("expiration was not set")
+	}
+	wait()
+	s.expectMissing(t, c, s.key(0))
+
+	c.Set(s.key(1), s.value(1), expireAfter)
+	if err := c.UpdateExpiration(s.key(1), cache.NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	s.expectValue(t, c, s.key(1), s.value(1))
+}
+
+func (s *suite[K, V]) testDelete(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	c.Set(s.key(0), s.value(0), cache.DefaultExpiration)
+	c.Delete(s.key(0))
+	c.Delete(s.key(1))
+	s.expectMissing(t, c, s.key(0))
+	if n := c.ItemCount(); n != 0 {
+		t.Error("ItemCount is not 0:", n)
+	}
+}
+
+func (s *suite[K, V]) testDeleteExpired(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	c.Set(s.key(0), s.value(0), expireAfter)
+	c.Set(s.key(1), s.value(1), cache.DefaultExpiration)
+	wait()
+	c.DeleteExpired()
+	if n := c.ItemCount(); n != 1 {
+		t.Error("ItemCount is not 1 after DeleteExpired:", n)
+	}
+	s.expectValue(t, c, s.key(1), s.value(1))
+}
+
+func (s *suite[K, V]) testItems(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	c.Set(s.key(0), s.value(0), cache.DefaultExpiration)
+	c.Set(s.key(1), s.value(1), time.Hour)
+	c.Set(s.key(2), s.value(2), expireAfter)
+	wait()
+	items := c.Items()
+	if len(items) != 2 {
+		t.Fatal("expected 2 unexpired items, got", len(items))
+	}
+	if item := items[s.key(0)]; !reflect.DeepEqual(item.Value, s.value(0)) || item.Expiration != 0 {
+		t.Errorf("unexpected item %v", item)
+	}
+	if item := items[s.key(1)]; !reflect.DeepEqual(item.Value, s.value(1)) || item.Expiration == 0 {
+		t.Errorf("unexpected item %v", item)
+	}
+	delete(items, s.key(0))
+	s.expectValue(t, c, s.key(0), s.value(0))
+}
+
+func (s *suite[K, V]) testFlush(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	for i := 0; i < 10; i++ {
+		c.Set(s.key(i), s.value(i), cache.DefaultExpiration)
+	}
+	c.Flush()
+	if n := c.ItemCount(); n != 0 {
+		t.Error("ItemCount is not 0 after Flush:", n)
+	}
+	s.expectMissing(t, c, s.key(0))
+	c.Set(s.key(0), s.value(0), cache.DefaultExpiration)
+	s.expectValue(t, c, s.key(0), s.value(0))
+}
+
+func (s *suite[K, V]) testConcurrent(t *testing.T) {
+	c := s.new(t, cache.NoExpiration)
+	const workers, n = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				k := s.key(w*n + i)
+				c.Set(k, s.value(i), cache.DefaultExpiration)
+				if _, found := c.Get(k); !found {
+					t.Errorf("%v not found right after Set", k)
+					return
+				}
+				if i%2 == 0 {
+					c.Delete(k)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if got := c.ItemCount(); got != workers*n/2 {
+		t.Errorf("ItemCount is %d, want %d", got, workers*n/2)
+	}
+}
@@ -0,0 +1,29 @@
+package cachetest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+func TestAny(t *testing.T) {
+	Run(t, func(d time.Duration) cache.Cache[string, []byte] {
+		return cache.New[string, []byte](d, 0)
+	}, strconv.Itoa, func(i int) []byte {
+		return []byte("value " + strconv.Itoa(i))
+	})
+}
+
+func TestNumber(t *testing.T) {
+	Run(t, func(d time.Duration) cache.Cache[int, float64] {
+		return cache.NewNumber[int, float64](d, 0)
+	}, func(i int) int { return i }, func(i int) float64 { return float64(i) / 2 })
+}
+
+func TestCapacity(t *testing.T) {
+	Run(t, func(d time.Duration) cache.Cache[string, int] {
+		return cache.New[string, int](d, time.Millisecond, cache.WithCapacity(10000))
+	}, strconv.Itoa, func(i int) int { return i })
+}
@@ -0,0 +1,91 @@
+package cache
+
+import "time"
+
+// callback is a callback of SetWithCallback. Events carry a pointer to it,
+// which keeps them comparable.
+type callback[K comparable, V any] struct {
+	f func(key K, value V)
+}
+
+// SetWithCallback Add an item to the cache like Set, and call f with the key
+// and the value it holds at that time when the item is removed: when it
+// expires and is deleted by the janitor, DeleteExpired or the timer of
+// WithPreciseExpiration, by Delete or Flush, to stay within the capacity, or
+// when it is written again after expiring.
+//
+// The callback belongs to the key, not to the value: Set, Increment, Update
+// and the like keep it while the item is alive, and it is called at most
+// once. Calling SetWithCallback again replaces it; a nil f removes it.
+// Items with a callback are never moved to the disk tier, and callbacks are
+// neither persisted nor replicated. Items restored from the write-ahead log
+// or a snapshot, or added by Load, have no callback.
+//
+// f is called without holding the cache lock, after the OnEvicted
+// listeners. With WithAsyncEviction both run on the eviction workers, and f
+// may run before or concurrently with the listeners.
+func (c *cache[K, V]) SetWithCallback(k K, v V, d time.Duration, f func(key K, value V)) {
+	sp := c.traceKey(OpSet, k)
+	c.mu.Lock()
+	evs := c.set(k, v, d)
+	if f != nil {
+		if c.callbacks == nil {
+			c.callbacks = map[K]*callback[K, V]{}
+		}
+		c.callbacks[k] = &callback[K, V]{f}
+	} else {
+		delete(c.callbacks, k)
+	}
+	c.mu.Unlock()
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, 1)
+}
+
+// removed appends the callback registered for k, if any, to evs. publish
+// calls it with v. c.mu must be held.
+func (c *cache[K, V]) removed(evs []Event[K, V], k K, v V) []Event[K, V] {
+	if len(c.callbacks) == 0 {
+		return evs
+	}
+	f, found := c.callbacks[k]
+	if !found {
+		return evs
+	}
+	delete(c.callbacks, k)
+	return append(evs, Event[K, V]{Key: k, OldValue: v, callback: f})
+}
+
+// removedAll is removed for every item. c.mu must be held.
+func (c *cache[K, V]) removedAll(evs []Event[K, V]) []Event[K, V] {
+	for k, f := range c.callbacks {
+		evs = append(evs, Event[K, V]{Key: k, OldValue: c.items[k].Value, callback: f})
+	}
+	c.callbacks = nil
+	return evs
+}
+
+// runCallbacks calls the callbacks carried by evs and returns the events that
+// remain to be published. Must be called without holding c.mu.
+func (c *cache[K, V]) runCallbacks(evs []Event[K, V]) []Event[K, V] {
+	var calls []Event[K, V]
+	out := evs[:0]
+	for _, ev := range evs {
+		if ev.callback != nil {
+			calls = append(calls, ev)
+			continue
+		}
+		out = append(out, ev)
+	}
+	if len(calls) == 0 {
+		return evs
+	}
+	run := func() {
+		for _, ev := range calls {
+			c.evicted.callback(ev.callback.f, ev.Key, ev.OldValue)
+		}
+	}
+	if c.evicted.pool == nil || !c.evicted.pool.submit(run) {
+		run()
+	}
+	return out
+}
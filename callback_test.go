@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type calls struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (c *calls) f(k string, v int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]int{}
+	}
+	c.m[k] = v
+}
+
+func (c *calls) get(k string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+
+func (c *calls) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.m)
+}
+
+func TestSetWithCallback(t *testing.T) {
+	var got calls
+	tc := NewNumber[string, int](DefaultExpiration, 0)
+	tc.SetWithCallback("expires", 1, time.Millisecond, got.f)
+	tc.SetWithCallback("deleted", 2, DefaultExpiration, got.f)
+	tc.SetWithCallback("kept", 3, DefaultExpiration, got.f)
+	tc.SetWithCallback("removed", 4, DefaultExpiration, got.f)
+	tc.SetWithCallback("removed", 4, DefaultExpiration, nil)
+	tc.Set("plain", 5, DefaultExpiration)
+
+	tc.Increment("kept", 1)
+	tc.Set("kept", 10, DefaultExpiration)
+	tc.Delete("deleted")
+	tc.Delete("removed")
+	time.Sleep(5 * time.Millisecond)
+	tc.DeleteExpired()
+	if v, ok := got.get("expires"); !ok || v != 1 {
+		t.Error("callback of expired item:", v, ok)
+	}
+	if v, ok := got.get("deleted"); !ok || v != 2 {
+		t.Error("callback of deleted item:", v, ok)
+	}
+	if got.len() != 2 {
+		t.Error("unexpected callbacks:", got.m)
+	}
+
+	tc.Flush()
+	if v, ok := got.get("kept"); !ok || v != 10 {
+		t.Error("callback kept across updates not called by Flush:", v, ok)
+	}
+	tc.Set("kept", 1, DefaultExpiration)
+	tc.Delete("kept")
+	if got.len() != 3 {
+		t.Error("unexpected callbacks:", got.m)
+	}
+}
+
+func TestSetWithCallbackOverwriteExpired(t *testing.T) {
+	var got calls
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.SetWithCallback("a", 1, time.Millisecond, got.f)
+	time.Sleep(5 * time.Millisecond)
+	tc.Set("a", 2, DefaultExpiration)
+	if v, ok := got.get("a"); !ok || v != 1 {
+		t.Error("callback not called when overwriting an expired item:", v, ok)
+	}
+	tc.Delete("a")
+	if got.len() != 1 {
+		t.Error("callback called twice")
+	}
+}
+
+func TestSetWithCallbackCapacity(t *testing.T) {
+	var got calls
+	tc := New[string, int](DefaultExpiration, 0, WithCapacity(1))
+	tc.SetWithCallback("a", 1, time.Hour, got.f)
+	tc.Set("b", 2, DefaultExpiration)
+	if v, ok := got.get("a"); !ok || v != 1 {
+		t.Error("callback not called on eviction:", v, ok)
+	}
+}
+
+func TestSetWithCallbackPanic(t *testing.T) {
+	var recovered any
+	tc := New[string, int](DefaultExpiration, 0, WithPanicHandler(func(x any) { recovered = x }))
+	tc.SetWithCallback("a", 1, DefaultExpiration, func(string, int) { panic("boom") })
+	tc.Delete("a")
+	if recovered != "boom" {
+		t.Error("panic not recovered:", recovered)
+	}
+}
+
+func TestSetWithCallbackFlush(t *testing.T) {
+	var got calls
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.SetWithCallback("a", 1, DefaultExpiration, got.f)
+	tc.SetWithCallback("b", 2, DefaultExpiration, got.f)
+	tc.Flush()
+	tc.Flush()
+	if got.len() != 2 {
+		t.Error("unexpected callbacks:", got.m)
+	}
+	if v, _ := got.get("b"); v != 2 {
+		t.Error("callback of b called with", v)
+	}
+}
+
+func TestSetWithCallbackInvalidation(t *testing.T) {
+	var got calls
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.SetWithCallback("a", 1, DefaultExpiration, got.f)
+	tc.SetWithCallback("b", 2, DefaultExpiration, got.f)
+	tc.drop("a")
+	tc.drop("a")
+	if v, ok := got.get("a"); !ok || v != 1 || got.len() != 1 {
+		t.Error("callback not called once by drop:", got.m)
+	}
+	tc.dropAll()
+	if v, ok := got.get("b"); !ok || v != 2 || got.len() != 2 {
+		t.Error("callback not called once by dropAll:", got.m)
+	}
+}
+
+func TestSetWithCallbackNamespaceEviction(t *testing.T) {
+	done := make(chan string, 10)
+	tc := New[string, int](NoExpiration, 0, WithCapacity(2))
+	ns := tc.Namespace("ns", DefaultExpiration)
+	tc.SetWithCallback("a", 1, DefaultExpiration, func(k string, v int) { done <- k })
+	tc.SetWithCallback("b", 2, DefaultExpiration, func(k string, v int) { done <- k })
+	// The parent holds more items, so it gives one up for the namespace
+	ns.Set("c", 3, DefaultExpiration)
+	select {
+	case k := <-done:
+		if k != "a" && k != "b" {
+			t.Error("callback of", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not called for an item evicted by a namespace")
+	}
+	if tc.ItemCount() != 1 || ns.ItemCount() != 1 {
+		t.Error("unexpected item counts:", tc.ItemCount(), ns.ItemCount())
+	}
+	select {
+	case k := <-done:
+		t.Error("unexpected callback of", k)
+	default:
+	}
+}
+
+func TestSetWithCallbackWriteBehind(t *testing.T) {
+	var got calls
+	tc := NewNumber[string, int](NoExpiration, 0)
+	sink := &testSink{}
+	tc.SetSink(sink, WithSinkInterval(time.Hour))
+	defer tc.Close()
+	tc.SetWithCallback("a", 1, DefaultExpiration, got.f)
+	tc.Increment("a", 1)
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	if got.len() != 0 {
+		t.Error("callback called by Increment or the sink:", got.m)
+	}
+	tc.Delete("a")
+	if v, ok := got.get("a"); !ok || v != 2 {
+		t.Error("callback not called by Delete:", v, ok)
+	}
+}
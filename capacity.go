@@ -0,0 +1,112 @@
+package cache
+
+import "time"
+
+// evictSamples is the number of items compared to pick the item evicted when
+// the cache is full
+const evictSamples = 5
+
+// makeRoom evicts an item if the cache holds as many items as its capacity,
+// before a new key is added. c.mu must be held.
+func (c *cache[K, V]) makeRoom(evs []Event[K, V]) []Event[K, V] {
+	if c.budget != nil {
+		return c.makeBudgetRoom(evs)
+	}
+	if c.capacity <= 0 || len(c.items) < c.capacity {
+		return evs
+	}
+	return c.evict(evs)
+}
+
+// evict removes an item to make room. An expired item is preferred,
+// otherwise the sampled item that expires first. The evicted item is moved to
+// the disk tier if there is one, otherwise it is reported as EventEvict and
+// to the OnEvicted listeners. c.mu must be held.
+func (c *cache[K, V]) evict(evs []Event[K, V]) []Event[K, V] {
+	k, item := c.victim()
+	delete(c.items, k)
+	c.sized(-1)
+	c.logWAL(walRecord[K, V]{Op: walDelete, Key: k})
+	if item.Expired() {
+		c.stats.expirations.Add(1)
+		c.dirty(k)
+		return c.dropped(c.removed(evs, k, item.Value), EventExpire, k, item)
+	}
+	c.stats.evictions.Add(1)
+	if _, ok := c.callbacks[k]; !ok && c.disk != nil && c.disk.put(k, item) == nil {
+		return evs
+	}
+	c.dirty(k)
+	evs = c.removed(evs, k, item.Value)
+	return c.dropped(evs, EventEvict, k, item)
+}
+
+// dirty passes k to markDirty if it is set. c.mu must be held.
+func (c *cache[K, V]) dirty(k K) {
+	if c.markDirty != nil {
+		c.markDirty(k)
+	}
+}
+
+// victim picks the item to evict among evictSamples items. Map iteration
+// starts at a random position, which makes this a random sample.
+func (c *cache[K, V]) victim() (K, Item[V]) {
+	var vk K
+	var vi Item[V]
+	n := 0
+	now := time.Now().UnixNano()
+	for k, v := range c.items {
+		// "Inlining" of Expired
+		if v.Expiration > 0 && now > v.Expiration {
+			return k, v
+		}
+		if n == 0 || (v.Expiration > 0 && (vi.Expiration == 0 || v.Expiration < vi.Expiration)) {
+			vk, vi = k, v
+		}
+		n++
+		if n == evictSamples {
+			break
+		}
+	}
+	return vk, vi
+}
+
+// dropped appends the event for an item removed to make room if anybody
+// needs it. publish hands it to the OnEvicted listeners.
+func (c *cache[K, V]) dropped(evs []Event[K, V], t EventType, k K, item Item[V]) []Event[K, V] {
+	if !c.watching() && !c.hasEvictListeners() {
+		return evs
+	}
+	ev := removeEvent(t, k, item.Value)
+	ev.dropped = true
+	ev.hit = item.Hit
+	return append(evs, ev)
+}
+
+// reportDropped passes the items removed to make room to the OnEvicted
+// listeners and returns the events that remain to be published. Must be
+// called without holding c.mu.
+func (c *cache[K, V]) reportDropped(evs []Event[K, V]) []Event[K, V] {
+	var items []keyAndValueModel[K, V]
+	for _, ev := range evs {
+		if ev.dropped {
+			items = append(items, keyAndValueModel[K, V]{ev.Key, ev.OldValue, ev.hit})
+		}
+	}
+	if len(items) == 0 {
+		return evs
+	}
+	c.notifyEvicted(items)
+	watching := c.watching()
+	out := evs[:0]
+	for _, ev := range evs {
+		if ev.dropped {
+			if !watching {
+				continue
+			}
+			ev.dropped, ev.hit = false, 0
+		}
+		out = append(out, ev)
+	}
+	return out
+}
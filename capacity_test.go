@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithCapacity(t *testing.T) {
+	tc := New[string, int](NoExpiration, 0, WithCapacity(3))
+	var evicted []string
+	tc.OnEvicted(func(k string, v int, hit int) {
+		evicted = append(evicted, k)
+	})
+	ch := tc.Subscribe(func(ev Event[string, int]) bool { return ev.Type == EventEvict }, 4, OverflowDrop)
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Hour)
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Set("c", 4, DefaultExpiration) // replacing doesn't evict
+	if len(evicted) != 0 {
+		t.Fatal("evicted before reaching capacity:", evicted)
+	}
+	tc.Set("d", 4, DefaultExpiration)
+	if n := tc.ItemCount(); n != 3 {
+		t.Error("ItemCount is not 3:", n)
+	}
+	// b is the only item that expires, so it goes first
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("expected b to be evicted, got", evicted)
+	}
+	if ev := <-ch; ev.Type != EventEvict || ev.Key != "b" || ev.OldValue != 2 || ev.dropped {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if s := tc.Stats(); s.Evictions != 1 {
+		t.Error("Evictions is not 1:", s.Evictions)
+	}
+}
+
+func TestWithCapacityPrefersExpired(t *testing.T) {
+	tc := New[string, int](NoExpiration, 0, WithCapacity(2))
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("expired", 2, time.Millisecond)
+	<-time.After(5 * time.Millisecond)
+	tc.Set("b", 3, DefaultExpiration)
+	if _, found := tc.Get("a"); !found {
+		t.Error("a was evicted instead of the expired item")
+	}
+	s := tc.Stats()
+	if s.Evictions != 0 || s.Expirations != 1 {
+		t.Errorf("expected 0 evictions and 1 expiration, got %d and %d", s.Evictions, s.Expirations)
+	}
+}
@@ -0,0 +1,398 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+type testPeer struct {
+	srv   *httptest.Server
+	node  *Node
+	group *Group[string]
+	loads *atomic.Int64
+}
+
+// countingTransport counts the fetches of a node
+type countingTransport struct {
+	Transport
+	fetches atomic.Int64
+}
+
+func (t *countingTransport) Fetch(ctx context.Context, peer, group, key string) ([]byte, error) {
+	t.fetches.Add(1)
+	return t.Transport.Fetch(ctx, peer, group, key)
+}
+
+// startCluster runs n peers on localhost, all loading "value of <key>" and
+// counting their loads in loads
+func startCluster(t *testing.T, n int, loads *atomic.Int64, opts ...GroupOption) []*testPeer {
+	t.Helper()
+	peers := make([]*testPeer, n)
+	var urls []string
+	for i := range peers {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		node := NewNode(srv.URL, WithTransport(&countingTransport{Transport: &HTTPTransport{}}))
+		mux.Handle(DefaultBasePath, node)
+		g := NewGroup(node, "test", func(ctx context.Context, key string) (string, time.Duration, error) {
+			loads.Add(1)
+			if strings.HasPrefix(key, "fail") {
+				return "", 0, errors.New("no such key")
+			}
+			return "value of " + key, time.Hour, nil
+		}, opts...)
+		t.Cleanup(g.Close)
+		peers[i] = &testPeer{srv: srv, node: node, group: g, loads: loads}
+		urls = append(urls, srv.URL)
+	}
+	for _, p := range peers {
+		p.node.SetPeers(urls...)
+	}
+	return peers
+}
+
+func TestClusterGet(t *testing.T) {
+	var loads atomic.Int64
+	peers := startCluster(t, 3, &loads)
+	ctx := context.Background()
+	owned := map[string]int{}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint("key", i)
+		for _, p := range peers {
+			v, err := p.group.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != "value of "+key {
+				t.Errorf("%s: got %q", key, v)
+			}
+		}
+		owned[peers[0].node.Owner(key)]++
+	}
+	// Every key was loaded once, by its owner
+	if loads.Load() != 30 {
+		t.Error("loads:", loads.Load())
+	}
+	if len(owned) != 3 {
+		t.Error("keys not spread over the peers:", owned)
+	}
+	for _, p := range peers {
+		if n := p.group.Main().ItemCount(); n != owned[p.node.Self()] {
+			t.Errorf("%s holds %d keys in its main cache, owns %d", p.node.Self(), n, owned[p.node.Self()])
+		}
+	}
+
+	// Items fetched from peers are served from the hot cache
+	tr := peers[0].node.transport.(*countingTransport)
+	fetches := tr.fetches.Load()
+	for i := 0; i < 30; i++ {
+		if _, err := peers[0].group.Get(ctx, fmt.Sprint("key", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tr.fetches.Load() != fetches {
+		t.Error("hot keys were fetched again")
+	}
+	st := peers[0].group.Stats()
+	if st.Gets != 60 || st.HotHits != uint64(30-owned[peers[0].node.Self()]) || st.PeerLoads != uint64(fetches) {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	// The expiration of the owner is kept
+	for k, item := range peers[0].group.Hot().Items() {
+		if d := time.Until(time.Unix(0, item.Expiration)); d > time.Minute {
+			t.Errorf("%s kept for %v", k, d)
+		}
+	}
+
+	_, err := peers[0].group.Get(ctx, "fail")
+	if err == nil {
+		t.Error("loader error not returned")
+	}
+	for _, p := range peers {
+		if p.node.Self() != p.node.Owner("fail") {
+			continue
+		}
+		if st := p.group.Stats(); st.LoadErrors != 1 || st.Loads == 0 || st.LoadTime <= 0 {
+			t.Errorf("unexpected loader stats of the owner: %+v", st)
+		}
+	}
+}
+
+func TestClusterConcurrentGets(t *testing.T) {
+	var loads atomic.Int64
+	peers := startCluster(t, 3, &loads, WithHotCache(0, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, p := range peers {
+			wg.Add(1)
+			go func(g *Group[string]) {
+				defer wg.Done()
+				if v, err := g.Get(context.Background(), "hot"); err != nil || v != "value of hot" {
+					t.Error(v, err)
+				}
+			}(p.group)
+		}
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Error("loads:", loads.Load())
+	}
+}
+
+// inFlight reports whether a call for key is running in f
+func inFlight[V any](f *flight[V], key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, found := f.calls[key]
+	return found
+}
+
+// loaderTracer records the spans of a Group
+type loaderTracer struct {
+	mu   sync.Mutex
+	ends map[any]cache.SpanEnd
+}
+
+type loaderSpan struct {
+	t   *loaderTracer
+	op  cache.Op
+	key any
+}
+
+func (t *loaderTracer) Start(op cache.Op, key any) cache.Span {
+	return &loaderSpan{t, op, key}
+}
+
+func (s *loaderSpan) End(e cache.SpanEnd) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	if s.op == cache.OpLoader {
+		s.t.ends[s.key] = e
+	}
+}
+
+func TestGroupTracer(t *testing.T) {
+	tr := &loaderTracer{ends: map[any]cache.SpanEnd{}}
+	var loads atomic.Int64
+	peers := startCluster(t, 1, &loads, WithTracer(tr))
+	peers[0].group.Get(context.Background(), "a")
+	peers[0].group.Get(context.Background(), "a")
+	peers[0].group.Get(context.Background(), "fail")
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.ends) != 2 {
+		t.Fatal("unexpected loader spans:", tr.ends)
+	}
+	if e := tr.ends["a"]; e.Outcome != cache.OutcomeOK || e.Items != 1 || e.Duration <= 0 {
+		t.Errorf("unexpected span of a: %+v", e)
+	}
+	if e := tr.ends["fail"]; e.Outcome != cache.OutcomeError || e.Err == nil {
+		t.Errorf("unexpected span of fail: %+v", e)
+	}
+}
+
+func TestGroupLoaderPanic(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup(NewNode("self"), "g", func(ctx context.Context, key string) (string, time.Duration, error) {
+		if key == "panic" {
+			<-release
+			panic("boom")
+		}
+		return "value of " + key, 0, nil
+	})
+	defer g.Close()
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := g.Get(context.Background(), "panic")
+			errs <- err
+		}()
+	}
+	for !inFlight(&g.loads, "panic") {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		select {
+		case err := <-errs:
+			if err == nil || !strings.Contains(err.Error(), "boom") {
+				t.Error("panic not returned as an error:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("callers of a panicking load are blocked")
+		}
+	}
+	if inFlight(&g.loads, "panic") {
+		t.Error("panicking load is still in flight")
+	}
+	if v, err := g.Get(context.Background(), "a"); err != nil || v != "value of a" {
+		t.Error("load after a panic:", v, err)
+	}
+}
+
+func TestGroupDetachedLoad(t *testing.T) {
+	type ctxKey struct{}
+	release := make(chan struct{})
+	loaderErr := make(chan error, 1)
+	g := NewGroup(NewNode("self"), "g", func(ctx context.Context, key string) (string, time.Duration, error) {
+		if ctx.Value(ctxKey{}) != "first" {
+			return "", 0, errors.New("context values not kept")
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		loaderErr <- ctx.Err()
+		return "value of " + key, 0, ctx.Err()
+	}, WithLoadTimeout(time.Hour))
+	defer g.Close()
+
+	// The first caller gives up, the load goes on for the second
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "first"))
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.Get(ctx, "a")
+		first <- err
+	}()
+	for !inFlight(&g.loads, "a") {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() {
+		_, err := g.Get(context.Background(), "a")
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Error("canceled Get returned", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Error("load was canceled with the first caller:", err)
+	}
+	if err := <-loaderErr; err != nil {
+		t.Error("loader context done:", err)
+	}
+
+	// The timeout still bounds the load
+	g2 := NewGroup(NewNode("self"), "g", func(ctx context.Context, key string) (string, time.Duration, error) {
+		<-ctx.Done()
+		return "", 0, ctx.Err()
+	}, WithLoadTimeout(10*time.Millisecond))
+	defer g2.Close()
+	if _, err := g2.Get(context.Background(), "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("load not bounded by its timeout:", err)
+	}
+}
+
+func TestClusterLoaderError(t *testing.T) {
+	var loads atomic.Int64
+	var peerErrors atomic.Int64
+	peers := startCluster(t, 2, &loads, WithPeerErrorHandler(func(peer, key string, err error) {
+		peerErrors.Add(1)
+	}))
+	// A failing key owned by the other peer
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprint("fail", i); peers[0].node.Owner(k) == peers[1].node.Self() {
+			key = k
+		}
+	}
+	_, err := peers[0].group.Get(context.Background(), key)
+	var le *LoadError
+	if !errors.As(err, &le) || le.Peer != peers[1].node.Self() || !strings.Contains(le.Msg, "no such key") {
+		t.Errorf("unexpected error: %#v", err)
+	}
+	if loads.Load() != 1 {
+		t.Error("loader called", loads.Load(), "times")
+	}
+	if peerErrors.Load() != 0 || peers[0].group.Stats().PeerErrors != 0 {
+		t.Error("loader error taken for an unreachable peer")
+	}
+}
+
+func TestClusterPeerDown(t *testing.T) {
+	var loads atomic.Int64
+	var peerErrors atomic.Int64
+	peers := startCluster(t, 2, &loads, WithPeerErrorHandler(func(peer, key string, err error) {
+		peerErrors.Add(1)
+	}))
+	peers[1].srv.Close()
+	// A key owned by the stopped peer
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprint("key", i)
+		if peers[0].node.Owner(key) == peers[1].node.Self() {
+			break
+		}
+	}
+	v, err := peers[0].group.Get(context.Background(), key)
+	if err != nil || v != "value of "+key {
+		t.Fatal(v, err)
+	}
+	if peerErrors.Load() != 1 || peers[0].group.Stats().PeerErrors != 1 {
+		t.Error("peer error not reported")
+	}
+	// Kept in the hot cache, not the main cache
+	if _, found := peers[0].group.Hot().Get(key); !found {
+		t.Error("item not kept in the hot cache")
+	}
+	if peers[0].group.Main().ItemCount() != 0 {
+		t.Error("item kept in the main cache")
+	}
+}
+
+func TestNodeServeHTTP(t *testing.T) {
+	node := NewNode("self")
+	NewGroup(node, "g", func(ctx context.Context, key string) (int, time.Duration, error) {
+		return len(key), cache.NoExpiration, nil
+	}, WithCodec[int](cache.JSONCodec[string, int]{}))
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+	for _, tc := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/_cache/g/a%2Fb%20c", http.StatusOK, `{"key":"a/b c","value":5}` + "\n"},
+		{"/_cache/missing/a", http.StatusNotFound, ""},
+		{"/_cache/g", http.StatusBadRequest, ""},
+		{"/other", http.StatusNotFound, ""},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body [64]byte
+		n, _ := resp.Body.Read(body[:])
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("%s: status %d", tc.path, resp.StatusCode)
+		}
+		if tc.body != "" && string(body[:n]) != tc.body {
+			t.Errorf("%s: body %q", tc.path, body[:n])
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate group didn't panic")
+		}
+	}()
+	NewGroup(node, "g", func(ctx context.Context, key string) (int, time.Duration, error) {
+		return 0, 0, nil
+	})
+}
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// call is a load in progress or completed, done is closed once it completed
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// flight makes sure a key is loaded only once at a time: callers asking for
+// a key that is being loaded wait for the result of the running load.
+type flight[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+// do returns the result of fn, run once for all the concurrent callers of
+// key. fn runs in its own goroutine, so a caller whose ctx is done stops
+// waiting while the load goes on for the others. A panic in fn is returned to
+// all of them as an error.
+func (f *flight[V]) do(ctx context.Context, key string, fn func() (V, error)) (V, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]*call[V]{}
+	}
+	c, found := f.calls[key]
+	if !found {
+		c = &call[V]{done: make(chan struct{})}
+		f.calls[key] = c
+		go f.run(c, key, fn)
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// run runs fn for c and wakes the callers waiting for it
+func (f *flight[V]) run(c *call[V], key string, fn func() (V, error)) {
+	returned := false
+	defer func() {
+		if !returned {
+			c.err = fmt.Errorf("cluster: loading %q panicked: %v", key, recover())
+		}
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	returned = true
+}
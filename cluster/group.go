@@ -0,0 +1,378 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// Loader loads the value of key on its owner, e.g. from a database, and
+// returns how long it may be cached. 0 (cache.DefaultExpiration) and -1
+// (cache.NoExpiration) keep it until it is evicted.
+type Loader[V any] func(ctx context.Context, key string) (V, time.Duration, error)
+
+// GroupOption configures a Group.
+type GroupOption func(*groupOptions)
+
+type groupOptions struct {
+	cacheOpts   []cache.Option
+	hotCapacity int
+	hotTTL      time.Duration
+	codec       any
+	loadTimeout time.Duration
+	tracer      cache.Tracer
+	onPeerError func(peer, key string, err error)
+}
+
+// WithCacheOptions creates the main cache, holding the keys owned by this
+// peer, with opts, e.g. cache.WithCapacity.
+func WithCacheOptions(opts ...cache.Option) GroupOption {
+	return func(o *groupOptions) {
+		o.cacheOpts = append(o.cacheOpts, opts...)
+	}
+}
+
+// WithHotCache keeps up to capacity items fetched from their owners for at
+// most ttl, less if they expire earlier. A capacity of 0 turns the hot cache
+// off. The default is 1000 items for one minute.
+func WithHotCache(capacity int, ttl time.Duration) GroupOption {
+	return func(o *groupOptions) {
+		o.hotCapacity = capacity
+		o.hotTTL = ttl
+	}
+}
+
+// WithCodec encodes the items sent to peers with c. The default is
+// cache.GobCodec. All peers must use the same codec, and V must be the value
+// type of the group.
+func WithCodec[V any](c cache.Codec[string, V]) GroupOption {
+	return func(o *groupOptions) {
+		o.codec = c
+	}
+}
+
+// WithLoadTimeout bounds the loads and fetches shared by concurrent Gets to
+// d, as they are not canceled with the context of any of the callers. 0 lets
+// them run until they return. The default is one minute.
+func WithLoadTimeout(d time.Duration) GroupOption {
+	return func(o *groupOptions) {
+		o.loadTimeout = d
+	}
+}
+
+// WithTracer traces the calls to the loader with t, as cache.OpLoader spans
+// of the key. The operations of the caches are traced with their SetTracer,
+// e.g. Main().SetTracer(t).
+func WithTracer(t cache.Tracer) GroupOption {
+	return func(o *groupOptions) {
+		o.tracer = t
+	}
+}
+
+// WithPeerErrorHandler is called when a Get could not be forwarded to the
+// owner of key. The item is then loaded locally and kept in the hot cache.
+func WithPeerErrorHandler(f func(peer, key string, err error)) GroupOption {
+	return func(o *groupOptions) {
+		o.onPeerError = f
+	}
+}
+
+// GroupStats are the counters of a Group.
+type GroupStats struct {
+	Gets       uint64        // calls to Get
+	MainHits   uint64        // Gets served by the main cache
+	HotHits    uint64        // Gets served by the hot cache
+	PeerLoads  uint64        // items fetched from their owner
+	PeerErrors uint64        // fetches that failed and were loaded locally instead
+	Loads      uint64        // calls to the loader
+	LoadErrors uint64        // calls to the loader that returned an error
+	LoadTime   time.Duration // total time spent in the loader
+	Requests   uint64        // Gets served to other peers
+}
+
+type groupStats struct {
+	gets, mainHits, hotHits, peerLoads, peerErrors atomic.Uint64
+	loads, loadErrors, requests                    atomic.Uint64
+	loadTime                                       atomic.Int64 // nanoseconds
+}
+
+// loaded is an item with its absolute expiration, 0 for none
+type loaded[V any] struct {
+	value      V
+	expiration int64
+}
+
+// Group is a cache of values loaded by a Loader, partitioned across the
+// peers of a Node. Groups are identified by their name, which must be
+// registered on every peer.
+type Group[V any] struct {
+	name   string
+	node   *Node
+	loader Loader[V]
+	codec  cache.Codec[string, V]
+	main   *cache.Any[string, V]
+	hot    *cache.Any[string, V]
+	hotTTL time.Duration
+	// Loads and fetches run at most loadTimeout, 0 for no limit
+	loadTimeout time.Duration
+	tracer      cache.Tracer
+	// Loads on the owner and fetches from other peers are deduplicated
+	// separately, so serving a peer never waits for a fetch from a peer
+	loads   flight[loaded[V]]
+	fetches flight[loaded[V]]
+	stats   groupStats
+
+	onPeerError func(peer, key string, err error)
+}
+
+// NewGroup registers a group named name on n, loading missing keys with
+// loader. It panics if n already has a group of that name.
+func NewGroup[V any](n *Node, name string, loader Loader[V], opts ...GroupOption) *Group[V] {
+	o := groupOptions{hotCapacity: 1000, hotTTL: time.Minute, loadTimeout: time.Minute}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	g := &Group[V]{
+		name:        name,
+		node:        n,
+		loader:      loader,
+		codec:       cache.GobCodec[string, V]{},
+		main:        cache.New[string, V](cache.NoExpiration, time.Minute, o.cacheOpts...),
+		hotTTL:      o.hotTTL,
+		loadTimeout: o.loadTimeout,
+		tracer:      o.tracer,
+		onPeerError: o.onPeerError,
+	}
+	if o.codec != nil {
+		c, ok := o.codec.(cache.Codec[string, V])
+		if !ok {
+			panic(fmt.Sprintf("cluster: codec %T used for group %q of %T values", o.codec, name, *new(V)))
+		}
+		g.codec = c
+	}
+	if o.hotCapacity > 0 && o.hotTTL > 0 {
+		g.hot = cache.New[string, V](o.hotTTL, time.Minute, cache.WithCapacity(o.hotCapacity))
+	}
+	n.register(name, g)
+	return g
+}
+
+// Name returns the name of the group.
+func (g *Group[V]) Name() string {
+	return g.name
+}
+
+// Main returns the cache holding the keys owned by this peer.
+func (g *Group[V]) Main() *cache.Any[string, V] {
+	return g.main
+}
+
+// Hot returns the cache holding the keys fetched from other peers, or nil if
+// the hot cache is turned off.
+func (g *Group[V]) Hot() *cache.Any[string, V] {
+	return g.hot
+}
+
+// Get returns the value of key from the main or hot cache, or else from its
+// owner: this peer runs the loader if it owns key and otherwise fetches it
+// from the owner. If the loader of the owner fails, Get returns a *LoadError;
+// the key is only loaded here if the owner can't be reached. Concurrent Gets
+// of a key share a single load or fetch. It keeps the values of the context
+// of the first of them, but is only canceled by WithLoadTimeout; a Get whose
+// ctx is done returns ctx.Err() without waiting for it.
+func (g *Group[V]) Get(ctx context.Context, key string) (V, error) {
+	g.stats.gets.Add(1)
+	if v, found := g.cached(key); found {
+		return v, nil
+	}
+	owner := g.node.Owner(key)
+	if owner == g.node.self {
+		item, err := g.loads.do(ctx, key, func() (loaded[V], error) {
+			ctx, cancel := g.detach(ctx)
+			defer cancel()
+			return g.loadMain(ctx, key)
+		})
+		return item.value, err
+	}
+	item, err := g.fetches.do(ctx, key, func() (loaded[V], error) {
+		ctx, cancel := g.detach(ctx)
+		defer cancel()
+		// Fetched by the call that just finished
+		if g.hot != nil {
+			if v, found := g.hot.Get(key); found {
+				return loaded[V]{value: v}, nil
+			}
+		}
+		item, err := g.fetch(ctx, owner, key)
+		if err == nil {
+			g.stats.peerLoads.Add(1)
+			g.keepHot(key, item)
+			return item, nil
+		}
+		// The owner ran the loader, running it here again won't help
+		if isLoadError(err) {
+			return item, err
+		}
+		g.stats.peerErrors.Add(1)
+		if g.onPeerError != nil {
+			g.onPeerError(owner, key, err)
+		}
+		// The owner keeps the item once it is back, this peer only for a while
+		item, err = g.load(ctx, key)
+		if err == nil {
+			g.keepHot(key, item)
+		}
+		return item, err
+	})
+	return item.value, err
+}
+
+// detach returns a context for a load shared by several callers, keeping the
+// values of ctx but not its cancellation
+func (g *Group[V]) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if g.loadTimeout > 0 {
+		return context.WithTimeout(ctx, g.loadTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cached looks key up in the main and the hot cache
+func (g *Group[V]) cached(key string) (V, bool) {
+	if v, found := g.main.Get(key); found {
+		g.stats.mainHits.Add(1)
+		return v, true
+	}
+	if g.hot != nil {
+		if v, found := g.hot.Get(key); found {
+			g.stats.hotHits.Add(1)
+			return v, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// load runs the loader
+func (g *Group[V]) load(ctx context.Context, key string) (loaded[V], error) {
+	g.stats.loads.Add(1)
+	var span cache.Span
+	if g.tracer != nil {
+		span = g.tracer.Start(cache.OpLoader, key)
+	}
+	start := time.Now()
+	v, d, err := g.loader(ctx, key)
+	took := time.Since(start)
+	g.stats.loadTime.Add(int64(took))
+	if span != nil {
+		end := cache.SpanEnd{Outcome: cache.OutcomeOK, Duration: took, Items: 1}
+		if err != nil {
+			end = cache.SpanEnd{Outcome: cache.OutcomeError, Err: err, Duration: took}
+		}
+		span.End(end)
+	}
+	if err != nil {
+		g.stats.loadErrors.Add(1)
+		return loaded[V]{}, err
+	}
+	item := loaded[V]{value: v}
+	if d > 0 {
+		item.expiration = time.Now().Add(d).UnixNano()
+	}
+	return item, nil
+}
+
+// loadMain returns key from the main cache, loading it if it is missing
+func (g *Group[V]) loadMain(ctx context.Context, key string) (loaded[V], error) {
+	if v, e, found := g.main.GetWithExpiration(key); found {
+		item := loaded[V]{value: v}
+		if !e.IsZero() {
+			item.expiration = e.UnixNano()
+		}
+		return item, nil
+	}
+	item, err := g.load(ctx, key)
+	if err != nil {
+		return item, err
+	}
+	d := cache.NoExpiration
+	if item.expiration > 0 {
+		d = time.Until(time.Unix(0, item.expiration))
+	}
+	g.main.Set(key, item.value, d)
+	return item, nil
+}
+
+// keepHot adds an item owned by another peer to the hot cache
+func (g *Group[V]) keepHot(key string, item loaded[V]) {
+	if g.hot == nil {
+		return
+	}
+	d := g.hotTTL
+	if item.expiration > 0 {
+		d = min(d, time.Until(time.Unix(0, item.expiration)))
+	}
+	if d > 0 {
+		g.hot.Set(key, item.value, d)
+	}
+}
+
+// fetch gets key from its owner
+func (g *Group[V]) fetch(ctx context.Context, owner, key string) (loaded[V], error) {
+	b, err := g.node.transport.Fetch(ctx, owner, g.name, key)
+	if err != nil {
+		return loaded[V]{}, err
+	}
+	r, err := g.codec.NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		return loaded[V]{}, fmt.Errorf("cluster: %s: decoding %q: %w", owner, key, err)
+	}
+	return loaded[V]{value: r.Value, expiration: r.Expiration}, nil
+}
+
+// serve loads key for another peer and encodes it
+func (g *Group[V]) serve(ctx context.Context, key string) ([]byte, error) {
+	g.stats.requests.Add(1)
+	item, err := g.loads.do(ctx, key, func() (loaded[V], error) {
+		ctx, cancel := g.detach(ctx)
+		defer cancel()
+		return g.loadMain(ctx, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	r := cache.Record[string, V]{Key: key, Value: item.value, Expiration: item.expiration}
+	if err = g.codec.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Stats returns the counters of the group.
+func (g *Group[V]) Stats() GroupStats {
+	return GroupStats{
+		Gets:       g.stats.gets.Load(),
+		MainHits:   g.stats.mainHits.Load(),
+		HotHits:    g.stats.hotHits.Load(),
+		PeerLoads:  g.stats.peerLoads.Load(),
+		PeerErrors: g.stats.peerErrors.Load(),
+		Loads:      g.stats.loads.Load(),
+		LoadErrors: g.stats.loadErrors.Load(),
+		LoadTime:   time.Duration(g.stats.loadTime.Load()),
+		Requests:   g.stats.requests.Load(),
+	}
+}
+
+// Close unregisters the group from its node and closes its caches.
+func (g *Group[V]) Close() {
+	g.node.unregister(g.name)
+	g.main.Close()
+	if g.hot != nil {
+		g.hot.Close()
+	}
+}
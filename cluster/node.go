@@ -0,0 +1,185 @@
+// Package cluster partitions a cache across peers, groupcache-style: every
+// key is owned by one peer chosen with a consistent-hash ring, and only the
+// owner runs the loader for it and keeps it in its main cache. The other
+// peers forward their Gets to the owner over a Transport, HTTP by default,
+// and keep the items they fetched in a small hot cache for a while, so keys
+// read everywhere don't all go to their owner.
+//
+// Every peer runs a Node, registers the same groups on it and serves it to
+// the others:
+//
+//	node := cluster.NewNode("http://10.0.0.1:8000")
+//	node.SetPeers("http://10.0.0.1:8000", "http://10.0.0.2:8000")
+//	users := cluster.NewGroup(node, "users", loadUser)
+//	http.Handle(cluster.DefaultBasePath, node)
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Option configures a Node.
+type Option func(*Node)
+
+// WithTransport forwards Gets with t. The default is an HTTPTransport using
+// http.DefaultClient.
+func WithTransport(t Transport) Option {
+	return func(n *Node) {
+		n.transport = t
+	}
+}
+
+// WithReplicas places every peer at n points of the ring. More points spread
+// the keys more evenly. The default is 50.
+func WithReplicas(n int) Option {
+	return func(node *Node) {
+		node.replicas = n
+	}
+}
+
+// WithHash hashes keys and peers onto the ring with h. The default is
+// CRC-32 (IEEE). All peers must use the same hash.
+func WithHash(h Hash) Option {
+	return func(n *Node) {
+		n.hash = h
+	}
+}
+
+// WithBasePath serves peers under p. The default is DefaultBasePath. The
+// HTTPTransport of the other peers must use the same path.
+func WithBasePath(p string) Option {
+	return func(n *Node) {
+		n.basePath = p
+	}
+}
+
+// group is the part of a Group a Node serves to its peers
+type group interface {
+	serve(ctx context.Context, key string) ([]byte, error)
+}
+
+// Node is the member of a cluster running in this process. It owns the
+// ring, forwards the Gets of its groups to the other peers and serves
+// theirs.
+type Node struct {
+	self      string
+	transport Transport
+	replicas  int
+	hash      Hash
+	basePath  string
+
+	mu     sync.RWMutex
+	ring   *Ring
+	groups map[string]group
+}
+
+// NewNode returns a node for the peer self, which is how the other peers
+// reach it, e.g. its base URL with HTTPTransport. Until SetPeers is called
+// the node owns every key.
+func NewNode(self string, opts ...Option) *Node {
+	n := &Node{
+		self:     self,
+		replicas: 50,
+		basePath: DefaultBasePath,
+		groups:   map[string]group{},
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.transport == nil {
+		n.transport = &HTTPTransport{BasePath: n.basePath}
+	}
+	n.ring = NewRing(n.replicas, n.hash)
+	return n
+}
+
+// Self returns the name of this peer.
+func (n *Node) Self() string {
+	return n.self
+}
+
+// SetPeers replaces the peers of the cluster. The list should include this
+// peer and be the same on all peers, otherwise they disagree about the
+// owners of some keys.
+func (n *Node) SetPeers(peers ...string) {
+	peers = append([]string(nil), peers...)
+	sort.Strings(peers)
+	ring := NewRing(n.replicas, n.hash)
+	ring.Add(peers...)
+	n.mu.Lock()
+	n.ring = ring
+	n.mu.Unlock()
+}
+
+// Owner returns the peer owning key.
+func (n *Node) Owner(key string) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.ring.Empty() {
+		return n.self
+	}
+	return n.ring.Get(key)
+}
+
+func (n *Node) register(name string, g group) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, found := n.groups[name]; found {
+		panic(fmt.Sprintf("cluster: group %q registered twice", name))
+	}
+	n.groups[name] = g
+}
+
+func (n *Node) unregister(name string) {
+	n.mu.Lock()
+	delete(n.groups, name)
+	n.mu.Unlock()
+}
+
+// ServeHTTP serves GET basePath + group + "/" + key to the other peers. The
+// item is loaded on this node, whichever peer owns it here, so peers with
+// different rings never forward in a loop.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, found := strings.CutPrefix(r.URL.EscapedPath(), n.basePath)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	name, key, found := strings.Cut(p, "/")
+	if !found {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	name, err1 := url.PathUnescape(name)
+	key, err2 := url.PathUnescape(key)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	n.mu.RLock()
+	g, found := n.groups[name]
+	n.mu.RUnlock()
+	if !found {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	b, err := g.serve(r.Context(), key)
+	if err != nil {
+		// Tells the peer not to load the key itself
+		w.Header().Set(loadErrorHeader, "1")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
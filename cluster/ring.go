@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Hash maps data to a point on the ring.
+type Hash func(data []byte) uint32
+
+// Ring is a consistent-hash ring. Every peer is placed on the ring at
+// several points, its virtual nodes, and a key belongs to the peer of the
+// first point at or after the hash of the key. Adding or removing a peer only
+// moves the keys of its own points.
+//
+// A Ring is not safe for concurrent modification; Node replaces its ring
+// instead of changing it.
+type Ring struct {
+	hash     Hash
+	replicas int
+	points   []uint32
+	owners   map[uint32]string
+}
+
+// NewRing returns an empty ring placing every peer at replicas points. A nil
+// hash uses CRC-32 (IEEE).
+func NewRing(replicas int, hash Hash) *Ring {
+	if replicas < 1 {
+		replicas = 1
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Ring{hash: hash, replicas: replicas, owners: map[uint32]string{}}
+}
+
+// Add places peers on the ring. Where two peers collide the one sorting
+// first wins, so rings built from the same peers agree whatever the order of
+// the calls.
+func (r *Ring) Add(peers ...string) {
+	for _, p := range peers {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + p))
+			if cur, found := r.owners[h]; found {
+				if cur < p {
+					continue
+				}
+			} else {
+				r.points = append(r.points, h)
+			}
+			r.owners[h] = p
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+}
+
+// Empty reports whether the ring has no peers.
+func (r *Ring) Empty() bool {
+	return len(r.points) == 0
+}
+
+// Get returns the peer owning key, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	if r.Empty() {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
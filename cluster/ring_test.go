@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(50, nil)
+	if r.Get("a") != "" {
+		t.Error("empty ring returned an owner")
+	}
+	r.Add("a", "b", "c")
+	counts := map[string]int{}
+	owners := map[string]string{}
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprint("key", i)
+		owners[k] = r.Get(k)
+		counts[owners[k]]++
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		// Roughly a third each
+		if counts[p] < 500 || counts[p] > 1500 {
+			t.Errorf("peer %s owns %d of 3000 keys", p, counts[p])
+		}
+	}
+
+	// Adding a peer only moves keys to it
+	r2 := NewRing(50, nil)
+	r2.Add("c", "d", "b", "a")
+	moved := 0
+	for k, p := range owners {
+		if q := r2.Get(k); q != p {
+			if q != "d" {
+				t.Fatalf("%s moved from %s to %s", k, p, q)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Error("moved keys:", moved)
+	}
+}
+
+func TestRingCollision(t *testing.T) {
+	// Every point collides, the peer sorting first wins in any order
+	hash := func([]byte) uint32 { return 1 }
+	r1 := NewRing(3, hash)
+	r1.Add("b", "a")
+	r2 := NewRing(3, hash)
+	r2.Add("a")
+	r2.Add("b")
+	if r1.Get("x") != "a" || r2.Get("x") != "a" {
+		t.Error("collision resolved differently:", r1.Get("x"), r2.Get("x"))
+	}
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBasePath is the path under which a Node serves its peers.
+const DefaultBasePath = "/_cache/"
+
+// loadErrorHeader marks the responses of a peer whose loader failed
+const loadErrorHeader = "X-Cache-Load-Error"
+
+// Transport forwards a Get to the peer owning the key and returns the item
+// the peer encoded for it. Fetch returns a *LoadError if the peer was reached
+// but could not load the key, and any other error if the peer could not be
+// asked.
+type Transport interface {
+	Fetch(ctx context.Context, peer, group, key string) ([]byte, error)
+}
+
+// LoadError is the error of the loader of the peer owning a key. Get returns
+// it as is, while the key is loaded locally if its owner can't be reached.
+type LoadError struct {
+	Peer string
+	Msg  string // the error of the peer
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("cluster: %s: %s", e.Peer, e.Msg)
+}
+
+// isLoadError reports whether err is the loader error of a peer
+func isLoadError(err error) bool {
+	var le *LoadError
+	return errors.As(err, &le)
+}
+
+// HTTPTransport fetches from peers serving a Node over HTTP. Peers are base
+// URLs such as "http://10.0.0.1:8000".
+type HTTPTransport struct {
+	// Client is used for the requests. nil uses http.DefaultClient.
+	Client *http.Client
+	// BasePath is the path the peers serve their Node under. "" uses
+	// DefaultBasePath.
+	BasePath string
+}
+
+// Fetch requests GET peer + BasePath + group + "/" + key.
+func (t *HTTPTransport) Fetch(ctx context.Context, peer, group, key string) ([]byte, error) {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u := strings.TrimSuffix(peer, "/") + basePath(t.BasePath) + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.Header.Get(loadErrorHeader) != "" {
+		return nil, &LoadError{Peer: peer, Msg: strings.TrimSpace(string(body))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster: %s: %s: %s", peer, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func basePath(p string) string {
+	if p == "" {
+		return DefaultBasePath
+	}
+	return p
+}
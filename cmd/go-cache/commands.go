@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// command is a parsed command line, before the key and value types are known
+type command struct {
+	name   string
+	args   []string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// flags returns a flag set for the command
+func (c *command) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("go-cache "+c.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprint(c.stderr, usage)
+	}
+	return fs
+}
+
+// parse parses the flags of the command and checks the number of files
+func (c *command) parse(fs *flag.FlagSet, files int) ([]string, error) {
+	if err := fs.Parse(c.args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != files {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func runCommand[K comparable, V any](c *command) error {
+	switch c.name {
+	case "info":
+		return info(c)
+	case "dump":
+		return dump[K, V](c)
+	case "stats":
+		return stats[K, V](c)
+	case "filter", "delete":
+		return filter[K, V](c)
+	case "expire":
+		return expire[K, V](c)
+	case "convert":
+		return convert[K, V](c)
+	case "merge":
+		return merge[K, V](c)
+	}
+	fmt.Fprint(c.stderr, usage)
+	return errUsage
+}
+
+func codec[K comparable, V any](name string) (cache.Codec[K, V], error) {
+	switch name {
+	case "gob":
+		return cache.GobCodec[K, V]{}, nil
+	case "json":
+		return cache.JSONCodec[K, V]{}, nil
+	}
+	return nil, fmt.Errorf("unsupported codec %q", name)
+}
+
+// matcher returns a function reporting whether a key matches pattern
+func matcher[K comparable](pattern string) (func(k K) bool, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return func(k K) bool {
+		ok, _ := path.Match(pattern, fmt.Sprint(k))
+		return ok
+	}, nil
+}
+
+// write replaces out, or fname if out is empty, with recs encoded with the
+// codec named name
+func write[K comparable, V any](fname, out, name string, recs []cache.Record[K, V]) error {
+	cd, err := codec[K, V](name)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = fname
+	}
+	return cache.WriteSnapshotFile(out, cd, recs)
+}
+
+func expired[K comparable, V any](r cache.Record[K, V], now int64) bool {
+	return r.Expiration > 0 && now > r.Expiration
+}
+
+func info(c *command) error {
+	files, err := c.parse(c.flags(), 1)
+	if err != nil {
+		return err
+	}
+	h, err := cache.ReadSnapshotInfo(files[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "version: %d\ncodec:   %s\nrecords: %d\ncreated: %s\n", h.Version, h.Codec, h.Count, h.Created.Format(time.RFC3339))
+	return nil
+}
+
+// dumpRecord is a record as printed by dump
+type dumpRecord[K comparable, V any] struct {
+	Key        K          `json:"key"`
+	Value      V          `json:"value"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	Expired    bool       `json:"expired,omitempty"`
+	Hit        int        `json:"hit,omitempty"`
+}
+
+func dump[K comparable, V any](c *command) error {
+	files, err := c.parse(c.flags(), 1)
+	if err != nil {
+		return err
+	}
+	_, recs, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	out := make([]dumpRecord[K, V], len(recs))
+	for i, r := range recs {
+		out[i] = dumpRecord[K, V]{Key: r.Key, Value: r.Value, Expired: expired(r, now), Hit: r.Hit}
+		if r.Expiration > 0 {
+			e := time.Unix(0, r.Expiration)
+			out[i].Expiration = &e
+		}
+	}
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// ttlBuckets are the upper bounds of the TTL histogram printed by stats
+var ttlBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"< 1m", time.Minute},
+	{"< 1h", time.Hour},
+	{"< 1d", 24 * time.Hour},
+	{"< 7d", 7 * 24 * time.Hour},
+}
+
+// size returns the size of v: the length of strings and byte slices, and the
+// length of the JSON encoding of other values
+func size(v any) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+func stats[K comparable, V any](c *command) error {
+	fs := c.flags()
+	top := fs.Int("top", 10, "number of largest values to print")
+	files, err := c.parse(fs, 1)
+	if err != nil {
+		return err
+	}
+	h, recs, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	var nExpired, never int
+	hist := make([]int, len(ttlBuckets)+1)
+	for _, r := range recs {
+		switch {
+		case expired(r, now):
+			nExpired++
+		case r.Expiration == 0:
+			never++
+		default:
+			ttl := time.Duration(r.Expiration - now)
+			i := sort.Search(len(ttlBuckets), func(i int) bool {
+				return ttl < ttlBuckets[i].max
+			})
+			hist[i]++
+		}
+	}
+	w := c.stdout
+	fmt.Fprintf(w, "codec:   %s\ncreated: %s\nrecords: %d\nexpired: %d\n", h.Codec, h.Created.Format(time.RFC3339), len(recs), nExpired)
+	fmt.Fprintln(w, "ttl:")
+	for i, b := range ttlBuckets {
+		fmt.Fprintf(w, "  %-6s %d\n", b.label, hist[i])
+	}
+	fmt.Fprintf(w, "  %-6s %d\n", ">= 7d", hist[len(ttlBuckets)])
+	fmt.Fprintf(w, "  %-6s %d\n", "never", never)
+
+	type sized struct {
+		key  K
+		size int
+	}
+	largest := make([]sized, len(recs))
+	for i, r := range recs {
+		largest[i] = sized{r.Key, size(r.Value)}
+	}
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].size > largest[j].size
+	})
+	fmt.Fprintln(w, "largest values:")
+	for _, s := range largest[:min(*top, len(largest))] {
+		fmt.Fprintf(w, "  %8d  %v\n", s.size, s.key)
+	}
+	return nil
+}
+
+// filter handles filter, which keeps the matching keys, and delete, which
+// removes them
+func filter[K comparable, V any](c *command) error {
+	fs := c.flags()
+	pattern := fs.String("match", "", "key pattern")
+	dropExpired := false
+	if c.name == "delete" {
+		fs.BoolVar(&dropExpired, "expired", false, "delete expired keys")
+	}
+	out := fs.String("o", "", "output file")
+	files, err := c.parse(fs, 1)
+	if err != nil {
+		return err
+	}
+	if *pattern == "" && !dropExpired {
+		return fmt.Errorf("%s: -match is required", c.name)
+	}
+	match := func(K) bool { return false }
+	if *pattern != "" {
+		if match, err = matcher[K](*pattern); err != nil {
+			return err
+		}
+	}
+	h, recs, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	kept := recs[:0]
+	for _, r := range recs {
+		drop := match(r.Key) || (dropExpired && expired(r, now))
+		if c.name == "filter" {
+			drop = !drop
+		}
+		if !drop {
+			kept = append(kept, r)
+		}
+	}
+	fmt.Fprintf(c.stdout, "%d records, %d removed\n", len(kept), h.Count-len(kept))
+	return write(files[0], *out, h.Codec, kept)
+}
+
+func expire[K comparable, V any](c *command) error {
+	fs := c.flags()
+	pattern := fs.String("match", "*", "key pattern")
+	ttl := fs.Duration("ttl", 0, "new time to live")
+	persist := fs.Bool("persist", false, "remove the expiration")
+	out := fs.String("o", "", "output file")
+	files, err := c.parse(fs, 1)
+	if err != nil {
+		return err
+	}
+	if (*ttl > 0) == *persist {
+		return fmt.Errorf("expire: exactly one of a positive -ttl and -persist is required")
+	}
+	match, err := matcher[K](*pattern)
+	if err != nil {
+		return err
+	}
+	h, recs, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	var e int64
+	if !*persist {
+		e = time.Now().Add(*ttl).UnixNano()
+	}
+	n := 0
+	for i := range recs {
+		if match(recs[i].Key) {
+			recs[i].Expiration = e
+			n++
+		}
+	}
+	fmt.Fprintf(c.stdout, "%d records, %d changed\n", len(recs), n)
+	return write(files[0], *out, h.Codec, recs)
+}
+
+func convert[K comparable, V any](c *command) error {
+	fs := c.flags()
+	name := fs.String("codec", "", "codec to convert to")
+	out := fs.String("o", "", "output file")
+	files, err := c.parse(fs, 1)
+	if err != nil {
+		return err
+	}
+	if _, err = codec[K, V](*name); err != nil {
+		return err
+	}
+	_, recs, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	return write(files[0], *out, *name, recs)
+}
+
+var strategies = map[string]cache.MergeStrategy{
+	"keep-existing": cache.MergeKeepExisting,
+	"overwrite":     cache.MergeOverwrite,
+	"keep-newer":    cache.MergeKeepNewer,
+}
+
+func merge[K comparable, V any](c *command) error {
+	fs := c.flags()
+	name := fs.String("strategy", "keep-existing", "merge strategy")
+	out := fs.String("o", "", "output file")
+	files, err := c.parse(fs, 2)
+	if err != nil {
+		return err
+	}
+	s, ok := strategies[*name]
+	if !ok {
+		return fmt.Errorf("merge: unsupported strategy %q", *name)
+	}
+	h, dst, err := cache.ReadSnapshotFile[K, V](files[0], nil)
+	if err != nil {
+		return err
+	}
+	_, src, err := cache.ReadSnapshotFile[K, V](files[1], nil)
+	if err != nil {
+		return err
+	}
+	merged := cache.MergeRecords(dst, src, s)
+	fmt.Fprintf(c.stdout, "%d records\n", len(merged))
+	return write(files[0], *out, h.Codec, merged)
+}
@@ -0,0 +1,119 @@
+// Command go-cache inspects and edits snapshot files written by SaveFile and
+// SaveFileWith.
+//
+// Usage:
+//
+//	go-cache [-key type] [-value type] command [flags] file...
+//
+// The commands are:
+//
+//	info     print the header of the snapshot
+//	dump     print the records as JSON
+//	stats    print the number of records, expired records, a TTL histogram
+//	         and the largest values
+//	filter   keep only the keys matching -match
+//	delete   delete the keys matching -match, and expired keys with -expired
+//	expire   set the expiration of the keys matching -match to -ttl from
+//	         now, or remove it with -persist
+//	convert  rewrite the snapshot with the codec given by -codec
+//	merge    merge the second snapshot into the first like LoadFileMerge,
+//	         resolving keys found in both with -strategy
+//
+// Commands that edit a snapshot replace the first file unless -o names
+// another one. Patterns use the syntax of path.Match and are matched against
+// the keys formatted with fmt.Sprint.
+//
+// Snapshots of the built-in gob and json codecs can be read. The key and
+// value types must be the ones the cache was created with: gob refuses to
+// decode a value into a different type. The key type is one of string, int,
+// int64, uint64 or float64 and defaults to string. The value type is one of
+// any, string, bytes, int, int64, uint64, float64 or bool and defaults to
+// any, which reads every json snapshot and gob snapshots of caches holding
+// interface values.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: go-cache [-key type] [-value type] command [flags] file...
+
+commands:
+  info     file
+  dump     file
+  stats    [-top n] file
+  filter   -match pattern [-o out] file
+  delete   [-match pattern] [-expired] [-o out] file
+  expire   [-match pattern] (-ttl duration | -persist) [-o out] file
+  convert  -codec gob|json [-o out] file
+  merge    [-strategy keep-existing|overwrite|keep-newer] [-o out] file other
+`
+
+// errUsage is returned for invalid command lines, after printing the usage
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "go-cache:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("go-cache", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+	keyType := fs.String("key", "string", "key type")
+	valueType := fs.String("value", "any", "value type")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	cmd := &command{name: fs.Arg(0), args: fs.Args()[1:], stdout: stdout, stderr: stderr}
+	switch *keyType {
+	case "string":
+		return withValue[string](*valueType, cmd)
+	case "int":
+		return withValue[int](*valueType, cmd)
+	case "int64":
+		return withValue[int64](*valueType, cmd)
+	case "uint64":
+		return withValue[uint64](*valueType, cmd)
+	case "float64":
+		return withValue[float64](*valueType, cmd)
+	}
+	return fmt.Errorf("unsupported key type %q", *keyType)
+}
+
+func withValue[K comparable](valueType string, cmd *command) error {
+	switch valueType {
+	case "any":
+		return runCommand[K, any](cmd)
+	case "string":
+		return runCommand[K, string](cmd)
+	case "bytes":
+		return runCommand[K, []byte](cmd)
+	case "int":
+		return runCommand[K, int](cmd)
+	case "int64":
+		return runCommand[K, int64](cmd)
+	case "uint64":
+		return runCommand[K, uint64](cmd)
+	case "float64":
+		return runCommand[K, float64](cmd)
+	case "bool":
+		return runCommand[K, bool](cmd)
+	}
+	return fmt.Errorf("unsupported value type %q", valueType)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// testSnapshot writes a snapshot of a cache holding a few string counters
+func testSnapshot(t *testing.T, name string, recs ...cache.Record[string, int]) string {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), name)
+	if err := cache.WriteSnapshotFile(fname, cache.GobCodec[string, int]{}, recs); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func testRecords() []cache.Record[string, int] {
+	now := time.Now()
+	return []cache.Record[string, int]{
+		{Key: "user:1", Value: 1},
+		{Key: "user:2", Value: 22, Expiration: now.Add(30 * time.Second).UnixNano()},
+		{Key: "order:1", Value: 333, Expiration: now.Add(2 * time.Hour).UnixNano()},
+		{Key: "old", Value: 4, Expiration: now.Add(-time.Hour).UnixNano()},
+	}
+}
+
+// goCache runs the command line and returns its output
+func goCache(t *testing.T, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if err := run(args, &stdout, &stderr); err != nil {
+		t.Fatalf("%v: %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func readKeys(t *testing.T, fname string) map[string]cache.Record[string, int] {
+	t.Helper()
+	_, recs, err := cache.ReadSnapshotFile[string, int](fname, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]cache.Record[string, int]{}
+	for _, r := range recs {
+		keys[r.Key] = r
+	}
+	return keys
+}
+
+func TestDump(t *testing.T) {
+	fname := testSnapshot(t, "cache.snap", testRecords()...)
+	var recs []dumpRecord[string, int]
+	out := goCache(t, "-value", "int", "dump", fname)
+	if err := json.Unmarshal([]byte(out), &recs); err != nil {
+		t.Fatal(err, out)
+	}
+	if len(recs) != 4 || recs[0].Key != "user:1" || recs[0].Expiration != nil || recs[2].Value != 333 {
+		t.Error("unexpected dump:", out)
+	}
+	if !recs[3].Expired || recs[1].Expired {
+		t.Error("expired records not marked:", out)
+	}
+	if out = goCache(t, "info", fname); !strings.Contains(out, "records: 4") {
+		t.Error("unexpected info:", out)
+	}
+}
+
+func TestStats(t *testing.T) {
+	fname := testSnapshot(t, "cache.snap", testRecords()...)
+	out := goCache(t, "-value", "int", "stats", "-top", "2", fname)
+	for _, want := range []string{
+		"records: 4\n", "expired: 1\n",
+		"  < 1m   1\n", "  < 1h   0\n", "  < 1d   1\n", "  never  1\n",
+		"largest values:\n         3  order:1\n         2  user:2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFilterAndDelete(t *testing.T) {
+	fname := testSnapshot(t, "cache.snap", testRecords()...)
+	out := filepath.Join(t.TempDir(), "users.snap")
+	goCache(t, "-value", "int", "filter", "-match", "user:*", "-o", out, fname)
+	if keys := readKeys(t, out); len(keys) != 2 || keys["user:1"].Value != 1 {
+		t.Error("unexpected filter result:", keys)
+	}
+	goCache(t, "-value", "int", "delete", "-match", "user:1", "-expired", fname)
+	if keys := readKeys(t, fname); len(keys) != 2 || keys["order:1"].Value != 333 || keys["user:2"].Value != 22 {
+		t.Error("unexpected delete result:", keys)
+	}
+	var stderr bytes.Buffer
+	if err := run([]string{"-value", "int", "delete", fname}, &bytes.Buffer{}, &stderr); err == nil {
+		t.Error("delete without -match didn't fail")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	fname := testSnapshot(t, "cache.snap", testRecords()...)
+	goCache(t, "-value", "int", "expire", "-match", "user:*", "-ttl", "1h", fname)
+	keys := readKeys(t, fname)
+	if d := time.Until(time.Unix(0, keys["user:1"].Expiration)); d < 59*time.Minute || d > time.Hour {
+		t.Error("unexpected TTL:", d)
+	}
+	goCache(t, "-value", "int", "expire", "-persist", fname)
+	for k, r := range readKeys(t, fname) {
+		if r.Expiration != 0 {
+			t.Error(k, "still expires")
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	fname := testSnapshot(t, "cache.snap", testRecords()...)
+	goCache(t, "-value", "int", "convert", "-codec", "json", fname)
+	info, err := cache.ReadSnapshotInfo(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != "json" || info.Count != 4 {
+		t.Error("unexpected snapshot:", info)
+	}
+	// JSON snapshots can be read without knowing the value type
+	if out := goCache(t, "dump", fname); !strings.Contains(out, `"value": 333`) {
+		t.Error("unexpected dump:", out)
+	}
+	var stderr bytes.Buffer
+	if err = run([]string{"-value", "int", "convert", "-codec", "xml", fname}, &bytes.Buffer{}, &stderr); err == nil {
+		t.Error("unknown codec accepted")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+	a := testSnapshot(t, "a.snap",
+		cache.Record[string, int]{Key: "a", Value: 1},
+		cache.Record[string, int]{Key: "b", Value: 1, Expiration: now.Add(time.Minute).UnixNano()},
+	)
+	b := testSnapshot(t, "b.snap",
+		cache.Record[string, int]{Key: "b", Value: 2, Expiration: now.Add(time.Hour).UnixNano()},
+		cache.Record[string, int]{Key: "c", Value: 2},
+		cache.Record[string, int]{Key: "old", Value: 2, Expiration: now.Add(-time.Hour).UnixNano()},
+	)
+	out := filepath.Join(t.TempDir(), "merged.snap")
+	goCache(t, "-value", "int", "merge", "-o", out, a, b)
+	if keys := readKeys(t, out); len(keys) != 3 || keys["b"].Value != 1 || keys["c"].Value != 2 {
+		t.Error("unexpected keep-existing merge:", keys)
+	}
+	goCache(t, "-value", "int", "merge", "-strategy", "keep-newer", a, b)
+	if keys := readKeys(t, a); len(keys) != 3 || keys["a"].Value != 1 || keys["b"].Value != 2 {
+		t.Error("unexpected keep-newer merge:", keys)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"bogus", "x"}, {"dump"}, {"-key", "bool", "dump", "x"}} {
+		var stderr bytes.Buffer
+		if err := run(args, &bytes.Buffer{}, &stderr); err == nil {
+			t.Errorf("%v didn't fail", args)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Record is the serialized form of a single cache item.
+type Record[K comparable, V any] struct {
+	Key        K     `json:"key" msgpack:"key"`
+	Value      V     `json:"value" msgpack:"value"`
+	Expiration int64 `json:"expiration,omitempty" msgpack:"expiration,omitempty"`
+	Hit        int   `json:"hit,omitempty" msgpack:"hit,omitempty"`
+}
+
+// Codec serializes cache items for SaveWith and LoadWith. Items are written
+// and read one record at a time.
+type Codec[K comparable, V any] interface {
+	// Name identifies the format, e.g. "gob" or "json".
+	Name() string
+	NewEncoder(w io.Writer) Encoder[K, V]
+	NewDecoder(r io.Reader) Decoder[K, V]
+}
+
+// Encoder writes records to the stream it was created for.
+type Encoder[K comparable, V any] interface {
+	Encode(r Record[K, V]) error
+}
+
+// Decoder reads records from the stream it was created for. Decode returns
+// io.EOF once the stream is exhausted.
+type Decoder[K comparable, V any] interface {
+	Decode() (Record[K, V], error)
+}
+
+// GobCodec encodes items with encoding/gob. If V is an interface type the
+// dynamic types of the values are registered with gob while encoding.
+//
+// Its decoder also reads the format of earlier versions of Save, a single
+// gob-encoded map[K]Item[V], and returns the items of the map as records.
+type GobCodec[K comparable, V any] struct{}
+
+func (GobCodec[K, V]) Name() string {
+	return "gob"
+}
+
+func (GobCodec[K, V]) NewEncoder(w io.Writer) Encoder[K, V] {
+	return &gobEncoder[K, V]{enc: gob.NewEncoder(w), register: isInterface[V]()}
+}
+
+func (GobCodec[K, V]) NewDecoder(r io.Reader) Decoder[K, V] {
+	head := &headReader{r: r, buf: &bytes.Buffer{}}
+	return &gobDecoder[K, V]{dec: gob.NewDecoder(head), head: head}
+}
+
+type gobEncoder[K comparable, V any] struct {
+	enc      *gob.Encoder
+	register bool
+}
+
+func (e *gobEncoder[K, V]) Encode(r Record[K, V]) error {
+	if e.register {
+		if err := gobRegister(r.Key, r.Value); err != nil {
+			return err
+		}
+	}
+	if err := e.enc.Encode(&r); err != nil {
+		return &EncodeError{Key: r.Key, Err: err}
+	}
+	return nil
+}
+
+type gobDecoder[K comparable, V any] struct {
+	dec    *gob.Decoder
+	head   *headReader    // nil once the first record is decoded
+	legacy []Record[K, V] // remaining items of a legacy map
+	isMap  bool           // the stream is a legacy map
+}
+
+func (d *gobDecoder[K, V]) Decode() (Record[K, V], error) {
+	var r Record[K, V]
+	if d.isMap {
+		if len(d.legacy) == 0 {
+			return r, io.EOF
+		}
+		r, d.legacy = d.legacy[0], d.legacy[1:]
+		return r, nil
+	}
+	err := d.dec.Decode(&r)
+	if head := d.head; head != nil {
+		d.head = nil
+		consumed := head.buf
+		head.buf = nil
+		if err != nil && err != io.EOF && d.decodeMap(io.MultiReader(consumed, head.r)) {
+			return d.Decode()
+		}
+	}
+	return r, err
+}
+
+// decodeMap reads r, the whole stream, as a legacy map and reports whether
+// it is one
+func (d *gobDecoder[K, V]) decodeMap(r io.Reader) bool {
+	var m map[K]Item[V]
+	if gob.NewDecoder(r).Decode(&m) != nil {
+		return false
+	}
+	d.isMap = true
+	d.legacy = make([]Record[K, V], 0, len(m))
+	for k, item := range m {
+		d.legacy = append(d.legacy, Record[K, V]{Key: k, Value: item.Value, Expiration: item.Expiration, Hit: item.Hit})
+	}
+	return true
+}
+
+// headReader keeps a copy of the bytes read until buf is cleared, so that
+// a stream can be decoded again from the start
+type headReader struct {
+	r   io.Reader
+	buf *bytes.Buffer
+}
+
+func (h *headReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if h.buf != nil {
+		h.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// JSONCodec encodes items as one JSON object per line with encoding/json.
+// Values of interface type are decoded the way json.Unmarshal decodes into
+// an interface, e.g. numbers become float64.
+type JSONCodec[K comparable, V any] struct{}
+
+func (JSONCodec[K, V]) Name() string {
+	return "json"
+}
+
+func (JSONCodec[K, V]) NewEncoder(w io.Writer) Encoder[K, V] {
+	return &jsonEncoder[K, V]{enc: json.NewEncoder(w)}
+}
+
+func (JSONCodec[K, V]) NewDecoder(r io.Reader) Decoder[K, V] {
+	return &jsonDecoder[K, V]{dec: json.NewDecoder(r)}
+}
+
+type jsonEncoder[K comparable, V any] struct {
+	enc *json.Encoder
+}
+
+func (e *jsonEncoder[K, V]) Encode(r Record[K, V]) error {
+	if err := e.enc.Encode(&r); err != nil {
+		return &EncodeError{Key: r.Key, Err: err}
+	}
+	return nil
+}
+
+type jsonDecoder[K comparable, V any] struct {
+	dec *json.Decoder
+}
+
+func (d *jsonDecoder[K, V]) Decode() (Record[K, V], error) {
+	var r Record[K, V]
+	err := d.dec.Decode(&r)
+	return r, err
+}
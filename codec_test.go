@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCodecRoundTrip(t *testing.T, codec Codec[string, TestStruct]) {
+	tc := New[string, TestStruct](DefaultExpiration, 0)
+	tc.Set("a", TestStruct{Num: 1}, DefaultExpiration)
+	tc.Set("b", TestStruct{Num: 2, Children: []*TestStruct{{Num: 3}}}, 1*time.Hour)
+	tc.Set("expired", TestStruct{Num: 4}, 1*time.Millisecond)
+	tc.mu.Lock()
+	item := tc.items["a"]
+	item.Hit = 7
+	tc.items["a"] = item
+	tc.mu.Unlock()
+	<-time.After(5 * time.Millisecond)
+
+	fp := &bytes.Buffer{}
+	if err := tc.SaveWith(fp, codec); err != nil {
+		t.Fatal(codec.Name(), err)
+	}
+	oc := New[string, TestStruct](DefaultExpiration, 0)
+	if err := oc.LoadWith(fp, codec); err != nil {
+		t.Fatal(codec.Name(), err)
+	}
+	if n := oc.ItemCount(); n != 2 {
+		t.Errorf("%s: loaded %d items, want 2", codec.Name(), n)
+	}
+	if v, hit, found := oc.GetWithHit("a"); !found || v.Num != 1 || hit != 7 {
+		t.Errorf("%s: a was not loaded with its hit count: %v %d", codec.Name(), v, hit)
+	}
+	v, exp, found := oc.GetWithExpiration("b")
+	if !found || v.Num != 2 || len(v.Children) != 1 || v.Children[0].Num != 3 {
+		t.Errorf("%s: b was not loaded: %v", codec.Name(), v)
+	}
+	if exp.UnixNano() != tc.items["b"].Expiration {
+		t.Errorf("%s: expiration of b was not kept", codec.Name())
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	testCodecRoundTrip(t, GobCodec[string, TestStruct]{})
+}
+
+// TestLoadLegacy loads the single gob-encoded map written by Save before
+// it wrote a stream of records
+func TestLoadLegacy(t *testing.T) {
+	items := map[string]Item[any]{
+		"a":       {Value: "one", Hit: 3},
+		"b":       {Value: 2, Expiration: time.Now().Add(time.Hour).UnixNano()},
+		"expired": {Value: 3, Expiration: 1},
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&items); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(t.TempDir(), "legacy.dat")
+	if err := os.WriteFile(fname, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, tc *Any[string, any]) {
+		t.Helper()
+		if n := tc.ItemCount(); n != 2 {
+			t.Errorf("%s: loaded %d items, want 2", what, n)
+		}
+		if v, hit, found := tc.GetWithHit("a"); !found || v != "one" || hit != 3 {
+			t.Errorf("%s: a was not loaded: %v %d", what, v, hit)
+		}
+		if _, exp, found := tc.GetWithExpiration("b"); !found || exp.UnixNano() != items["b"].Expiration {
+			t.Errorf("%s: b was not loaded with its expiration", what)
+		}
+	}
+
+	tc := New[string, any](DefaultExpiration, 0)
+	if err := tc.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	check("Load", tc)
+	tc = New[string, any](DefaultExpiration, 0)
+	if err := tc.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	check("LoadFile", tc)
+
+	// Neither a map nor a record stream
+	err := New[string, any](DefaultExpiration, 0).Load(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	if err == nil {
+		t.Error("truncated legacy stream didn't fail")
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCodecRoundTrip(t, JSONCodec[string, TestStruct]{})
+
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, NoExpiration)
+	fp := &bytes.Buffer{}
+	if err := tc.SaveWith(fp, JSONCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(fp.String()); s != `{"key":"a","value":1}` {
+		t.Error("unexpected JSON:", s)
+	}
+}
+
+func TestLoadWithDecodeError(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	err := tc.LoadWith(strings.NewReader(`{"key":"a","value":1}`+"\n"+`{"key":`), JSONCodec[string, int]{})
+	if err == nil || err == io.EOF {
+		t.Fatal("truncated stream didn't fail:", err)
+	}
+	if tc.ItemCount() != 0 {
+		t.Error("items were added from a truncated stream")
+	}
+}
+
+type testCSVCodec struct{}
+
+func (testCSVCodec) Name() string { return "test-csv" }
+
+func (testCSVCodec) NewEncoder(w io.Writer) Encoder[string, string] {
+	return testCSVEncoder{w}
+}
+
+func (testCSVCodec) NewDecoder(r io.Reader) Decoder[string, string] {
+	b, _ := io.ReadAll(r)
+	return &testCSVDecoder{lines: strings.Split(strings.TrimSpace(string(b)), "\n")}
+}
+
+type testCSVEncoder struct{ w io.Writer }
+
+func (e testCSVEncoder) Encode(r Record[string, string]) error {
+	_, err := io.WriteString(e.w, r.Key+","+r.Value+"\n")
+	return err
+}
+
+type testCSVDecoder struct{ lines []string }
+
+func (d *testCSVDecoder) Decode() (Record[string, string], error) {
+	if len(d.lines) == 0 || d.lines[0] == "" {
+		return Record[string, string]{}, io.EOF
+	}
+	k, v, _ := strings.Cut(d.lines[0], ",")
+	d.lines = d.lines[1:]
+	return Record[string, string]{Key: k, Value: v}, nil
+}
+
+func TestCustomCodec(t *testing.T) {
+	tc := New[string, string](DefaultExpiration, 0)
+	tc.Set("a", "1", DefaultExpiration)
+	fp := &bytes.Buffer{}
+	if err := tc.SaveWith(fp, testCSVCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if fp.String() != "a,1\n" {
+		t.Error("unexpected output:", fp.String())
+	}
+	oc := New[string, string](DefaultExpiration, 0)
+	if err := oc.LoadWith(fp, testCSVCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := oc.Get("a"); v != "1" {
+		t.Error("a was not loaded:", v)
+	}
+}
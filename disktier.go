@@ -0,0 +1,730 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A disk tier is a directory of segment files named "<id>.seg", appended to
+// in order of increasing id. Every record is framed as
+//
+//	length  uint32  length of kind and payload
+//	crc     uint32  CRC-32 (IEEE) of kind and payload
+//	kind    uint8   diskPut or diskTombstone
+//	payload         a single Record encoded with the tier's codec
+//
+// Only the newest segment is written to. Once it reaches a quarter of the
+// size limit a new segment is started; when the limit is exceeded the oldest
+// segment is removed together with the items it still holds. Segments are
+// also removed once every record in them has been replaced or removed, as
+// long as all older segments are gone too, so tombstones keep working after
+// the tier is reopened.
+//
+// The cache calls the tier while holding its lock, so the tier only updates
+// its index there and queues the records. A writer goroutine appends them to
+// the segments without the cache lock; until then lookups are served from
+// the queue.
+
+const (
+	diskPut       = 1
+	diskTombstone = 2
+	diskClear     = 3 // queued only: removes every segment
+
+	diskFrameHeader = 4 + 4 + 1
+	diskSegments    = 4 // number of segments the size limit is split into
+	diskSegmentExt  = ".seg"
+)
+
+// ErrDiskTierClosed is returned by the disk tier after Close.
+var ErrDiskTierClosed = errors.New("cache: disk tier closed")
+
+type diskEntry struct {
+	seg        uint64
+	off        int64
+	size       int64
+	expiration int64
+	version    uint64
+}
+
+// diskQueued is an item put into the tier that is not written yet
+type diskQueued[V any] struct {
+	item    Item[V]
+	version uint64
+}
+
+// diskWrite is a queued record
+type diskWrite[K comparable, V any] struct {
+	kind    byte
+	rec     Record[K, V]
+	version uint64
+}
+
+type diskSegment struct {
+	id   uint64
+	fp   *os.File
+	size int64
+	live int // number of indexed records in the segment
+}
+
+// DiskTier is a second, on-disk tier of a cache. Items evicted from memory
+// because the cache reached the capacity given to WithCapacity are written to
+// the tier instead of being dropped, and moved back into memory when they are
+// looked up again. Attach it to a cache with SetDiskTier.
+//
+// The tier keeps an index of its keys in memory and the items in segment
+// files, so it can be reopened after a restart. Items are written by a
+// goroutine of the tier, which Close stops. It is not meant to be shared
+// between caches.
+type DiskTier[K comparable, V any] struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	codec       Codec[K, V]
+
+	io     sync.Mutex // serializes flush, held while writing
+	mu     sync.Mutex
+	index  map[K]diskEntry
+	queued map[K]diskQueued[V]
+	queue  []diskWrite[K, V]
+	segs   []*diskSegment // oldest first, the last one is written to
+	size   int64
+	err    error
+	closed bool
+
+	version uint64 // of the last item put, to tell its copies apart
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	dropped func(k K) // called with the keys dropped to stay below maxBytes or expired
+}
+
+// OpenDiskTier opens the disk tier in dir, creating the directory if needed,
+// and indexes the items already stored in it. The segment files take up at
+// most about maxBytes; older items are dropped to stay below it. Items are
+// encoded with codec, or with GobCodec if codec is nil.
+func OpenDiskTier[K comparable, V any](dir string, maxBytes int64, codec Codec[K, V]) (*DiskTier[K, V], error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("cache: disk tier size limit must be positive, got %d", maxBytes)
+	}
+	if codec == nil {
+		codec = GobCodec[K, V]{}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	t := &DiskTier[K, V]{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: max(maxBytes/diskSegments, 1),
+		codec:       codec,
+		index:       map[K]diskEntry{},
+		queued:      map[K]diskQueued[V]{},
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := t.open(); err != nil {
+		t.closeSegments()
+		return nil, err
+	}
+	go t.run()
+	return t, nil
+}
+
+// segmentIDs returns the ids of the segment files in dir in ascending order
+func segmentIDs(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), diskSegmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (t *DiskTier[K, V]) segmentPath(id uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%016d%s", id, diskSegmentExt))
+}
+
+// open replays the existing segments into the index and opens a segment for
+// writing
+func (t *DiskTier[K, V]) open() error {
+	ids, err := segmentIDs(t.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	for _, id := range ids {
+		fp, err := os.OpenFile(t.segmentPath(id), os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		s := &diskSegment{id: id, fp: fp}
+		t.segs = append(t.segs, s)
+		end, err := t.scan(s, now)
+		if err != nil {
+			return err
+		}
+		if end != s.size {
+			// A torn record can only be at the end of the newest segment, but
+			// cutting it off is the right thing to do for any segment
+			if err = fp.Truncate(end); err != nil {
+				return err
+			}
+			s.size = end
+		}
+	}
+	for _, s := range t.segs {
+		t.size += s.size
+	}
+	if len(t.segs) == 0 || t.segs[len(t.segs)-1].size >= t.segmentSize {
+		if err = t.rotate(); err != nil {
+			return err
+		}
+	}
+	t.removeDead()
+	return nil
+}
+
+// scan reads the records of s into the index and returns the offset after
+// the last valid record
+func (t *DiskTier[K, V]) scan(s *diskSegment, now int64) (int64, error) {
+	st, err := s.fp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	s.size = st.Size()
+	br := bufio.NewReader(io.NewSectionReader(s.fp, 0, s.size))
+	var off int64
+	var hdr [diskFrameHeader]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, nil
+			}
+			return off, err
+		}
+		// A length that doesn't fit in the segment is a torn or corrupted
+		// record, like a checksum mismatch
+		n := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		if n < 1 || n-1 > s.size-off-diskFrameHeader {
+			return off, nil
+		}
+		payload := make([]byte, n-1)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, nil
+			}
+			return off, err
+		}
+		crc := crc32.Update(crc32.ChecksumIEEE(hdr[8:9]), crc32.IEEETable, payload)
+		if crc != binary.BigEndian.Uint32(hdr[4:8]) {
+			return off, nil
+		}
+		r, err := t.codec.NewDecoder(bytes.NewReader(payload)).Decode()
+		if err != nil {
+			return off, fmt.Errorf("cache: decoding disk tier record in %s at offset %d: %w", s.fp.Name(), off, err)
+		}
+		size := int64(diskFrameHeader) + int64(len(payload))
+		t.unindex(r.Key)
+		if hdr[8] == diskPut && (r.Expiration == 0 || now <= r.Expiration) {
+			t.version++
+			t.index[r.Key] = diskEntry{seg: s.id, off: off, size: size, expiration: r.Expiration, version: t.version}
+			s.live++
+		}
+		off += size
+	}
+}
+
+// segment returns the segment with the given id. t.mu must be held.
+func (t *DiskTier[K, V]) segment(id uint64) *diskSegment {
+	for _, s := range t.segs {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// unindex removes k from the index. t.mu must be held.
+func (t *DiskTier[K, V]) unindex(k K) (diskEntry, bool) {
+	e, found := t.index[k]
+	if !found {
+		return e, false
+	}
+	delete(t.index, k)
+	if s := t.segment(e.seg); s != nil {
+		s.live--
+	}
+	return e, true
+}
+
+// rotate starts a new segment. t.mu must be held.
+func (t *DiskTier[K, V]) rotate() error {
+	var id uint64 = 1
+	if len(t.segs) > 0 {
+		id = t.segs[len(t.segs)-1].id + 1
+	}
+	fp, err := os.OpenFile(t.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	t.segs = append(t.segs, &diskSegment{id: id, fp: fp})
+	return nil
+}
+
+// removeOldest removes the oldest segment and the items it holds, appending
+// their keys to dropped. t.mu must be held.
+func (t *DiskTier[K, V]) removeOldest(dropped []K) []K {
+	s := t.segs[0]
+	if s.live > 0 {
+		for k, e := range t.index {
+			if e.seg == s.id {
+				delete(t.index, k)
+				dropped = append(dropped, k)
+			}
+		}
+	}
+	t.segs = t.segs[1:]
+	t.size -= s.size
+	s.fp.Close()
+	t.setErr(os.Remove(s.fp.Name()))
+	return dropped
+}
+
+// removeDead removes old segments without indexed items. t.mu must be held.
+func (t *DiskTier[K, V]) removeDead() {
+	for len(t.segs) > 1 && t.segs[0].live == 0 {
+		t.removeOldest(nil)
+	}
+}
+
+// setErr keeps the first error. t.mu must be held.
+func (t *DiskTier[K, V]) setErr(err error) {
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// reportDropped passes keys to the dropped hook. Must be called without
+// holding t.mu.
+func (t *DiskTier[K, V]) reportDropped(keys []K) {
+	if len(keys) == 0 {
+		return
+	}
+	t.mu.Lock()
+	f := t.dropped
+	t.mu.Unlock()
+	if f == nil {
+		return
+	}
+	for _, k := range keys {
+		f(k)
+	}
+}
+
+// enqueue queues a record and wakes the writer. t.mu must be held.
+func (t *DiskTier[K, V]) enqueue(w diskWrite[K, V]) {
+	t.queue = append(t.queue, w)
+	t.signal()
+}
+
+// signal wakes the writer
+func (t *DiskTier[K, V]) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queued records until Close
+func (t *DiskTier[K, V]) run() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.wake:
+			t.flush()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// flush writes the queued records to the newest segment, starting new
+// segments and removing old ones as they fill up. The files are written
+// without holding t.mu.
+func (t *DiskTier[K, V]) flush() {
+	t.io.Lock()
+	defer t.io.Unlock()
+	t.mu.Lock()
+	queue := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+
+	var dropped []K
+	var buf bytes.Buffer
+	var batch []diskWrite[K, V] // the records in buf
+	var offs []int              // of the records in buf
+	for _, w := range queue {
+		if w.kind == diskClear {
+			dropped = t.write(buf.Bytes(), batch, offs, dropped)
+			buf.Reset()
+			batch, offs = batch[:0], offs[:0]
+			t.mu.Lock()
+			for len(t.segs) > 0 {
+				t.removeOldest(nil)
+			}
+			t.setErr(t.rotate())
+			t.mu.Unlock()
+			continue
+		}
+		start := buf.Len()
+		if err := t.encode(&buf, w.kind, w.rec); err != nil {
+			buf.Truncate(start)
+			t.mu.Lock()
+			t.setErr(err)
+			if q, found := t.queued[w.rec.Key]; found && q.version == w.version {
+				delete(t.queued, w.rec.Key)
+				dropped = append(dropped, w.rec.Key)
+			}
+			t.mu.Unlock()
+			continue
+		}
+		batch = append(batch, w)
+		offs = append(offs, start)
+		if buf.Len() >= int(t.segmentSize) {
+			dropped = t.write(buf.Bytes(), batch, offs, dropped)
+			buf.Reset()
+			batch, offs = batch[:0], offs[:0]
+		}
+	}
+	dropped = t.write(buf.Bytes(), batch, offs, dropped)
+	t.mu.Lock()
+	t.removeDead()
+	t.mu.Unlock()
+	t.reportDropped(dropped)
+}
+
+// write appends b, the frames of batch starting at offs, to the newest
+// segment and indexes the items that are still current. The keys of items
+// that could not be written or that were dropped to stay below maxBytes are
+// appended to dropped. t.io must be held.
+func (t *DiskTier[K, V]) write(b []byte, batch []diskWrite[K, V], offs []int, dropped []K) []K {
+	if len(b) == 0 {
+		return dropped
+	}
+	t.mu.Lock()
+	if len(t.segs) == 0 {
+		t.mu.Unlock()
+		return dropped
+	}
+	s := t.segs[len(t.segs)-1]
+	off := s.size
+	t.mu.Unlock()
+	_, err := s.fp.WriteAt(b, off)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.setErr(err)
+	} else {
+		s.size += int64(len(b))
+		t.size += int64(len(b))
+	}
+	for i, w := range batch {
+		q, found := t.queued[w.rec.Key]
+		if w.kind != diskPut || !found || q.version != w.version {
+			continue
+		}
+		// Not replaced or removed while it was written
+		delete(t.queued, w.rec.Key)
+		if err != nil {
+			dropped = append(dropped, w.rec.Key)
+			continue
+		}
+		end := len(b)
+		if i+1 < len(offs) {
+			end = offs[i+1]
+		}
+		t.index[w.rec.Key] = diskEntry{seg: s.id, off: off + int64(offs[i]), size: int64(end - offs[i]), expiration: w.rec.Expiration, version: w.version}
+		s.live++
+	}
+	if err == nil && s.size >= t.segmentSize {
+		t.setErr(t.rotate())
+	}
+	for t.size > t.maxBytes && len(t.segs) > 1 {
+		dropped = t.removeOldest(dropped)
+	}
+	return dropped
+}
+
+// encode appends the frame of a record to buf
+func (t *DiskTier[K, V]) encode(buf *bytes.Buffer, kind byte, r Record[K, V]) error {
+	start := buf.Len()
+	buf.Write(make([]byte, diskFrameHeader))
+	if err := t.codec.NewEncoder(buf).Encode(r); err != nil {
+		return err
+	}
+	b := buf.Bytes()[start:]
+	b[8] = kind
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b[8:]))
+	return nil
+}
+
+// put stores item under k, replacing any older copy. The item is written by
+// the writer goroutine; errors are reported by Err.
+func (t *DiskTier[K, V]) put(k K, item Item[V]) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrDiskTierClosed
+	}
+	t.forget(k)
+	t.version++
+	t.queued[k] = diskQueued[V]{item: item, version: t.version}
+	t.enqueue(diskWrite[K, V]{kind: diskPut, rec: Record[K, V]{Key: k, Value: item.Value, Expiration: item.Expiration, Hit: item.Hit}, version: t.version})
+	return nil
+}
+
+// has reports whether k is stored in the tier and hasn't expired
+func (t *DiskTier[K, V]) has(k K) bool {
+	t.mu.Lock()
+	e, found := t.index[k]
+	if q, ok := t.queued[k]; ok {
+		e, found = diskEntry{expiration: q.item.Expiration}, true
+	}
+	t.mu.Unlock()
+	return found && (e.expiration == 0 || time.Now().UnixNano() <= e.expiration)
+}
+
+// take reads k and removes it from the tier. Expired items are removed
+// without being returned.
+func (t *DiskTier[K, V]) take(k K) (Item[V], bool) {
+	return t.takeVersion(k, 0, Item[V]{})
+}
+
+// takeVersion is take for an item already read by peek without holding the
+// cache lock: if k still holds version v, item is returned instead of being
+// read again.
+func (t *DiskTier[K, V]) takeVersion(k K, v uint64, item Item[V]) (Item[V], bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if q, found := t.queued[k]; found {
+		item = q.item
+	} else if e, found := t.index[k]; !found {
+		return Item[V]{}, false
+	} else if v == 0 || e.version != v {
+		var err error
+		if item, err = t.read(e); err != nil {
+			t.remove(k)
+			t.setErr(err)
+			return Item[V]{}, false
+		}
+	}
+	t.remove(k)
+	if item.Expired() {
+		return Item[V]{}, false
+	}
+	return item, true
+}
+
+// peek reads k without removing it from the tier. It also returns the
+// version of the item for takeVersion.
+func (t *DiskTier[K, V]) peek(k K) (Item[V], uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var item Item[V]
+	var v uint64
+	if q, found := t.queued[k]; found {
+		item, v = q.item, q.version
+	} else if e, found := t.index[k]; !found {
+		return Item[V]{}, 0, false
+	} else {
+		var err error
+		if item, err = t.read(e); err != nil {
+			t.setErr(err)
+			return Item[V]{}, 0, false
+		}
+		v = e.version
+	}
+	if item.Expired() {
+		return Item[V]{}, 0, false
+	}
+	return item, v, true
+}
+
+// setDropped sets the function called with the keys the tier drops by itself
+func (t *DiskTier[K, V]) setDropped(f func(k K)) {
+	t.mu.Lock()
+	t.dropped = f
+	t.mu.Unlock()
+}
+
+// read decodes the record of e. t.mu must be held.
+func (t *DiskTier[K, V]) read(e diskEntry) (Item[V], error) {
+	s := t.segment(e.seg)
+	if s == nil {
+		return Item[V]{}, ErrDiskTierClosed
+	}
+	b := make([]byte, e.size)
+	if _, err := s.fp.ReadAt(b, e.off); err != nil {
+		return Item[V]{}, err
+	}
+	if crc32.ChecksumIEEE(b[8:]) != binary.BigEndian.Uint32(b[4:8]) {
+		return Item[V]{}, fmt.Errorf("cache: disk tier record in %s at offset %d is corrupted", s.fp.Name(), e.off)
+	}
+	r, err := t.codec.NewDecoder(bytes.NewReader(b[diskFrameHeader:])).Decode()
+	if err != nil {
+		return Item[V]{}, err
+	}
+	return Item[V]{Value: r.Value, Expiration: r.Expiration, Hit: r.Hit}, nil
+}
+
+// forget removes k from the index or the queue and reports whether it was
+// there. t.mu must be held.
+func (t *DiskTier[K, V]) forget(k K) bool {
+	if _, found := t.queued[k]; found {
+		delete(t.queued, k)
+		return true
+	}
+	_, found := t.unindex(k)
+	return found
+}
+
+// remove drops k from the tier, queuing a tombstone so it stays removed
+// after reopening. t.mu must be held.
+func (t *DiskTier[K, V]) remove(k K) {
+	if !t.forget(k) || t.closed {
+		return
+	}
+	t.enqueue(diskWrite[K, V]{kind: diskTombstone, rec: Record[K, V]{Key: k}})
+}
+
+// delete drops k from the tier
+func (t *DiskTier[K, V]) delete(k K) {
+	t.mu.Lock()
+	t.remove(k)
+	t.mu.Unlock()
+}
+
+// deleteExpired drops the expired items from the index. Their records are
+// skipped when the tier is reopened, so no tombstones are needed.
+func (t *DiskTier[K, V]) deleteExpired() {
+	var dropped []K
+	now := time.Now().UnixNano()
+	t.mu.Lock()
+	for k, e := range t.index {
+		if e.expiration > 0 && now > e.expiration {
+			t.unindex(k)
+			dropped = append(dropped, k)
+		}
+	}
+	for k, q := range t.queued {
+		if q.item.Expiration > 0 && now > q.item.Expiration {
+			// Its record is written anyway and skipped when reopening
+			delete(t.queued, k)
+			dropped = append(dropped, k)
+		}
+	}
+	t.mu.Unlock()
+	// The writer removes the segments left without items
+	t.signal()
+	t.reportDropped(dropped)
+}
+
+// clear removes every item and queues the removal of every segment
+func (t *DiskTier[K, V]) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	for _, s := range t.segs {
+		s.live = 0
+	}
+	t.index = map[K]diskEntry{}
+	t.queued = map[K]diskQueued[V]{}
+	t.enqueue(diskWrite[K, V]{kind: diskClear})
+}
+
+// Len returns the number of items in the tier. This may include items that
+// have expired, but have not yet been cleaned up.
+func (t *DiskTier[K, V]) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.index) + len(t.queued)
+}
+
+// Size returns the total size of the segment files in bytes, once the
+// queued items are written.
+func (t *DiskTier[K, V]) Size() int64 {
+	t.flush()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Err returns the first I/O error the tier ran into, or nil, once the
+// queued items are written. Items that could not be written are dropped.
+func (t *DiskTier[K, V]) Err() error {
+	t.flush()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// closeSegments closes all segment files. t.mu must be held.
+func (t *DiskTier[K, V]) closeSegments() error {
+	var err error
+	for _, s := range t.segs {
+		if e := s.fp.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	t.segs = nil
+	return err
+}
+
+// Close writes the queued items, stops the writer and closes the segment
+// files. The items stay on disk and are indexed again by OpenDiskTier.
+func (t *DiskTier[K, V]) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	close(t.stop)
+	<-t.done
+	t.flush()
+	t.io.Lock()
+	defer t.io.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closeSegments()
+}
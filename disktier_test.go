@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := OpenDiskTier[string, int](dir, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := New[string, int](NoExpiration, 0, WithCapacity(2))
+	tc.SetDiskTier(disk)
+	evicted := 0
+	tc.OnEvicted(func(string, int, int) { evicted++ })
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Set("d", 4, DefaultExpiration)
+	if evicted != 0 {
+		t.Error("items spilled to disk were reported as evicted:", evicted)
+	}
+	if tc.ItemCount() != 2 || disk.Len() != 2 {
+		t.Fatalf("expected 2 items in memory and 2 on disk, got %d and %d", tc.ItemCount(), disk.Len())
+	}
+	for i, k := range []string{"a", "b", "c", "d"} {
+		if v, found := tc.Get(k); !found || v != i+1 {
+			t.Errorf("%s is %d, %v; want %d", k, v, found, i+1)
+		}
+	}
+	if tc.ItemCount() != 2 || disk.Len() != 2 {
+		t.Errorf("promotion broke the split: %d in memory and %d on disk", tc.ItemCount(), disk.Len())
+	}
+
+	// Remove returns an item that is only on disk
+	for i, k := range []string{"a", "b", "c", "d"} {
+		if disk.has(k) {
+			if v, found := tc.Remove(k); !found || v != i+1 || disk.has(k) {
+				t.Errorf("Remove of %s on disk returned %d, %v", k, v, found)
+			}
+			break
+		}
+	}
+
+	// A key overwritten in memory must not come back from disk
+	for _, k := range []string{"a", "b", "c", "d"} {
+		tc.Delete(k)
+	}
+	if disk.Len() != 0 {
+		t.Error("deleted items left on disk:", disk.Len())
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, found := tc.Get(k); found {
+			t.Error("deleted item came back:", k)
+		}
+	}
+	if err = disk.Err(); err != nil {
+		t.Error(err)
+	}
+	disk.Close()
+}
+
+func TestDiskTierExpiration(t *testing.T) {
+	disk, err := OpenDiskTier[string, int](t.TempDir(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	tc := NewNumber[string, int](NoExpiration, 0, WithCapacity(1))
+	tc.SetDiskTier(disk)
+	tc.Set("short", 1, 5*time.Millisecond)
+	tc.Set("long", 2, time.Hour)
+	tc.Set("forever", 3, DefaultExpiration)
+	if disk.Len() != 2 {
+		t.Fatal("expected 2 items on disk, got", disk.Len())
+	}
+	if err = tc.Increment("long", 10); err != nil {
+		t.Fatal("Increment didn't find the spilled item:", err)
+	}
+	if v, e, found := tc.GetWithExpiration("long"); !found || v != 12 || e.IsZero() {
+		t.Errorf("long is %d, %v, %v; want 12 with its expiration", v, e, found)
+	}
+	<-time.After(10 * time.Millisecond)
+	if _, found := tc.Get("short"); found {
+		t.Error("expired item was read from disk")
+	}
+	tc.DeleteExpired()
+	if disk.Len() != 1 {
+		t.Error("expected only forever on disk, got", disk.Len())
+	}
+}
+
+func TestDiskTierReopen(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := OpenDiskTier[string, string](dir, 1<<20, JSONCodec[string, string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := New[string, string](NoExpiration, 0, WithCapacity(1))
+	tc.SetDiskTier(disk)
+	tc.Set("a", "x", DefaultExpiration)
+	tc.Set("b", "y", DefaultExpiration)
+	tc.Set("c", "z", DefaultExpiration)
+	tc.Get("a") // promotes a and spills c
+	disk.Close()
+
+	disk, err = OpenDiskTier[string, string](dir, 1<<20, JSONCodec[string, string]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	if disk.Len() != 2 {
+		t.Fatal("expected b and c after reopening, got", disk.Len())
+	}
+	tc = New[string, string](NoExpiration, 0, WithCapacity(10))
+	tc.SetDiskTier(disk)
+	for k, want := range map[string]string{"b": "y", "c": "z"} {
+		if v, found := tc.Get(k); !found || v != want {
+			t.Errorf("%s is %q, %v after reopening", k, v, found)
+		}
+	}
+	if _, found := tc.Get("a"); found {
+		t.Error("promoted item a was still on disk")
+	}
+}
+
+func TestDiskTierCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := OpenDiskTier[string, int](dir, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = disk.put("a", Item[int]{Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	disk.Close()
+	ids, _ := segmentIDs(dir)
+	fp, err := os.OpenFile(disk.segmentPath(ids[len(ids)-1]), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A torn frame claiming almost 4 GiB
+	fp.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, diskPut, 1, 2, 3})
+	fp.Close()
+
+	disk, err = OpenDiskTier[string, int](dir, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	if disk.Len() != 1 {
+		t.Error("expected a after reopening, got", disk.Len())
+	}
+	if item, _, found := disk.peek("a"); !found || item.Value != 1 {
+		t.Error("a is", item.Value, found)
+	}
+}
+
+func TestDiskTierSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := OpenDiskTier[int, string](dir, 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	tc := New[int, string](NoExpiration, 0, WithCapacity(1))
+	tc.SetDiskTier(disk)
+	for i := 0; i < 200; i++ {
+		tc.Set(i, fmt.Sprintf("value %d", i), DefaultExpiration)
+	}
+	if disk.Size() > 4096+4096/diskSegments {
+		t.Error("disk tier grew past its limit:", disk.Size())
+	}
+	if disk.Len() == 0 || disk.Len() >= 199 {
+		t.Error("expected the oldest items to be dropped, have", disk.Len())
+	}
+	if _, found := tc.Get(198); !found {
+		t.Error("newest spilled item is missing")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) > diskSegments+1 {
+		t.Error("too many segment files:", len(entries))
+	}
+
+	tc.Flush()
+	if disk.Len() != 0 || disk.Size() != 0 {
+		t.Errorf("Flush left %d items, %d bytes on disk", disk.Len(), disk.Size())
+	}
+}
+
+func TestDiskTierConcurrent(t *testing.T) {
+	disk, err := OpenDiskTier[string, int](t.TempDir(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	tc := New[string, int](NoExpiration, 0, WithCapacity(8))
+	tc.SetDiskTier(disk)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				k := fmt.Sprint(g, "-", i%10)
+				tc.Set(k, i, DefaultExpiration)
+				if v, found := tc.Get(k); !found || v != i {
+					t.Errorf("%s is %d, %v after setting %d", k, v, found, i)
+					return
+				}
+				if i%3 == 0 {
+					if v, found := tc.Remove(k); !found || v != i {
+						t.Errorf("Remove of %s returned %d, %v, want %d", k, v, found, i)
+						return
+					}
+					if _, found := tc.Get(k); found {
+						t.Errorf("%s came back after Remove", k)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err = disk.Err(); err != nil {
+		t.Error(err)
+	}
+}
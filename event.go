@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventType identifies the kind of change an Event describes.
+type EventType int
+
+const (
+	// EventSet A new item was stored under a key that had no unexpired item
+	EventSet EventType = iota + 1
+	// EventUpdate The value of an unexpired item was replaced or modified
+	EventUpdate
+	// EventDelete An item was removed by Delete
+	EventDelete
+	// EventExpire An expired item was removed by DeleteExpired
+	EventExpire
+	// EventEvict An item was dropped by the cache itself, e.g. by Flush or to
+	// stay within the capacity given to WithCapacity
+	EventEvict
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event describes a change of a single cache item. OldValue is the zero value
+// for EventSet, NewValue is the zero value for EventDelete, EventExpire and
+// EventEvict.
+type Event[K comparable, V any] struct {
+	Type     EventType
+	Key      K
+	OldValue V
+	NewValue V
+
+	dropped  bool // removed to make room, still to be passed to OnEvicted
+	hit      int
+	callback *callback[K, V] // called instead of publishing the event
+	owner    *cache[K, V]    // namespace the event belongs to, if not the publisher
+}
+
+// OverflowPolicy decides what happens when a subscriber's channel is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop Discard the event if the channel is full
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock Wait until the subscriber has room. The writer that caused
+	// the event blocks, but never while holding the cache lock.
+	OverflowBlock
+)
+
+type subscriber[K comparable, V any] struct {
+	ch     chan Event[K, V]
+	done   chan struct{}
+	filter func(Event[K, V]) bool
+	policy OverflowPolicy
+	once   sync.Once
+	// Held for reading while sending to ch, and for writing to close it
+	mu     sync.RWMutex
+	closed bool
+}
+
+// eventBus holds the subscribers of a cache. subs is copied on removal, so
+// publishers can range over it after releasing mu.
+type eventBus[K comparable, V any] struct {
+	mu       sync.RWMutex
+	subs     []*subscriber[K, V]
+	watchers atomic.Int32 // number of subscribers, checked without locking
+}
+
+// Subscribe returns a channel receiving every event that passes filter (all
+// events if filter is nil). The channel has room for buffer events; policy
+// decides what happens when it is full. Events are delivered after the cache
+// lock has been released, so concurrent writers may deliver them out of order.
+func (c *cache[K, V]) Subscribe(filter func(Event[K, V]) bool, buffer int, policy OverflowPolicy) <-chan Event[K, V] {
+	s := &subscriber[K, V]{
+		ch:     make(chan Event[K, V], buffer),
+		done:   make(chan struct{}),
+		filter: filter,
+		policy: policy,
+	}
+	c.events.mu.Lock()
+	c.events.subs = append(c.events.subs, s)
+	c.events.watchers.Add(1)
+	c.events.mu.Unlock()
+	return s.ch
+}
+
+// Unsubscribe stops delivering events to ch and closes it. Does nothing if ch
+// is not subscribed.
+func (c *cache[K, V]) Unsubscribe(ch <-chan Event[K, V]) {
+	c.events.mu.RLock()
+	var s *subscriber[K, V]
+	for _, v := range c.events.subs {
+		if (<-chan Event[K, V])(v.ch) == ch {
+			s = v
+			break
+		}
+	}
+	c.events.mu.RUnlock()
+	if s == nil {
+		return
+	}
+	c.events.mu.Lock()
+	found := false
+	for i, v := range c.events.subs {
+		if v == s {
+			c.events.subs = append(c.events.subs[:i:i], c.events.subs[i+1:]...)
+			c.events.watchers.Add(-1)
+			found = true
+			break
+		}
+	}
+	c.events.mu.Unlock()
+	if !found {
+		return
+	}
+	// Release blocked publishers before waiting for them to finish
+	s.once.Do(func() { close(s.done) })
+	s.mu.Lock()
+	s.closed = true
+	close(s.ch)
+	s.mu.Unlock()
+}
+
+// watching reports whether events have to be collected
+func (c *cache[K, V]) watching() bool {
+	return c.events.watchers.Load() > 0
+}
+
+// changeEvent returns the event for writing v over old
+func changeEvent[K comparable, V any](k K, old Item[V], found bool, v V) Event[K, V] {
+	if found && !old.Expired() {
+		return Event[K, V]{Type: EventUpdate, Key: k, OldValue: old.Value, NewValue: v}
+	}
+	return Event[K, V]{Type: EventSet, Key: k, NewValue: v}
+}
+
+// removeEvent returns the event for removing old
+func removeEvent[K comparable, V any](t EventType, k K, old V) Event[K, V] {
+	return Event[K, V]{Type: t, Key: k, OldValue: old}
+}
+
+// publishOwned publishes the events of other namespaces on them, in order,
+// and returns the events of c. Must be called without holding c.mu.
+func (c *cache[K, V]) publishOwned(evs []Event[K, V]) []Event[K, V] {
+	out := evs[:0]
+	var owner *cache[K, V]
+	var owned []Event[K, V]
+	for _, ev := range evs {
+		if ev.owner == nil {
+			out = append(out, ev)
+			continue
+		}
+		if ev.owner != owner && len(owned) > 0 {
+			owner.publish(owned)
+			owned = nil
+		}
+		owner = ev.owner
+		ev.owner = nil
+		owned = append(owned, ev)
+	}
+	if len(owned) > 0 {
+		owner.publish(owned)
+	}
+	return out
+}
+
+// publish delivers evs to the subscribers. Must be called without holding c.mu.
+func (c *cache[K, V]) publish(evs []Event[K, V]) {
+	if len(evs) == 0 {
+		return
+	}
+	if evs = c.runCallbacks(c.reportDropped(c.publishOwned(evs))); len(evs) == 0 {
+		return
+	}
+	// Sends may block, without holding the bus lock so that subscribers can
+	// Subscribe and Unsubscribe meanwhile
+	c.events.mu.RLock()
+	subs := c.events.subs
+	c.events.mu.RUnlock()
+	for _, s := range subs {
+		s.send(evs)
+	}
+}
+
+// send delivers the events passing the filter of s
+func (s *subscriber[K, V]) send(evs []Event[K, V]) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, ev := range evs {
+		if s.filter != nil && !s.filter(ev) {
+			continue
+		}
+		if s.policy == OverflowBlock {
+			select {
+			case s.ch <- ev:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	tc := NewNumber[string, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(nil, 16, OverflowDrop)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("a", 2, DefaultExpiration)
+	tc.Increment("a", 3)
+	tc.Set("expired", 1, 1*time.Millisecond)
+	tc.Delete("a")
+	tc.Delete("missing")
+	<-time.After(5 * time.Millisecond)
+	tc.DeleteExpired()
+	tc.Set("b", 1, DefaultExpiration)
+	tc.Flush()
+
+	want := []Event[string, int]{
+		{Type: EventSet, Key: "a", NewValue: 1},
+		{Type: EventUpdate, Key: "a", OldValue: 1, NewValue: 2},
+		{Type: EventUpdate, Key: "a", OldValue: 2, NewValue: 5},
+		{Type: EventSet, Key: "expired", NewValue: 1},
+		{Type: EventDelete, Key: "a", OldValue: 5},
+		{Type: EventExpire, Key: "expired", OldValue: 1},
+		{Type: EventSet, Key: "b", NewValue: 1},
+		{Type: EventEvict, Key: "b", OldValue: 1},
+	}
+	for i, w := range want {
+		select {
+		case ev := <-ch:
+			if ev != w {
+				t.Errorf("event %d: got %+v, want %+v", i, ev, w)
+			}
+		default:
+			t.Fatalf("event %d (%+v) was not delivered", i, w)
+		}
+	}
+	select {
+	case ev := <-ch:
+		t.Error("unexpected event:", ev)
+	default:
+	}
+
+	tc.Unsubscribe(ch)
+	if _, ok := <-ch; ok {
+		t.Error("channel was not closed by Unsubscribe")
+	}
+	tc.Set("c", 1, DefaultExpiration)
+	if tc.watching() {
+		t.Error("cache still watching after Unsubscribe")
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(func(ev Event[string, int]) bool {
+		return ev.Key == "watched"
+	}, 4, OverflowDrop)
+	tc.Set("other", 1, DefaultExpiration)
+	tc.Set("watched", 2, DefaultExpiration)
+	ev := <-ch
+	if ev.Key != "watched" || ev.NewValue != 2 {
+		t.Error("unexpected event:", ev)
+	}
+}
+
+func TestSubscribeOverflowDrop(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(nil, 1, OverflowDrop)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	if ev := <-ch; ev.Key != "a" {
+		t.Error("unexpected event:", ev)
+	}
+	select {
+	case ev := <-ch:
+		t.Error("event was not dropped:", ev)
+	default:
+	}
+}
+
+func TestSubscribeOverflowBlock(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(nil, 1, OverflowBlock)
+	tc.Set("a", 1, DefaultExpiration)
+	done := make(chan struct{})
+	go func() {
+		tc.Set("b", 2, DefaultExpiration)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set didn't block on a full subscriber")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// The cache lock must not be held while blocked
+	if _, found := tc.Get("b"); !found {
+		t.Error("b was not stored")
+	}
+	<-ch
+	<-done
+	if ev := <-ch; ev.Key != "b" {
+		t.Error("unexpected event:", ev)
+	}
+}
+
+func TestUnsubscribeReleasesBlockedWriter(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(nil, 0, OverflowBlock)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tc.Set("a", 1, DefaultExpiration)
+	}()
+	<-time.After(10 * time.Millisecond)
+	tc.Unsubscribe(ch)
+	wg.Wait()
+}
+
+func TestSubscribeWhileWriterBlocked(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	blocked := tc.Subscribe(nil, 0, OverflowBlock)
+	other := tc.Subscribe(nil, 1, OverflowDrop)
+	written := make(chan struct{})
+	go func() {
+		tc.Set("a", 1, DefaultExpiration)
+		close(written)
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	// The consumer of blocked changes its subscriptions before reading
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch := tc.Subscribe(nil, 1, OverflowDrop)
+		tc.Unsubscribe(ch)
+		tc.Unsubscribe(other)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe or Unsubscribe waited for a blocked writer")
+	}
+	if ev := <-blocked; ev.Key != "a" {
+		t.Error("unexpected event:", ev)
+	}
+	<-written
+	tc.Unsubscribe(blocked)
+	if _, ok := <-blocked; ok {
+		t.Error("channel not closed")
+	}
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type evictListener[K comparable, V any] struct {
+	id uint64
+	f  func(key K, value V, hit int)
+}
+
+// evictListeners holds the OnEvicted listeners of a cache
+type evictListeners[K comparable, V any] struct {
+	mu      sync.RWMutex
+	next    uint64
+	list    []evictListener[K, V]
+	count   atomic.Int32 // number of listeners, checked without locking
+	pool    *dispatchPool
+	onPanic func(recovered any)
+}
+
+// OnEvicted registers f to be called with every item removed by Delete,
+// DeleteExpired or to stay within the capacity given to WithCapacity. Any
+// number of listeners can be registered; calling the returned function
+// removes f again.
+func (c *cache[K, V]) OnEvicted(f func(key K, value V, hit int)) (remove func()) {
+	l := &c.evicted
+	l.mu.Lock()
+	l.next++
+	id := l.next
+	l.list = append(l.list, evictListener[K, V]{id, f})
+	l.count.Add(1)
+	l.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			for i, v := range l.list {
+				if v.id == id {
+					l.list = append(l.list[:i:i], l.list[i+1:]...)
+					l.count.Add(-1)
+					break
+				}
+			}
+			l.mu.Unlock()
+		})
+	}
+}
+
+// hasEvictListeners reports whether evicted items have to be collected
+func (c *cache[K, V]) hasEvictListeners() bool {
+	return c.evicted.count.Load() > 0
+}
+
+// notifyEvicted calls the listeners for every item, either right away or on
+// the dispatch pool. Must be called without holding c.mu.
+func (c *cache[K, V]) notifyEvicted(items []keyAndValueModel[K, V]) {
+	if len(items) == 0 {
+		return
+	}
+	l := &c.evicted
+	l.mu.RLock()
+	list := l.list
+	l.mu.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+	run := func() {
+		for _, v := range items {
+			for _, e := range list {
+				l.call(e.f, v)
+			}
+		}
+	}
+	if l.pool != nil && l.pool.submit(run) {
+		return
+	}
+	run()
+}
+
+// call runs a single listener, recovering from panics
+func (l *evictListeners[K, V]) call(f func(key K, value V, hit int), v keyAndValueModel[K, V]) {
+	defer func() {
+		if x := recover(); x != nil && l.onPanic != nil {
+			l.onPanic(x)
+		}
+	}()
+	f(v.key, v.value, v.hit)
+}
+
+// callback runs the callback of SetWithCallback, recovering from panics
+func (l *evictListeners[K, V]) callback(f func(K, V), k K, v V) {
+	defer func() {
+		if x := recover(); x != nil && l.onPanic != nil {
+			l.onPanic(x)
+		}
+	}()
+	f(k, v)
+}
+
+// dispatchPool is a bounded pool of goroutines running submitted jobs
+type dispatchPool struct {
+	jobs   chan func()
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newDispatchPool(workers, queue int) *dispatchPool {
+	p := &dispatchPool{jobs: make(chan func(), queue)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues job, blocking while the queue is full. It returns false if
+// the pool has been stopped.
+func (p *dispatchPool) submit(job func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	p.jobs <- job
+	return true
+}
+
+// stop waits for the queued jobs to finish and stops the workers
+func (p *dispatchPool) stop() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnEvictedMultiple(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	var a, b []string
+	removeA := tc.OnEvicted(func(k string, v int, hit int) {
+		a = append(a, k)
+	})
+	tc.OnEvicted(func(k string, v int, hit int) {
+		b = append(b, k)
+	})
+	tc.Set("foo", 1, DefaultExpiration)
+	tc.Set("bar", 2, DefaultExpiration)
+	tc.Delete("foo")
+	removeA()
+	removeA()
+	tc.Delete("bar")
+	if len(a) != 1 || a[0] != "foo" {
+		t.Error("unexpected keys for listener a:", a)
+	}
+	if len(b) != 2 || b[0] != "foo" || b[1] != "bar" {
+		t.Error("unexpected keys for listener b:", b)
+	}
+}
+
+func TestOnEvictedPanic(t *testing.T) {
+	var recovered atomic.Value
+	tc := New[string, int](DefaultExpiration, 0, WithPanicHandler(func(x any) {
+		recovered.Store(x)
+	}))
+	works := false
+	tc.OnEvicted(func(k string, v int, hit int) {
+		panic("bad listener")
+	})
+	tc.OnEvicted(func(k string, v int, hit int) {
+		works = true
+	})
+	tc.Set("expired", 1, 1*time.Millisecond)
+	<-time.After(5 * time.Millisecond)
+	tc.DeleteExpired()
+	if !works {
+		t.Error("listener after the panicking one was not called")
+	}
+	if recovered.Load() != "bad listener" {
+		t.Error("panic handler was not called:", recovered.Load())
+	}
+}
+
+func TestOnEvictedAsync(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0, WithAsyncEviction(2, 4))
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(3)
+	var n atomic.Int32
+	tc.OnEvicted(func(k string, v int, hit int) {
+		<-release
+		n.Add(1)
+		wg.Done()
+	})
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+
+	done := make(chan struct{})
+	go func() {
+		tc.Delete("a")
+		tc.Delete("b")
+		tc.Delete("c")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Delete blocked on a slow listener")
+	}
+	if n.Load() != 0 {
+		t.Error("listener ran before it was released")
+	}
+	close(release)
+	wg.Wait()
+
+	tc.Close()
+	// Listeners run synchronously once the pool is stopped
+	tc.Set("d", 4, DefaultExpiration)
+	wg.Add(1)
+	tc.Delete("d")
+	if n.Load() != 4 {
+		t.Error("listener was not called after Close:", n.Load())
+	}
+}
+
+func TestCloseStopsJanitor(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 1*time.Millisecond)
+	tc.StopJanitor()
+	tc.Close()
+	tc.Close()
+}
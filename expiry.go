@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryCompactMin is the number of stale entries the expiry queue may hold
+// beyond twice the number of items before it is rebuilt
+const expiryCompactMin = 64
+
+// expiryEntry is a deadline of an item. It is stale once the item is gone or
+// its expiration changed.
+type expiryEntry[K comparable] struct {
+	key K
+	at  int64
+}
+
+// expiryQueue is a min-heap of entries ordered by their deadline
+type expiryQueue[K comparable] []expiryEntry[K]
+
+func (q expiryQueue[K]) Len() int           { return len(q) }
+func (q expiryQueue[K]) Less(i, j int) bool { return q[i].at < q[j].at }
+func (q expiryQueue[K]) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue[K]) Push(x any) {
+	*q = append(*q, x.(expiryEntry[K]))
+}
+
+func (q *expiryQueue[K]) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// expiry deletes the items of a cache created with WithPreciseExpiration as
+// soon as they expire, with a single timer set to the earliest deadline.
+// Guarded by the mutex of the cache.
+type expiry[K comparable] struct {
+	queue  expiryQueue[K]
+	timer  *time.Timer
+	armed  int64 // deadline the timer is set for, 0 if none
+	closed bool
+}
+
+// schedule makes the expiry delete k at its expiration at. c.mu must be held.
+func (c *cache[K, V]) schedule(k K, at int64) {
+	x := c.expiry
+	heap.Push(&x.queue, expiryEntry[K]{k, at})
+	if len(x.queue) > 2*len(c.items)+expiryCompactMin {
+		c.rescheduleAll()
+		return
+	}
+	c.arm()
+}
+
+// rescheduleAll rebuilds the expiry queue from the items, dropping stale
+// entries. c.mu must be held.
+func (c *cache[K, V]) rescheduleAll() {
+	x := c.expiry
+	x.queue = x.queue[:0]
+	for k, v := range c.items {
+		if v.Expiration > 0 {
+			x.queue = append(x.queue, expiryEntry[K]{k, v.Expiration})
+		}
+	}
+	heap.Init(&x.queue)
+	c.arm()
+}
+
+// arm sets the timer to the earliest deadline unless it already fires
+// before. c.mu must be held.
+func (c *cache[K, V]) arm() {
+	x := c.expiry
+	if x.closed || len(x.queue) == 0 {
+		return
+	}
+	at := x.queue[0].at
+	if x.armed != 0 && x.armed <= at {
+		return
+	}
+	x.armed = at
+	// Items expire once the time is past their expiration
+	d := time.Duration(at-time.Now().UnixNano()) + 1
+	if x.timer == nil {
+		x.timer = time.AfterFunc(d, c.expireDue)
+		return
+	}
+	x.timer.Reset(d)
+}
+
+// expireDue deletes the items whose deadline has passed, like DeleteExpired,
+// and sets the timer to the next deadline
+func (c *cache[K, V]) expireDue() {
+	var evictedItems []keyAndValueModel[K, V]
+	var evs []Event[K, V]
+	n := 0
+	sp := c.trace(OpDeleteExpired)
+	c.mu.Lock()
+	x := c.expiry
+	x.armed = 0
+	if x.closed {
+		c.mu.Unlock()
+		sp.end(OutcomeOK, nil, 0)
+		return
+	}
+	watching := c.watching()
+	listening := c.hasEvictListeners()
+	now := time.Now().UnixNano()
+	for len(x.queue) > 0 && x.queue[0].at < now {
+		e := heap.Pop(&x.queue).(expiryEntry[K])
+		if item, found := c.items[e.key]; !found || item.Expiration != e.at {
+			continue
+		}
+		ov, oh, _ := c.delete(e.key)
+		evs = c.removed(evs, e.key, ov)
+		n++
+		if listening {
+			evictedItems = append(evictedItems, keyAndValueModel[K, V]{e.key, ov, oh})
+		}
+		if watching {
+			evs = append(evs, removeEvent(EventExpire, e.key, ov))
+		}
+	}
+	c.arm()
+	c.mu.Unlock()
+	c.stats.expirations.Add(uint64(n))
+	c.notifyEvicted(evictedItems)
+	c.publish(evs)
+	sp.end(OutcomeOK, nil, n)
+}
+
+// stopExpiry stops the timer for good. c.mu must be held.
+func (c *cache[K, V]) stopExpiry() {
+	x := c.expiry
+	x.closed = true
+	x.queue = nil
+	if x.timer != nil {
+		x.timer.Stop()
+	}
+}
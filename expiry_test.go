@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPreciseExpiration(t *testing.T) {
+	var mu sync.Mutex
+	expired := map[string]time.Time{}
+	tc := New[string, int](DefaultExpiration, 0, WithPreciseExpiration())
+	defer tc.Close()
+	tc.OnEvicted(func(k string, v int, hit int) {
+		mu.Lock()
+		expired[k] = time.Now()
+		mu.Unlock()
+	})
+	start := time.Now()
+	tc.Set("late", 1, 60*time.Millisecond)
+	tc.Set("early", 2, 20*time.Millisecond)
+	tc.Set("moved", 3, 20*time.Millisecond)
+	tc.Set("moved", 3, time.Hour)
+	tc.Set("kept", 4, NoExpiration)
+	waitFor(t, "expiration of late", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		_, ok := expired["late"]
+		return ok
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 2 {
+		t.Error("unexpected expirations:", expired)
+	}
+	if d := expired["early"].Sub(start); d < 20*time.Millisecond || d > 200*time.Millisecond {
+		t.Error("early expired after", d)
+	}
+	if d := expired["late"].Sub(start); d < 60*time.Millisecond || d > 240*time.Millisecond {
+		t.Error("late expired after", d)
+	}
+	if tc.ItemCount() != 2 {
+		t.Error("expired items not deleted:", tc.ItemCount())
+	}
+}
+
+func TestPreciseExpirationUpdate(t *testing.T) {
+	done := make(chan string, 2)
+	tc := New[string, int](DefaultExpiration, 0, WithPreciseExpiration())
+	defer tc.Close()
+	tc.SetWithCallback("a", 1, time.Hour, func(k string, v int) { done <- k })
+	tc.SetWithCallback("b", 2, time.Hour, func(k string, v int) { done <- k })
+	if err := tc.UpdateExpiration("a", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	ns := tc.Namespace("ns", DefaultExpiration)
+	ns.SetWithCallback("c", 3, 20*time.Millisecond, func(k string, v int) { done <- k })
+	for _, want := range []string{"a", "c"} {
+		select {
+		case k := <-done:
+			if k != want {
+				t.Error("expired", k, "instead of", want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for", want)
+		}
+	}
+}
+
+func TestPreciseExpirationNewFrom(t *testing.T) {
+	tc := NewFrom[string, int](DefaultExpiration, 0, map[string]Item[int]{
+		"a": {Value: 1, Expiration: time.Now().Add(10 * time.Millisecond).UnixNano()},
+	}, WithPreciseExpiration())
+	defer tc.Close()
+	waitFor(t, "expiration of a", func() bool { return tc.ItemCount() == 0 })
+}
+
+func TestPreciseExpirationCompact(t *testing.T) {
+	tc := New[string, int](time.Hour, 0, WithPreciseExpiration())
+	defer tc.Close()
+	for i := 0; i < 1000; i++ {
+		tc.SetDefault("a", i)
+	}
+	tc.mu.RLock()
+	n := len(tc.expiry.queue)
+	tc.mu.RUnlock()
+	if n > 2+expiryCompactMin {
+		t.Error("stale deadlines not compacted:", n)
+	}
+}
+
+func TestPreciseExpirationClose(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0, WithPreciseExpiration())
+	tc.Set("a", 1, 5*time.Millisecond)
+	tc.Close()
+	time.Sleep(20 * time.Millisecond)
+	if tc.ItemCount() != 1 {
+		t.Error("item deleted after Close")
+	}
+}
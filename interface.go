@@ -0,0 +1,41 @@
+package cache
+
+import "time"
+
+// Cache is the behaviour shared by Any, Number and any other implementation,
+// so code can accept "some cache" and tests can substitute their own. The
+// package cachetest checks an implementation against it.
+type Cache[K comparable, V any] interface {
+	// Get returns the unexpired item of k and whether it was found.
+	Get(k K) (V, bool)
+	// GetWithExpiration is Get that also returns the expiration time, or the
+	// zero time if the item never expires.
+	GetWithExpiration(k K) (V, time.Time, bool)
+	// Set stores v under k for d, replacing any existing item.
+	// DefaultExpiration uses the cache's default, NoExpiration never expires.
+	Set(k K, v V, d time.Duration)
+	// SetDefault is Set with DefaultExpiration.
+	SetDefault(k K, v V)
+	// Add is Set that fails if k holds an unexpired item.
+	Add(k K, v V, d time.Duration) error
+	// Replace is Set that fails unless k holds an unexpired item.
+	Replace(k K, v V, d time.Duration) error
+	// UpdateExpiration changes the expiration of an unexpired item.
+	UpdateExpiration(k K, d time.Duration) error
+	// Delete removes k. Does nothing if k is not in the cache.
+	Delete(k K)
+	// DeleteExpired removes all expired items.
+	DeleteExpired()
+	// Items returns a copy of all unexpired items.
+	Items() map[K]Item[V]
+	// ItemCount returns the number of items, possibly including expired items
+	// that have not been removed yet.
+	ItemCount() int
+	// Flush removes all items.
+	Flush()
+}
+
+var (
+	_ Cache[string, any] = (*Any[string, any])(nil)
+	_ Cache[string, int] = (*Number[string, int])(nil)
+)
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand/v2"
+	"sync"
+)
+
+// Transport carries invalidation messages between the replicas of a cache,
+// e.g. over a message broker. The pubsub package has in-memory, UDP multicast
+// and TCP transports.
+type Transport interface {
+	// Publish sends msg to the other replicas. It may deliver msg back to
+	// the sender, which ignores its own messages.
+	Publish(msg []byte) error
+	// Subscribe calls f with every message received until cancel is called.
+	// f must not keep msg after it returns.
+	Subscribe(f func(msg []byte)) (cancel func(), err error)
+}
+
+// invalidationBatch is the maximum number of keys per message
+const invalidationBatch = 256
+
+// invalidationMaxSize is the maximum size of an encoded message, which keeps
+// it within a UDP datagram. Larger batches are split.
+const invalidationMaxSize = 60 << 10
+
+// invalidation is the message published through the Transport
+type invalidation[K comparable] struct {
+	Origin uint64
+	Flush  bool
+	Keys   []K
+}
+
+// invalidator publishes the keys changed by this replica and drops the keys
+// changed by the others
+type invalidator[K comparable, V any] struct {
+	c       *cache[K, V]
+	t       Transport
+	id      uint64
+	onError func(err error)
+	cancel  func()
+
+	mu    sync.Mutex
+	keys  map[K]struct{}
+	flush bool
+	wake  chan struct{}
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newInvalidator[K comparable, V any](c *cache[K, V], t Transport, onError func(err error)) *invalidator[K, V] {
+	return &invalidator[K, V]{
+		c:       c,
+		t:       t,
+		id:      rand.Uint64(),
+		onError: onError,
+		keys:    map[K]struct{}{},
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// start subscribes to the transport and starts publishing
+func (iv *invalidator[K, V]) start() {
+	cancel, err := iv.t.Subscribe(iv.receive)
+	if err != nil {
+		iv.report(err)
+	}
+	iv.cancel = cancel
+	iv.wg.Add(1)
+	go iv.run()
+}
+
+func (iv *invalidator[K, V]) report(err error) {
+	if iv.onError != nil {
+		iv.onError(err)
+	}
+}
+
+// mark queues k for publishing. Called with c.mu held.
+func (iv *invalidator[K, V]) mark(k K) {
+	iv.mu.Lock()
+	iv.keys[k] = struct{}{}
+	iv.mu.Unlock()
+	iv.signal()
+}
+
+// markFlush queues a flush, which supersedes the keys queued so far. Called
+// with c.mu held.
+func (iv *invalidator[K, V]) markFlush() {
+	iv.mu.Lock()
+	iv.flush = true
+	iv.keys = map[K]struct{}{}
+	iv.mu.Unlock()
+	iv.signal()
+}
+
+func (iv *invalidator[K, V]) signal() {
+	select {
+	case iv.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes the queued keys as soon as they are queued. Keys changed
+// while a message is being published are coalesced into the next one.
+func (iv *invalidator[K, V]) run() {
+	defer iv.wg.Done()
+	for {
+		select {
+		case <-iv.wake:
+			iv.send()
+		case <-iv.stop:
+			iv.send()
+			return
+		}
+	}
+}
+
+func (iv *invalidator[K, V]) send() {
+	iv.mu.Lock()
+	flush, keys := iv.flush, iv.keys
+	iv.flush = false
+	if len(keys) > 0 {
+		iv.keys = map[K]struct{}{}
+	}
+	iv.mu.Unlock()
+	if flush {
+		iv.publish(invalidation[K]{Origin: iv.id, Flush: true})
+	}
+	batch := make([]K, 0, min(len(keys), invalidationBatch))
+	for k := range keys {
+		batch = append(batch, k)
+		if len(batch) == invalidationBatch {
+			iv.publish(invalidation[K]{Origin: iv.id, Keys: batch})
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		iv.publish(invalidation[K]{Origin: iv.id, Keys: batch})
+	}
+}
+
+// publish sends msg, halving its keys until every part fits in
+// invalidationMaxSize. A single key that doesn't fit is sent as it is and
+// left to the transport to refuse.
+func (iv *invalidator[K, V]) publish(msg invalidation[K]) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		iv.report(err)
+		return
+	}
+	if buf.Len() > invalidationMaxSize && len(msg.Keys) > 1 {
+		half := len(msg.Keys) / 2
+		iv.publish(invalidation[K]{Origin: msg.Origin, Keys: msg.Keys[:half]})
+		iv.publish(invalidation[K]{Origin: msg.Origin, Keys: msg.Keys[half:]})
+		return
+	}
+	if err := iv.t.Publish(buf.Bytes()); err != nil {
+		iv.report(err)
+	}
+}
+
+// receive drops the keys invalidated by another replica
+func (iv *invalidator[K, V]) receive(b []byte) {
+	var msg invalidation[K]
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&msg); err != nil {
+		iv.report(err)
+		return
+	}
+	if msg.Origin == iv.id {
+		return
+	}
+	if msg.Flush {
+		iv.c.dropAll()
+	}
+	for _, k := range msg.Keys {
+		iv.c.drop(k)
+	}
+}
+
+// close unsubscribes and publishes the remaining keys
+func (iv *invalidator[K, V]) close() {
+	if iv.cancel != nil {
+		iv.cancel()
+	}
+	close(iv.stop)
+	iv.wg.Wait()
+}
+
+// drop removes k like Delete, without publishing it again
+func (c *cache[K, V]) drop(k K) {
+	var evs []Event[K, V]
+	c.mu.Lock()
+	v, hit, found := c.delete(k)
+	if found {
+		evs = c.removed(evs, k, v)
+		c.logWAL(walRecord[K, V]{Op: walDelete, Key: k})
+	}
+	if c.disk != nil {
+		c.disk.delete(k)
+	}
+	c.mu.Unlock()
+	if !found {
+		return
+	}
+	if c.hasEvictListeners() {
+		c.notifyEvicted([]keyAndValueModel[K, V]{{k, v, hit}})
+	}
+	if c.watching() {
+		evs = append(evs, removeEvent(EventDelete, k, v))
+	}
+	c.publish(evs)
+}
+
+// dropAll removes all items like Flush, without publishing the flush again
+func (c *cache[K, V]) dropAll() {
+	var evs []Event[K, V]
+	c.mu.Lock()
+	if c.watching() {
+		for k, v := range c.items {
+			if !v.Expired() {
+				evs = append(evs, removeEvent(EventEvict, k, v.Value))
+			}
+		}
+	}
+	evs = c.removedAll(evs)
+	if c.markDirty != nil {
+		for k := range c.items {
+			c.markDirty(k)
+		}
+	}
+	c.sized(-len(c.items))
+	c.items = map[K]Item[V]{}
+	if c.disk != nil {
+		c.disk.clear()
+	}
+	c.logWAL(walRecord[K, V]{Op: walFlush})
+	c.mu.Unlock()
+	c.publish(evs)
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache/pubsub"
+)
+
+// waitFor fails the test if cond doesn't hold within a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	bus := pubsub.NewMemoryBus()
+	a := New[string, int](NoExpiration, 0, WithInvalidation(bus))
+	defer a.Close()
+	// Items given to NewFrom are not published, other writes to b would
+	// invalidate the writes to a
+	items := map[string]Item[int]{}
+	for _, k := range []string{"x", "y", "z", "w", "v"} {
+		items[k] = Item[int]{Value: 1}
+	}
+	b := NewFrom[string, int](NoExpiration, 0, items, WithInvalidation(bus))
+	defer b.Close()
+	var mu sync.Mutex
+	var evicted []string
+	b.OnEvicted(func(k string, v int, hit int) {
+		mu.Lock()
+		evicted = append(evicted, k)
+		mu.Unlock()
+	})
+
+	a.Set("x", 2, NoExpiration)
+	waitFor(t, "x to be dropped", func() bool {
+		_, found := b.Get("x")
+		return !found
+	})
+	// Not published back: a keeps its value
+	time.Sleep(10 * time.Millisecond)
+	if v, found := a.Get("x"); !found || v != 2 {
+		t.Error("a lost x:", v, found)
+	}
+
+	// Deleting a key a doesn't hold still invalidates it elsewhere
+	a.Delete("y")
+	waitFor(t, "y to be dropped", func() bool {
+		_, found := b.Get("y")
+		return !found
+	})
+	mu.Lock()
+	if len(evicted) != 2 {
+		t.Error("OnEvicted not called for dropped keys:", evicted)
+	}
+	mu.Unlock()
+
+	a.Add("z", 2, NoExpiration)
+	waitFor(t, "z to be dropped", func() bool {
+		_, found := b.Get("z")
+		return !found
+	})
+
+	a.Flush()
+	waitFor(t, "the flush", func() bool {
+		return b.ItemCount() == 0
+	})
+}
+
+func TestInvalidationClose(t *testing.T) {
+	bus := pubsub.NewMemoryBus()
+	a := New[string, int](NoExpiration, 0, WithInvalidation(bus))
+	b := New[string, int](NoExpiration, 0, WithInvalidation(bus))
+	defer b.Close()
+	for i := 0; i < invalidationBatch*3; i++ {
+		b.Set(string(rune('a'+i%26))+string(rune(i)), i, NoExpiration)
+	}
+	for k := range b.Items() {
+		a.Set(k, 0, NoExpiration)
+	}
+	// Close publishes what is left
+	a.Close()
+	if n := b.ItemCount(); n != 0 {
+		t.Error("items left after Close:", n)
+	}
+	// a no longer receives invalidations
+	a.Set("k", 1, NoExpiration)
+	b.Set("k", 2, NoExpiration)
+	time.Sleep(10 * time.Millisecond)
+	if _, found := a.Get("k"); !found {
+		t.Error("closed cache still subscribed")
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) Publish(msg []byte) error {
+	return errors.New("publish failed")
+}
+
+func (failingTransport) Subscribe(f func(msg []byte)) (func(), error) {
+	f([]byte("garbage"))
+	return func() {}, nil
+}
+
+func TestInvalidationErrors(t *testing.T) {
+	errs := make(chan error, 2)
+	c := New[string, int](NoExpiration, 0, WithInvalidation(failingTransport{}), WithInvalidationErrorHandler(func(err error) {
+		errs <- err
+	}))
+	defer c.Close()
+	if err := <-errs; err == nil {
+		t.Error("undecodable message not reported")
+	}
+	c.Set("a", 1, NoExpiration)
+	if err := <-errs; err == nil || err.Error() != "publish failed" {
+		t.Error("unexpected error:", err)
+	}
+}
+
+// sizeTransport records the size of the largest message published
+type sizeTransport struct {
+	*pubsub.MemoryBus
+	mu  sync.Mutex
+	max int
+}
+
+func (t *sizeTransport) Publish(msg []byte) error {
+	t.mu.Lock()
+	t.max = max(t.max, len(msg))
+	t.mu.Unlock()
+	return t.MemoryBus.Publish(msg)
+}
+
+func TestInvalidationMessageSize(t *testing.T) {
+	bus := &sizeTransport{MemoryBus: pubsub.NewMemoryBus()}
+	a := New[string, int](NoExpiration, 0, WithInvalidation(bus))
+	items := map[string]Item[int]{}
+	pad := strings.Repeat("k", 1000)
+	for i := 0; i < invalidationBatch; i++ {
+		items[pad+strconv.Itoa(i)] = Item[int]{Value: i}
+	}
+	b := NewFrom[string, int](NoExpiration, 0, items, WithInvalidation(bus))
+	defer b.Close()
+	for k := range items {
+		a.Set(k, 0, NoExpiration)
+	}
+	a.Close()
+	if n := b.ItemCount(); n != 0 {
+		t.Error("items left after Close:", n)
+	}
+	if bus.max > invalidationMaxSize {
+		t.Error("message of", bus.max, "bytes published")
+	}
+}
@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"container/heap"
+	"math/rand/v2"
+	"sync"
 	"time"
 )
 
@@ -9,18 +12,26 @@ type janitorInterface interface {
 	DeleteExpired()
 	SetJanitor(*janitor)
 	StopJanitor()
+	Close()
 }
 
-// stop janitor
+// stop janitor and every other background goroutine of the cache
 func stopJanitor(c janitorInterface) {
-	c.StopJanitor()
+	c.Close()
 }
 
-// run janitor
-func runJanitor(c janitorInterface, ci time.Duration) {
+// run janitor, on its own goroutine or on the shared Janitor if one is given
+func runJanitor(c janitorInterface, ci time.Duration, shared *Janitor) {
 	j := &janitor{
 		interval: ci,
-		stop:     make(chan bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if shared != nil {
+		j.shared = shared
+		j.entry = shared.add(c, ci)
+		c.SetJanitor(j)
+		return
 	}
 	c.SetJanitor(j)
 	go j.run(c)
@@ -29,11 +40,29 @@ func runJanitor(c janitorInterface, ci time.Duration) {
 // expired item cleaner
 type janitor struct {
 	interval time.Duration
-	stop     chan bool
+	stop     chan struct{}
+	done     chan struct{} // closed when run returns
+	once     sync.Once
+	shared   *Janitor      // sweeping the cache instead of run, if set
+	entry    *janitorEntry // of the cache on shared
+}
+
+// shutdown stops the janitor and waits for a sweep in progress, safe to call
+// more than once
+func (j *janitor) shutdown() {
+	j.once.Do(func() {
+		if j.shared != nil {
+			j.shared.remove(j.entry)
+			return
+		}
+		close(j.stop)
+		<-j.done
+	})
 }
 
 // clean up expired data
 func (j *janitor) run(c janitorInterface) {
+	defer close(j.done)
 	ticker := time.NewTicker(j.interval)
 	for {
 		select {
@@ -45,3 +74,152 @@ func (j *janitor) run(c janitorInterface) {
 		}
 	}
 }
+
+// Janitor deletes the expired items of many caches on a single goroutine,
+// instead of one goroutine per cache. Caches created with WithJanitor are
+// swept one after the other, each about every cleanupInterval: the first
+// sweep of a cache happens after a random part of its interval, and every
+// following one after its interval plus or minus up to 10%, so caches
+// created together don't all sweep at the same time. A slow sweep delays the
+// others.
+//
+// The goroutine runs only while caches are registered. A Janitor must not be
+// copied after first use.
+type Janitor struct {
+	mu       sync.Mutex
+	queue    janitorQueue
+	running  bool
+	wake     chan struct{}
+	sweeping *janitorEntry // swept by run without holding mu
+	swept    sync.Cond     // signaled when sweeping is reset, with mu
+}
+
+// DefaultJanitor is a Janitor for the caches of the whole program.
+var DefaultJanitor = NewJanitor()
+
+// NewJanitor returns a Janitor without caches.
+func NewJanitor() *Janitor {
+	j := &Janitor{wake: make(chan struct{}, 1)}
+	j.swept.L = &j.mu
+	return j
+}
+
+// janitorEntry is a cache registered with a Janitor
+type janitorEntry struct {
+	c        janitorInterface
+	interval time.Duration
+	next     time.Time
+	index    int // in the queue, -1 once removed
+}
+
+// janitorQueue is a min-heap of entries ordered by their next sweep
+type janitorQueue []*janitorEntry
+
+func (q janitorQueue) Len() int           { return len(q) }
+func (q janitorQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q janitorQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *janitorQueue) Push(x any) {
+	e := x.(*janitorEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *janitorQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
+
+// jitter returns d plus or minus up to 10%
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 10)
+	if spread <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
+
+func (j *Janitor) add(c janitorInterface, interval time.Duration) *janitorEntry {
+	e := &janitorEntry{
+		c:        c,
+		interval: interval,
+		next:     time.Now().Add(time.Duration(rand.Int64N(int64(interval)) + 1)),
+	}
+	j.mu.Lock()
+	heap.Push(&j.queue, e)
+	if !j.running {
+		j.running = true
+		go j.run()
+	}
+	j.mu.Unlock()
+	j.signal()
+	return e
+}
+
+// remove unregisters e, waiting for its sweep if run is sweeping it
+func (j *Janitor) remove(e *janitorEntry) {
+	j.mu.Lock()
+	if e.index >= 0 {
+		heap.Remove(&j.queue, e.index)
+	}
+	for j.sweeping == e {
+		j.swept.Wait()
+	}
+	j.mu.Unlock()
+	j.signal()
+}
+
+func (j *Janitor) signal() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of caches registered.
+func (j *Janitor) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.queue)
+}
+
+// run sweeps the caches as they become due until none is left
+func (j *Janitor) run() {
+	for {
+		j.mu.Lock()
+		if len(j.queue) == 0 {
+			j.running = false
+			j.mu.Unlock()
+			return
+		}
+		e := j.queue[0]
+		d := time.Until(e.next)
+		if d <= 0 {
+			e.next = time.Now().Add(jitter(e.interval))
+			heap.Fix(&j.queue, 0)
+			j.sweeping = e
+			j.mu.Unlock()
+			e.c.DeleteExpired()
+			j.mu.Lock()
+			j.sweeping = nil
+			j.swept.Broadcast()
+			j.mu.Unlock()
+			continue
+		}
+		j.mu.Unlock()
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-j.wake:
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedJanitor(t *testing.T) {
+	j := NewJanitor()
+	before := runtime.NumGoroutine()
+	caches := make([]*Any[string, int], 100)
+	for i := range caches {
+		caches[i] = New[string, int](time.Millisecond, 10*time.Millisecond, WithJanitor(j))
+		caches[i].Set("a", 1, DefaultExpiration)
+	}
+	if n := runtime.NumGoroutine() - before; n > 1 {
+		t.Error("goroutines started:", n)
+	}
+	if j.Len() != 100 {
+		t.Error("registered caches:", j.Len())
+	}
+	waitFor(t, "all caches to be swept", func() bool {
+		for _, c := range caches {
+			if c.ItemCount() != 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for _, c := range caches {
+		c.Close()
+	}
+	if j.Len() != 0 {
+		t.Error("caches left after Close:", j.Len())
+	}
+	// The goroutine exits once no cache is left
+	waitFor(t, "the janitor to stop", func() bool {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return !j.running
+	})
+
+	// and starts again for the next one
+	c := New[string, int](time.Millisecond, 5*time.Millisecond, WithJanitor(j))
+	defer c.Close()
+	c.Set("a", 1, DefaultExpiration)
+	waitFor(t, "the new cache to be swept", func() bool {
+		return c.ItemCount() == 0
+	})
+}
+
+func TestSharedJanitorIntervals(t *testing.T) {
+	j := NewJanitor()
+	fast := New[string, int](time.Millisecond, 5*time.Millisecond, WithJanitor(j))
+	defer fast.Close()
+	slow := New[string, int](time.Millisecond, time.Hour, WithJanitor(j))
+	defer slow.Close()
+	slow.Set("a", 1, DefaultExpiration)
+	fast.Set("a", 1, DefaultExpiration)
+	waitFor(t, "the fast cache to be swept", func() bool {
+		return fast.ItemCount() == 0
+	})
+	if slow.ItemCount() != 1 {
+		t.Error("slow cache swept early")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := jitter(time.Second); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatal("jitter out of range:", d)
+		}
+	}
+	if jitter(5) != 5 {
+		t.Error("tiny interval changed")
+	}
+}
+
+func TestDefaultJanitor(t *testing.T) {
+	c := NewNumber[string, int](time.Millisecond, 5*time.Millisecond, WithJanitor(DefaultJanitor))
+	c.Set("a", 1, DefaultExpiration)
+	waitFor(t, "the cache to be swept", func() bool {
+		return c.ItemCount() == 0
+	})
+	n := DefaultJanitor.Len()
+	c.Close()
+	if DefaultJanitor.Len() != n-1 {
+		t.Error("cache not removed from the default janitor")
+	}
+}
+
+func TestCloseWaitsForSweep(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithJanitor(NewJanitor())}} {
+		c := New[string, int](time.Millisecond, 5*time.Millisecond, opts...)
+		sweeping := make(chan struct{})
+		release := make(chan struct{})
+		var swept atomic.Int32
+		c.OnEvicted(func(string, int, int) {
+			if swept.Add(1) == 1 {
+				close(sweeping)
+				<-release
+			}
+		})
+		c.Set("a", 1, DefaultExpiration)
+		<-sweeping
+		closed := make(chan struct{})
+		go func() {
+			c.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("Close returned during a sweep")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		<-closed
+		c.Set("b", 1, DefaultExpiration)
+		time.Sleep(20 * time.Millisecond)
+		if swept.Load() != 1 || c.ItemCount() != 1 {
+			t.Error("swept after Close:", swept.Load())
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+var (
+	errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+	errOverflow   = errors.New("increment overflow")
+)
+
+// dispatch runs the command line and writes the response. It reports whether
+// the connection should be closed.
+func (s *Server) dispatch(line []byte, r *bufio.Reader, w *bufio.Writer) (quit bool) {
+	args := bytes.Fields(line)
+	if len(args) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+	switch string(args[0]) {
+	case "get":
+		s.get(args[1:], false, w)
+	case "gets":
+		s.get(args[1:], true, w)
+	case "set", "add", "replace":
+		return s.store(string(args[0]), args[1:], r, w)
+	case "delete":
+		s.delete(args[1:], w)
+	case "incr", "decr":
+		s.incr(string(args[0]) == "decr", args[1:], w)
+	case "touch":
+		s.touch(args[1:], w)
+	case "flush_all":
+		s.flushAll(args[1:], w)
+	case "stats":
+		if len(args) > 1 {
+			// Only the general statistics are supported
+			w.WriteString("END\r\n")
+			return false
+		}
+		s.writeStats(w)
+	case "version":
+		w.WriteString("VERSION " + Version + "\r\n")
+	case "verbosity":
+		reply(w, noreply(args[1:]), "OK")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+// noreply reports whether the last argument asks to suppress the response
+func noreply(args [][]byte) bool {
+	return len(args) > 0 && string(args[len(args)-1]) == "noreply"
+}
+
+// reply writes msg unless the client asked for noreply
+func reply(w *bufio.Writer, quiet bool, msg string) {
+	if !quiet {
+		w.WriteString(msg + "\r\n")
+	}
+}
+
+func clientError(w *bufio.Writer, msg string) {
+	w.WriteString("CLIENT_ERROR " + msg + "\r\n")
+}
+
+// get handles get and gets
+func (s *Server) get(keys [][]byte, withCAS bool, w *bufio.Writer) {
+	if len(keys) == 0 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	for _, b := range keys {
+		k := string(b)
+		s.cmdGet.Add(1)
+		v, found := s.c.Get(k)
+		if !found {
+			s.getMisses.Add(1)
+			continue
+		}
+		s.getHits.Add(1)
+		m := s.lookup(k, v)
+		w.WriteString("VALUE ")
+		w.WriteString(k)
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatUint(uint64(m.flags), 10))
+		w.WriteByte(' ')
+		w.WriteString(strconv.Itoa(len(v)))
+		if withCAS {
+			w.WriteByte(' ')
+			w.WriteString(strconv.FormatUint(m.cas, 10))
+		}
+		w.WriteString("\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// store handles set, add and replace:
+//
+//	<command> <key> <flags> <exptime> <bytes> [noreply]\r\n<data>\r\n
+func (s *Server) store(cmd string, args [][]byte, r *bufio.Reader, w *bufio.Writer) (quit bool) {
+	if len(args) != 4 && !(len(args) == 5 && noreply(args)) {
+		clientError(w, "bad command line format")
+		return false
+	}
+	quiet := noreply(args)
+	k := string(args[0])
+	flags, ok1 := parseUint(args[1], 32)
+	exptime, ok2 := parseInt(args[2])
+	n, ok3 := parseUint(args[3], 31)
+	if !ok1 || !ok2 || !ok3 {
+		clientError(w, "bad command line format")
+		return false
+	}
+	if int(n) > s.maxItemSize {
+		// Skip the data block, the connection stays usable
+		if _, err := io.CopyN(io.Discard, r, int64(n)+2); err != nil {
+			return true
+		}
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return true
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		// Skip the rest of the oversized block
+		if data[len(data)-1] != '\n' {
+			if _, err := readLine(r); err != nil {
+				return true
+			}
+		}
+		clientError(w, "bad data chunk")
+		return false
+	}
+	data = data[:n]
+	if !validKey(k) {
+		clientError(w, "bad key")
+		return false
+	}
+	s.cmdSet.Add(1)
+	d := expiration(exptime)
+
+	s.wmu.Lock()
+	var err error
+	switch cmd {
+	case "set":
+		s.c.Set(k, data, d)
+	case "add":
+		err = s.c.Add(k, data, d)
+	case "replace":
+		err = s.c.Replace(k, data, d)
+	}
+	if err == nil {
+		s.remember(k, uint32(flags), data)
+	}
+	s.wmu.Unlock()
+
+	if err != nil {
+		reply(w, quiet, "NOT_STORED")
+		return false
+	}
+	reply(w, quiet, "STORED")
+	return false
+}
+
+// delete handles delete <key> [0] [noreply]
+func (s *Server) delete(args [][]byte, w *bufio.Writer) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	// Old clients send a hold time of 0
+	if len(args) == 2 && string(args[1]) == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		clientError(w, "bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	k := string(args[0])
+	s.wmu.Lock()
+	_, found := s.c.Remove(k)
+	if found {
+		s.mu.Lock()
+		delete(s.meta, k)
+		s.mu.Unlock()
+	}
+	s.wmu.Unlock()
+	if !found {
+		reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	reply(w, quiet, "DELETED")
+}
+
+// incr handles incr and decr <key> <value> [noreply]. The value must be a
+// decimal unsigned 64-bit integer. Incrementing past the maximum is refused;
+// decrementing below zero results in zero, like memcached.
+func (s *Server) incr(decr bool, args [][]byte, w *bufio.Writer) {
+	quiet := noreply(args)
+	if len(args) != 2 && !(len(args) == 3 && quiet) {
+		clientError(w, "bad command line format")
+		return
+	}
+	k := string(args[0])
+	delta, ok := parseUint(args[1], 64)
+	if !ok {
+		clientError(w, "invalid numeric delta argument")
+		return
+	}
+	s.wmu.Lock()
+	var old []byte
+	v, err := s.c.Update(k, func(b []byte) ([]byte, error) {
+		old = b
+		cur, ok := parseUint(bytes.TrimSpace(b), 64)
+		if !ok {
+			return nil, errNonNumeric
+		}
+		var next uint64
+		var err error
+		if decr {
+			if next, err = cache.SubChecked(cur, delta); err != nil {
+				next = 0
+			}
+		} else if next, err = cache.AddChecked(cur, delta); err != nil {
+			return nil, errOverflow
+		}
+		return strconv.AppendUint(nil, next, 10), nil
+	})
+	if err == nil {
+		// The flags of the previous value are kept
+		s.remember(k, s.lookup(k, old).flags, v)
+	}
+	s.wmu.Unlock()
+
+	switch {
+	case err == nil:
+		reply(w, quiet, string(v))
+	case errors.Is(err, errNonNumeric), errors.Is(err, errOverflow):
+		if !quiet {
+			clientError(w, err.Error())
+		}
+	default:
+		reply(w, quiet, "NOT_FOUND")
+	}
+}
+
+// touch handles touch <key> <exptime> [noreply]
+func (s *Server) touch(args [][]byte, w *bufio.Writer) {
+	quiet := noreply(args)
+	if len(args) != 2 && !(len(args) == 3 && quiet) {
+		clientError(w, "bad command line format")
+		return
+	}
+	exptime, ok := parseInt(args[1])
+	if !ok {
+		clientError(w, "invalid exptime argument")
+		return
+	}
+	s.cmdTouch.Add(1)
+	if err := s.c.UpdateExpiration(string(args[0]), expiration(exptime)); err != nil {
+		reply(w, quiet, "NOT_FOUND")
+		return
+	}
+	reply(w, quiet, "TOUCHED")
+}
+
+// flushAll handles flush_all [delay] [noreply]
+func (s *Server) flushAll(args [][]byte, w *bufio.Writer) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	if len(args) > 1 {
+		clientError(w, "bad command line format")
+		return
+	}
+	if len(args) == 1 {
+		var ok bool
+		if delay, ok = parseInt(args[0]); !ok || delay < 0 {
+			clientError(w, "bad command line format")
+			return
+		}
+	}
+	s.cmdFlush.Add(1)
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, s.flush)
+	} else {
+		s.flush()
+	}
+	reply(w, quiet, "OK")
+}
+
+func (s *Server) flush() {
+	s.wmu.Lock()
+	s.c.Flush()
+	s.mu.Lock()
+	s.meta = map[string]meta{}
+	s.mu.Unlock()
+	s.wmu.Unlock()
+}
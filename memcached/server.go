@@ -0,0 +1,315 @@
+// Package memcached serves a cache over the memcached text protocol, so that
+// existing memcached clients can use it as a small standalone service.
+//
+// The supported commands are get, gets, set, add, replace, delete, incr,
+// decr, touch, flush_all, stats, version and quit. Values are stored as they
+// are in an Any[string, []byte]; the client flags and CAS unique of the items
+// written through the server are kept alongside, for as long as the cache
+// holds the value they were written with. Items written to the cache
+// directly, or read back from its disk tier, have flags 0 and CAS unique 0.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+	"github.com/Akvicor/go-cache/internal/netserver"
+)
+
+const (
+	maxKeyLength = 250
+	// Exptimes up to 30 days are relative, larger ones are Unix timestamps
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// Version is reported by the version command.
+const Version = "go-cache"
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("memcached: server closed")
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithMaxItemSize refuses values larger than n bytes. The default is 1 MiB,
+// like memcached.
+func WithMaxItemSize(n int) Option {
+	return func(s *Server) {
+		s.maxItemSize = n
+	}
+}
+
+// metaPruneMin is the number of stale entries meta may hold beyond twice
+// the number of items before they are dropped
+const metaPruneMin = 1024
+
+// meta is what memcached stores with an item besides its value. It applies
+// only as long as the cache holds the very value it was stored with: the
+// item may be replaced or removed without the server knowing.
+type meta struct {
+	flags uint32
+	cas   uint64
+	data  *byte // identity of the value
+}
+
+// identity returns the address of the first byte of b, or nil if b has no
+// capacity. Every value stored by the server is a new allocation.
+func identity(b []byte) *byte {
+	if cap(b) == 0 {
+		return nil
+	}
+	return &b[:1][0]
+}
+
+// lookup returns the meta of k if it belongs to v
+func (s *Server) lookup(k string, v []byte) meta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, found := s.meta[k]
+	if !found || m.data != identity(v) {
+		return meta{}
+	}
+	return m
+}
+
+// remember stores the meta of the value v just written to k, dropping the
+// entries of removed and replaced items once there are too many of them.
+// s.wmu must be held.
+func (s *Server) remember(k string, flags uint32, v []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[k] = meta{flags: flags, cas: s.nextCAS(), data: identity(v)}
+	if len(s.meta) <= 2*s.c.ItemCount()+metaPruneMin {
+		return
+	}
+	for k, m := range s.meta {
+		if item, found := s.c.Peek(k); !found || m.data != identity(item.Value) {
+			delete(s.meta, k)
+		}
+	}
+}
+
+// Server serves a cache over the memcached text protocol.
+type Server struct {
+	c           *cache.Any[string, []byte]
+	maxItemSize int
+	started     time.Time
+
+	wmu      sync.Mutex // serializes the writes of the server
+	mu       sync.Mutex // guards meta and cas
+	meta     map[string]meta
+	cas      uint64
+	unlisten func()
+
+	srv       *netserver.Server
+	closeOnce sync.Once
+
+	cmdGet, cmdSet, cmdTouch, cmdFlush atomic.Uint64
+	getHits, getMisses                 atomic.Uint64
+	currConns, totalConns              atomic.Int64
+}
+
+// NewServer returns a server for c.
+func NewServer(c *cache.Any[string, []byte], opts ...Option) *Server {
+	s := &Server{
+		c:           c,
+		maxItemSize: 1 << 20,
+		started:     time.Now(),
+		meta:        map[string]meta{},
+	}
+	s.srv = netserver.New(s.serveConn, ErrServerClosed)
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Forget the flags of items as they are removed, unless the key was
+	// written again in the meantime
+	s.unlisten = c.OnEvicted(func(k string, v []byte, _ int) {
+		s.mu.Lock()
+		if m, found := s.meta[k]; found && m.data == identity(v) {
+			delete(s.meta, k)
+		}
+		s.mu.Unlock()
+	})
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	return s.srv.ListenAndServe(addr)
+}
+
+// Serve accepts connections on l until Close is called, serving each on its
+// own goroutine. It always returns a non-nil error; ErrServerClosed after
+// Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.srv.Serve(l)
+}
+
+// Close stops all listeners, closes all connections and waits for their
+// goroutines. The cache is left open.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	s.closeOnce.Do(s.unlisten)
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.currConns.Add(1)
+	s.totalConns.Add(1)
+	defer s.currConns.Add(-1)
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				w.WriteString("CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+		if quit := s.dispatch(line, r, w); quit {
+			w.Flush()
+			return
+		}
+		// Flush only once the client has sent everything it pipelined
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errLineTooLong = errors.New("memcached: line too long")
+
+// maxLineLength bounds command lines; get accepts many keys per line
+const maxLineLength = 64 * 1024
+
+// readLine reads a command line without its "\r\n"
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		b, more, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b...)
+		if len(line) > maxLineLength {
+			return nil, errLineTooLong
+		}
+		if !more {
+			return line, nil
+		}
+	}
+}
+
+// expiration converts a memcached exptime to a cache duration
+func expiration(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return cache.NoExpiration
+	case exptime < 0:
+		// Already expired
+		return time.Nanosecond
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second
+	}
+	d := time.Until(time.Unix(exptime, 0))
+	if d <= 0 {
+		return time.Nanosecond
+	}
+	return d
+}
+
+// validKey reports whether k is a valid memcached key
+func validKey(k string) bool {
+	if len(k) == 0 || len(k) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// nextCAS returns a new CAS unique. s.mu must be held.
+func (s *Server) nextCAS() uint64 {
+	s.cas++
+	return s.cas
+}
+
+// Stats is a snapshot of the server counters.
+type Stats struct {
+	CurrConnections  int64
+	TotalConnections int64
+	CmdGet           uint64
+	CmdSet           uint64
+	CmdTouch         uint64
+	CmdFlush         uint64
+	GetHits          uint64
+	GetMisses        uint64
+}
+
+// Stats returns the server counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		CurrConnections:  s.currConns.Load(),
+		TotalConnections: s.totalConns.Load(),
+		CmdGet:           s.cmdGet.Load(),
+		CmdSet:           s.cmdSet.Load(),
+		CmdTouch:         s.cmdTouch.Load(),
+		CmdFlush:         s.cmdFlush.Load(),
+		GetHits:          s.getHits.Load(),
+		GetMisses:        s.getMisses.Load(),
+	}
+}
+
+// writeStats writes the response of the stats command
+func (s *Server) writeStats(w io.Writer) {
+	st := s.Stats()
+	cs := s.c.Stats()
+	now := time.Now()
+	stat := func(name string, v any) {
+		fmt.Fprintf(w, "STAT %s %v\r\n", name, v)
+	}
+	stat("uptime", int64(now.Sub(s.started).Seconds()))
+	stat("time", now.Unix())
+	stat("version", Version)
+	stat("curr_connections", st.CurrConnections)
+	stat("total_connections", st.TotalConnections)
+	stat("cmd_get", st.CmdGet)
+	stat("cmd_set", st.CmdSet)
+	stat("cmd_touch", st.CmdTouch)
+	stat("cmd_flush", st.CmdFlush)
+	stat("get_hits", st.GetHits)
+	stat("get_misses", st.GetMisses)
+	stat("curr_items", s.c.ItemCount())
+	stat("evictions", cs.Evictions)
+	// Not memcached's expired_unfetched: the cache doesn't know whether an
+	// expired item was ever fetched
+	stat("expirations", cs.Expirations)
+	io.WriteString(w, "END\r\n")
+}
+
+// parseUint parses a decimal protocol argument
+func parseUint(b []byte, bits int) (uint64, bool) {
+	v, err := strconv.ParseUint(string(b), 10, bits)
+	return v, err == nil
+}
+
+// parseInt parses a decimal protocol argument that may be negative
+func parseInt(b []byte) (int64, bool) {
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	return v, err == nil
+}
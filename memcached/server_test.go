@@ -0,0 +1,194 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, opts ...Option) (*cache.Any[string, []byte], *testClient) {
+	t.Helper()
+	c := cache.New[string, []byte](cache.NoExpiration, 0)
+	s := NewServer(c, opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Error("Serve returned", err)
+		}
+	})
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return c, &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends req and checks that the response is want
+func (c *testClient) do(req, want string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	got := make([]byte, 0, len(want))
+	for len(got) < len(want) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatalf("%q: read %q, then %v", req, got, err)
+		}
+		got = append(got, b)
+	}
+	if string(got) != want {
+		c.t.Errorf("%q: got %q, want %q", req, got, want)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("set foo 5 0 3\r\nbar\r\n", "STORED\r\n")
+	cl.do("get foo\r\n", "VALUE foo 5 3\r\nbar\r\nEND\r\n")
+	cl.do("gets foo missing\r\n", "VALUE foo 5 3 1\r\nbar\r\nEND\r\n")
+	cl.do("add foo 0 0 1\r\nx\r\n", "NOT_STORED\r\n")
+	cl.do("replace missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n")
+	cl.do("add new 0 0 0\r\n\r\n", "STORED\r\n")
+	cl.do("replace foo 7 0 4\r\nbaz!\r\n", "STORED\r\n")
+	cl.do("gets foo new\r\n", "VALUE foo 7 4 3\r\nbaz!\r\nVALUE new 0 0 2\r\n\r\nEND\r\n")
+	if v, _ := c.Get("foo"); string(v) != "baz!" {
+		t.Errorf("cache holds %q", v)
+	}
+	cl.do("set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", "VALUE quiet 0 1\r\nq\r\nEND\r\n")
+	cl.do("delete foo\r\n", "DELETED\r\n")
+	cl.do("delete foo\r\n", "NOT_FOUND\r\n")
+	cl.do("set foo 0 0 3\r\ntoolong\r\n", "CLIENT_ERROR bad data chunk\r\n")
+	cl.do("bogus\r\n", "ERROR\r\n")
+	cl.do("version\r\n", "VERSION "+Version+"\r\n")
+}
+
+func TestFlagsFollowValue(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("set a 5 0 1\r\na\r\n", "STORED\r\n")
+	cl.do("set n 9 0 1\r\n1\r\n", "STORED\r\n")
+	cl.do("incr n 1\r\n", "2\r\n")
+	cl.do("get n\r\n", "VALUE n 9 1\r\n2\r\nEND\r\n")
+	// Written behind the server's back: the flags no longer apply
+	c.Set("a", []byte("b"), cache.NoExpiration)
+	cl.do("gets a\r\n", "VALUE a 0 1 0\r\nb\r\nEND\r\n")
+	cl.do("set a 7 0 1\r\nc\r\n", "STORED\r\n")
+	cl.do("get a\r\n", "VALUE a 7 1\r\nc\r\nEND\r\n")
+}
+
+func TestMetaPruned(t *testing.T) {
+	c := cache.New[string, []byte](cache.NoExpiration, 0)
+	s := NewServer(c)
+	defer s.Close()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	for i := 0; i < 2*metaPruneMin; i++ {
+		k := fmt.Sprint("k", i)
+		v := []byte("v")
+		c.Set(k, v, cache.NoExpiration)
+		s.remember(k, 1, v)
+	}
+	// Flush doesn't call the OnEvicted listeners
+	c.Flush()
+	v := []byte("v")
+	c.Set("k", v, cache.NoExpiration)
+	s.remember("k", 1, v)
+	s.mu.Lock()
+	n := len(s.meta)
+	s.mu.Unlock()
+	if n > 2*c.ItemCount()+metaPruneMin {
+		t.Error("meta of removed items kept:", n)
+	}
+	if m := s.lookup("k", v); m.flags != 1 {
+		t.Error("meta of a live item dropped:", m)
+	}
+}
+
+func TestMaxItemSize(t *testing.T) {
+	_, cl := startServer(t, WithMaxItemSize(4))
+	cl.do("set big 0 0 5\r\n12345\r\n", "SERVER_ERROR object too large for cache\r\n")
+	cl.do("set ok 0 0 4\r\n1234\r\n", "STORED\r\n")
+}
+
+func TestIncrDecr(t *testing.T) {
+	_, cl := startServer(t)
+	cl.do("incr n 1\r\n", "NOT_FOUND\r\n")
+	cl.do("set n 0 0 2\r\n10\r\n", "STORED\r\n")
+	cl.do("incr n 5\r\n", "15\r\n")
+	cl.do("decr n 20\r\n", "0\r\n")
+	cl.do("set n 0 0 20\r\n18446744073709551615\r\n", "STORED\r\n")
+	cl.do("incr n 1\r\n", "CLIENT_ERROR increment overflow\r\n")
+	cl.do("get n\r\n", "VALUE n 0 20\r\n18446744073709551615\r\nEND\r\n")
+	cl.do("set s 0 0 3\r\nabc\r\n", "STORED\r\n")
+	cl.do("incr s 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	cl.do("incr n x\r\n", "CLIENT_ERROR invalid numeric delta argument\r\n")
+}
+
+func TestTouchAndFlush(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("set a 0 0 1\r\na\r\n", "STORED\r\n")
+	cl.do("set b 0 1 1\r\nb\r\n", "STORED\r\n")
+	cl.do("touch a 100\r\n", "TOUCHED\r\n")
+	cl.do("touch missing 100\r\n", "NOT_FOUND\r\n")
+	if _, e, _ := c.GetWithExpiration("a"); time.Until(e) < 99*time.Second {
+		t.Error("touch didn't set the expiration:", e)
+	}
+	cl.do("touch b 0\r\n", "TOUCHED\r\n")
+	if _, e, _ := c.GetWithExpiration("b"); !e.IsZero() {
+		t.Error("touch with 0 didn't remove the expiration:", e)
+	}
+	cl.do("set gone 0 -1 1\r\nx\r\n", "STORED\r\n")
+	cl.do("get gone\r\n", "END\r\n")
+	cl.do("flush_all\r\n", "OK\r\n")
+	cl.do("get a b\r\n", "END\r\n")
+}
+
+func TestStats(t *testing.T) {
+	_, cl := startServer(t)
+	cl.do("set a 0 0 1\r\na\r\n", "STORED\r\n")
+	cl.do("get a missing\r\n", "VALUE a 0 1\r\na\r\nEND\r\n")
+	cl.conn.Write([]byte("stats\r\n"))
+	stats := map[string]string{}
+	for {
+		line, err := cl.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "END" {
+			break
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != "STAT" {
+			t.Fatal("unexpected stats line", line)
+		}
+		stats[f[1]] = f[2]
+	}
+	for k, want := range map[string]string{"cmd_get": "2", "cmd_set": "1", "get_hits": "1", "get_misses": "1", "curr_items": "1", "curr_connections": "1"} {
+		if stats[k] != want {
+			t.Errorf("%s is %q, want %q", k, stats[k], want)
+		}
+	}
+	cl.do("quit\r\n", "")
+	if _, err := cl.r.ReadByte(); err == nil {
+		t.Error("connection was not closed by quit")
+	}
+}
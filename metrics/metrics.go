@@ -0,0 +1,144 @@
+// Package metrics exports go-cache statistics to monitoring systems.
+//
+// The Collector interface mirrors prometheus.Collector so a cache can be
+// plugged into a Prometheus registry with a thin adapter, without go-cache
+// itself depending on the Prometheus client library.
+package metrics
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// Source is anything that reports cache statistics. Both cache.Any and
+// cache.Number satisfy it.
+type Source interface {
+	Stats() cache.Stats
+	ItemCount() int
+}
+
+// ValueType tells whether a metric is a monotonically increasing counter or
+// a gauge that can go up and down.
+type ValueType int
+
+const (
+	CounterValue ValueType = iota + 1
+	GaugeValue
+)
+
+// Desc describes a metric, like prometheus.Desc.
+type Desc struct {
+	Name        string
+	Help        string
+	Type        ValueType
+	ConstLabels map[string]string
+}
+
+// Metric is a single sample of the metric described by Desc.
+type Metric struct {
+	Desc  *Desc
+	Value float64
+}
+
+// Collector is the local equivalent of prometheus.Collector.
+type Collector interface {
+	Describe(chan<- *Desc)
+	Collect(chan<- Metric)
+}
+
+// Registerer is the local equivalent of prometheus.Registerer.
+type Registerer interface {
+	Register(Collector) error
+}
+
+// CacheCollector collects the counters and gauges of one cache.
+type CacheCollector struct {
+	src Source
+
+	hits        *Desc
+	misses      *Desc
+	sets        *Desc
+	deletes     *Desc
+	expirations *Desc
+	evictions   *Desc
+	items       *Desc
+}
+
+// NewCollector returns a Collector for src. Every metric carries a "cache"
+// label set to name.
+func NewCollector(name string, src Source) *CacheCollector {
+	labels := map[string]string{"cache": name}
+	desc := func(n, help string, t ValueType) *Desc {
+		return &Desc{Name: "go_cache_" + n, Help: help, Type: t, ConstLabels: labels}
+	}
+	return &CacheCollector{
+		src:         src,
+		hits:        desc("hits_total", "Number of lookups that found an unexpired item.", CounterValue),
+		misses:      desc("misses_total", "Number of lookups that found nothing or an expired item.", CounterValue),
+		sets:        desc("sets_total", "Number of items written.", CounterValue),
+		deletes:     desc("deletes_total", "Number of items removed by Delete.", CounterValue),
+		expirations: desc("expirations_total", "Number of expired items removed by DeleteExpired or to make room.", CounterValue),
+		evictions:   desc("evictions_total", "Number of unexpired items evicted to stay within the capacity.", CounterValue),
+		items:       desc("items", "Number of items in the cache, including expired items not yet cleaned up.", GaugeValue),
+	}
+}
+
+// Describe sends the descriptors of all metrics collected by c.
+func (c *CacheCollector) Describe(ch chan<- *Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.sets
+	ch <- c.deletes
+	ch <- c.expirations
+	ch <- c.evictions
+	ch <- c.items
+}
+
+// Collect sends the current value of every metric.
+func (c *CacheCollector) Collect(ch chan<- Metric) {
+	s := c.src.Stats()
+	ch <- Metric{c.hits, float64(s.Hits)}
+	ch <- Metric{c.misses, float64(s.Misses)}
+	ch <- Metric{c.sets, float64(s.Sets)}
+	ch <- Metric{c.deletes, float64(s.Deletes)}
+	ch <- Metric{c.expirations, float64(s.Expirations)}
+	ch <- Metric{c.evictions, float64(s.Evictions)}
+	ch <- Metric{c.items, float64(c.src.ItemCount())}
+}
+
+// Register creates a Collector for src and registers it with r.
+func Register(r Registerer, name string, src Source) (*CacheCollector, error) {
+	c := NewCollector(name, src)
+	if err := r.Register(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var (
+	expvarOnce sync.Once
+	expvarMap  *expvar.Map
+)
+
+// PublishExpvar publishes the statistics of src in the "go_cache" expvar map
+// under name. Publishing another cache with the same name replaces it.
+func PublishExpvar(name string, src Source) {
+	expvarOnce.Do(func() {
+		expvarMap = expvar.NewMap("go_cache")
+	})
+	expvarMap.Set(name, expvar.Func(func() any {
+		s := src.Stats()
+		return map[string]any{
+			"hits":        s.Hits,
+			"misses":      s.Misses,
+			"sets":        s.Sets,
+			"deletes":     s.Deletes,
+			"expirations": s.Expirations,
+			"evictions":   s.Evictions,
+			"hit_ratio":   s.HitRatio(),
+			"items":       src.ItemCount(),
+		}
+	}))
+}
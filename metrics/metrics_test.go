@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/Akvicor/go-cache"
+)
+
+type testRegistry struct {
+	collectors []Collector
+}
+
+func (r *testRegistry) Register(c Collector) error {
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *testRegistry) gather() map[string]Metric {
+	m := map[string]Metric{}
+	for _, c := range r.collectors {
+		ch := make(chan Metric, 16)
+		c.Collect(ch)
+		close(ch)
+		for v := range ch {
+			m[v.Desc.ConstLabels["cache"]+"/"+v.Desc.Name] = v
+		}
+	}
+	return m
+}
+
+func TestRegister(t *testing.T) {
+	tc := cache.New[string, int](cache.DefaultExpiration, 0)
+	tc.Set("a", 1, cache.DefaultExpiration)
+	tc.Set("b", 2, cache.DefaultExpiration)
+	tc.Get("a")
+	tc.Get("c")
+
+	r := &testRegistry{}
+	c, err := Register(r, "users", tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descs := make(chan *Desc, 16)
+	c.Describe(descs)
+	close(descs)
+	n := 0
+	for d := range descs {
+		if d.ConstLabels["cache"] != "users" {
+			t.Error("desc is not labelled with the cache name:", d.Name)
+		}
+		n++
+	}
+	if n != 7 {
+		t.Error("expected 7 descriptors, got", n)
+	}
+
+	m := r.gather()
+	if v := m["users/go_cache_hits_total"]; v.Value != 1 || v.Desc.Type != CounterValue {
+		t.Error("unexpected hits metric:", v.Value)
+	}
+	if v := m["users/go_cache_misses_total"]; v.Value != 1 {
+		t.Error("unexpected misses metric:", v.Value)
+	}
+	if v := m["users/go_cache_items"]; v.Value != 2 || v.Desc.Type != GaugeValue {
+		t.Error("unexpected items metric:", v.Value)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	tc := cache.NewNumber[string, int](cache.DefaultExpiration, 0)
+	tc.Set("a", 1, cache.DefaultExpiration)
+	PublishExpvar("counters", tc)
+
+	v := expvar.Get("go_cache").(*expvar.Map).Get("counters")
+	if v == nil {
+		t.Fatal("counters was not published")
+	}
+	var s map[string]float64
+	if err := json.Unmarshal([]byte(v.String()), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s["sets"] != 1 || s["items"] != 1 {
+		t.Error("unexpected expvar values:", s)
+	}
+}
@@ -0,0 +1,48 @@
+// Package msgpackcodec provides a MessagePack cache.Codec.
+//
+// It lives in its own module so that go-cache itself does not depend on a
+// MessagePack library.
+package msgpackcodec
+
+import (
+	"io"
+
+	"github.com/Akvicor/go-cache"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes items as a stream of MessagePack maps.
+type Codec[K comparable, V any] struct{}
+
+func (Codec[K, V]) Name() string {
+	return "msgpack"
+}
+
+func (Codec[K, V]) NewEncoder(w io.Writer) cache.Encoder[K, V] {
+	return &encoder[K, V]{enc: msgpack.NewEncoder(w)}
+}
+
+func (Codec[K, V]) NewDecoder(r io.Reader) cache.Decoder[K, V] {
+	return &decoder[K, V]{dec: msgpack.NewDecoder(r)}
+}
+
+type encoder[K comparable, V any] struct {
+	enc *msgpack.Encoder
+}
+
+func (e *encoder[K, V]) Encode(r cache.Record[K, V]) error {
+	if err := e.enc.Encode(&r); err != nil {
+		return &cache.EncodeError{Key: r.Key, Err: err}
+	}
+	return nil
+}
+
+type decoder[K comparable, V any] struct {
+	dec *msgpack.Decoder
+}
+
+func (d *decoder[K, V]) Decode() (cache.Record[K, V], error) {
+	var r cache.Record[K, V]
+	err := d.dec.Decode(&r)
+	return r, err
+}
@@ -0,0 +1,47 @@
+package msgpackcodec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+type testStruct struct {
+	Num  int
+	Tags []string
+}
+
+func TestCodec(t *testing.T) {
+	tc := cache.New[string, testStruct](cache.DefaultExpiration, 0)
+	tc.Set("a", testStruct{1, []string{"x"}}, cache.DefaultExpiration)
+	tc.Set("b", testStruct{2, nil}, 1*time.Hour)
+
+	fp := &bytes.Buffer{}
+	if err := tc.SaveWith(fp, Codec[string, testStruct]{}); err != nil {
+		t.Fatal(err)
+	}
+	oc := cache.New[string, testStruct](cache.DefaultExpiration, 0)
+	if err := oc.LoadWith(fp, Codec[string, testStruct]{}); err != nil {
+		t.Fatal(err)
+	}
+	a, found := oc.Get("a")
+	if !found || a.Num != 1 || len(a.Tags) != 1 || a.Tags[0] != "x" {
+		t.Error("a was not loaded:", a)
+	}
+	_, exp, found := oc.GetWithExpiration("b")
+	_, want, _ := tc.GetWithExpiration("b")
+	if !found || !exp.Equal(want) {
+		t.Error("expiration of b was not kept:", exp, want)
+	}
+}
+
+func TestCodecEncodeError(t *testing.T) {
+	tc := cache.New[string, any](cache.DefaultExpiration, 0)
+	tc.Set("chan", make(chan int), cache.DefaultExpiration)
+	err := tc.SaveWith(&bytes.Buffer{}, Codec[string, any]{})
+	if e, ok := err.(*cache.EncodeError); !ok || e.Key != "chan" {
+		t.Error("unexpected error:", err)
+	}
+}
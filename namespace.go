@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// budget is the capacity shared by a cache and its namespaces
+type budget[K comparable, V any] struct {
+	capacity int
+	used     atomic.Int64 // items held by all members
+	mu       sync.Mutex
+	members  []*cache[K, V]
+}
+
+// Namespace returns a view of c holding the items of the namespace called
+// name, creating it on first use. A namespace is a cache of its own, with its
+// own keys, default expiration, stats, events and Flush, which clears only
+// the namespace. If defaultExpiration is 0 (DefaultExpiration), the default
+// expiration of c is used. Calling Namespace again with the same name returns
+// the same namespace and ignores defaultExpiration.
+//
+// Namespaces share the capacity given to c with WithCapacity, and the items
+// of c itself count against it too: adding a key when the total is reached
+// evicts an item from whichever of them holds the most items. Concurrent
+// writers may briefly exceed the capacity by a few items. The janitor of c
+// also deletes the expired items of its namespaces, and Close closes them.
+//
+// Besides the capacity and the janitor, a namespace only takes over the
+// panic handler of c and WithPreciseExpiration. Its items are not written to
+// the write-ahead log, snapshots or Save output of c, not passed to the Sink
+// of c, and not invalidated through the Transport of WithInvalidation.
+func (c *Any[K, V]) Namespace(name string, defaultExpiration time.Duration) *Any[K, V] {
+	return &Any[K, V]{c.namespace(name, defaultExpiration)}
+}
+
+// Namespace returns a view of c holding the items of the namespace called
+// name. See Any.Namespace.
+func (c *Number[K, V]) Namespace(name string, defaultExpiration time.Duration) *Number[K, V] {
+	return &Number[K, V]{c.namespace(name, defaultExpiration)}
+}
+
+// namespace returns the namespace called name, creating it on first use. c.mu
+// must not be held.
+func (c *cache[K, V]) namespace(name string, d time.Duration) *cache[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ns, found := c.namespaces[name]; found {
+		return ns
+	}
+	if c.budget == nil {
+		c.budget = &budget[K, V]{capacity: c.capacity, members: []*cache[K, V]{c}}
+		c.size.Store(int64(len(c.items)))
+		c.budget.used.Store(int64(len(c.items)))
+	}
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	ns := newCache(d, map[K]Item[V]{}, options{})
+	ns.addValue = c.addValue
+	ns.budget = c.budget
+	ns.namespaced = true
+	ns.evicted.onPanic = c.evicted.onPanic
+	if c.expiry != nil {
+		ns.expiry = &expiry[K]{}
+	}
+	c.budget.mu.Lock()
+	c.budget.members = append(c.budget.members, ns)
+	c.budget.mu.Unlock()
+	if c.namespaces == nil {
+		c.namespaces = map[string]*cache[K, V]{}
+	}
+	c.namespaces[name] = ns
+	return ns
+}
+
+// subspaces returns the namespaces created on c
+func (c *cache[K, V]) subspaces() []*cache[K, V] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.namespaces) == 0 {
+		return nil
+	}
+	list := make([]*cache[K, V], 0, len(c.namespaces))
+	for _, ns := range c.namespaces {
+		list = append(list, ns)
+	}
+	return list
+}
+
+// sized records that delta items were added to or removed from c. c.mu must
+// be held.
+func (c *cache[K, V]) sized(delta int) {
+	if c.budget != nil {
+		c.size.Add(int64(delta))
+		c.budget.used.Add(int64(delta))
+	}
+}
+
+// makeBudgetRoom is makeRoom for a cache sharing its capacity with its
+// namespaces. The member holding the most items gives up one of them. If
+// that member is busy, c evicts one of its own items instead, if it has any.
+// c.mu must be held.
+func (c *cache[K, V]) makeBudgetRoom(evs []Event[K, V]) []Event[K, V] {
+	b := c.budget
+	if b.capacity <= 0 || b.used.Load() < int64(b.capacity) {
+		return evs
+	}
+	if other := b.largest(c); other != nil {
+		if evs, ok := other.evictShared(evs); ok {
+			return evs
+		}
+	}
+	if len(c.items) == 0 {
+		return evs
+	}
+	return c.evict(evs)
+}
+
+// largest returns the member holding more items than c and more than any
+// other member, or nil if that is c
+func (b *budget[K, V]) largest(c *cache[K, V]) *cache[K, V] {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var m *cache[K, V]
+	n := c.size.Load()
+	for _, o := range b.members {
+		if s := o.size.Load(); s > n {
+			m, n = o, s
+		}
+	}
+	return m
+}
+
+// evictShared evicts an item of c to make room in another member of its
+// budget, appending the events of the eviction to evs for the other member
+// to publish once it unlocks. Called with the lock of the other member held,
+// so it gives up instead of waiting for c.mu.
+func (c *cache[K, V]) evictShared(evs []Event[K, V]) ([]Event[K, V], bool) {
+	if !c.mu.TryLock() {
+		return evs, false
+	}
+	if len(c.items) == 0 {
+		c.mu.Unlock()
+		return evs, false
+	}
+	own := c.evict(nil)
+	c.mu.Unlock()
+	for _, ev := range own {
+		ev.owner = c
+		evs = append(evs, ev)
+	}
+	return evs, true
+}
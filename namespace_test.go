@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	tc := New[string, int](time.Hour, 0)
+	users := tc.Namespace("users", time.Minute)
+	orders := tc.Namespace("orders", DefaultExpiration)
+	if tc.Namespace("users", time.Hour).cache != users.cache {
+		t.Error("Namespace returned a new namespace for the same name")
+	}
+	tc.Set("1", 0, DefaultExpiration)
+	users.Set("1", 1, DefaultExpiration)
+	orders.Set("1", 2, DefaultExpiration)
+	for _, c := range []*Any[string, int]{tc, users, orders} {
+		if c.ItemCount() != 1 {
+			t.Error("unexpected item count:", c.ItemCount())
+		}
+	}
+	if v, _ := users.Get("1"); v != 1 {
+		t.Error("users holds", v)
+	}
+	_, e, _ := users.GetWithExpiration("1")
+	if d := time.Until(e); d > time.Minute {
+		t.Error("default expiration of the namespace not used:", d)
+	}
+	_, e, _ = orders.GetWithExpiration("1")
+	if d := time.Until(e); d < 59*time.Minute {
+		t.Error("default expiration of the parent not used:", d)
+	}
+	if users.Stats().Hits != 2 || orders.Stats().Hits != 1 || tc.Stats().Hits != 0 {
+		t.Error("stats not kept per namespace")
+	}
+
+	users.Flush()
+	if users.ItemCount() != 0 || orders.ItemCount() != 1 || tc.ItemCount() != 1 {
+		t.Error("Flush cleared other namespaces")
+	}
+
+	// The parent cleans up its namespaces
+	orders.Set("old", 3, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	tc.DeleteExpired()
+	if orders.ItemCount() != 1 {
+		t.Error("expired item of a namespace not deleted")
+	}
+}
+
+func TestNamespaceJanitor(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 5*time.Millisecond)
+	defer tc.Close()
+	ns := tc.Namespace("ns", 10*time.Millisecond)
+	ns.Set("a", 1, DefaultExpiration)
+	time.Sleep(50 * time.Millisecond)
+	ns.mu.RLock()
+	n := len(ns.items)
+	ns.mu.RUnlock()
+	if n != 0 {
+		t.Error("janitor did not clean the namespace")
+	}
+}
+
+func TestNamespaceCapacity(t *testing.T) {
+	tc := New[string, int](NoExpiration, 0, WithCapacity(10))
+	a := tc.Namespace("a", DefaultExpiration)
+	b := tc.Namespace("b", DefaultExpiration)
+	for i := 0; i < 10; i++ {
+		a.Set(fmt.Sprint(i), i, DefaultExpiration)
+	}
+	// b takes its room from a, the largest namespace
+	for i := 0; i < 4; i++ {
+		b.Set(fmt.Sprint(i), i, DefaultExpiration)
+	}
+	if a.ItemCount() != 6 || b.ItemCount() != 4 {
+		t.Errorf("a holds %d items, b %d", a.ItemCount(), b.ItemCount())
+	}
+	if a.Stats().Evictions != 4 {
+		t.Error("evictions not counted by the namespace:", a.Stats().Evictions)
+	}
+	// Until both hold the same number, then b evicts its own
+	for i := 4; i < 20; i++ {
+		b.Set(fmt.Sprint(i), i, DefaultExpiration)
+	}
+	total := tc.ItemCount() + a.ItemCount() + b.ItemCount()
+	if total != 10 || b.ItemCount() < 5 || a.ItemCount() > 5 {
+		t.Errorf("a holds %d items, b %d", a.ItemCount(), b.ItemCount())
+	}
+	// Removing items frees room for the others
+	b.Flush()
+	tc.Set("x", 1, DefaultExpiration)
+	if tc.ItemCount() != 1 || a.ItemCount() != 5 {
+		t.Errorf("parent holds %d items, a %d", tc.ItemCount(), a.ItemCount())
+	}
+	b.Set("y", 1, DefaultExpiration)
+	b.Delete("y")
+	if n := tc.budget.used.Load(); n != 6 {
+		t.Error("budget counts", n, "items")
+	}
+}
+
+func TestNamespaceNumber(t *testing.T) {
+	nc := NewNumber[string, int](NoExpiration, 0)
+	hits := nc.Namespace("hits", DefaultExpiration)
+	hits.Set("a", 1, DefaultExpiration)
+	if err := hits.Increment("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := hits.Get("a"); v != 3 {
+		t.Error("hits holds", v)
+	}
+	if _, found := nc.Get("a"); found {
+		t.Error("namespace item visible in the parent")
+	}
+}
+
+func TestNamespaceEvictionEvents(t *testing.T) {
+	tc := New[string, int](NoExpiration, 0, WithCapacity(2))
+	ns := tc.Namespace("ns", DefaultExpiration)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	parent := tc.Subscribe(nil, 10, OverflowDrop)
+	child := ns.Subscribe(nil, 10, OverflowDrop)
+	var evicted []string
+	tc.OnEvicted(func(k string, v int, hit int) { evicted = append(evicted, k) })
+	ns.Set("c", 3, DefaultExpiration)
+	// Published by the writer before Set returns, on the cache that lost the item
+	if len(parent) != 1 || len(child) != 1 || len(evicted) != 1 {
+		t.Fatal("unexpected events:", len(parent), len(child), evicted)
+	}
+	if ev := <-parent; ev.Type != EventEvict || ev.Key != evicted[0] {
+		t.Error("unexpected event of the parent:", ev)
+	}
+	if ev := <-child; ev.Type != EventSet || ev.Key != "c" {
+		t.Error("unexpected event of the namespace:", ev)
+	}
+}
@@ -0,0 +1,155 @@
+package cache
+
+import "time"
+
+// Option configures optional cache behaviour at construction time.
+type Option func(*options)
+
+type options struct {
+	evictWorkers int
+	evictQueue   int
+	onPanic      func(recovered any)
+
+	snapshotPath     string
+	snapshotInterval time.Duration
+	onSnapshot       func(op Op, path string, err error)
+
+	walPath   string
+	walPolicy SyncPolicy
+
+	capacity int
+
+	transport      Transport
+	onInvalidError func(err error)
+
+	janitor *Janitor
+
+	precise bool
+}
+
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithAsyncEviction dispatches OnEvicted listeners on a pool of workers
+// goroutines instead of the goroutine that removed the item. Up to queue
+// batches of evicted items wait for a free worker; once the queue is full the
+// removing goroutine blocks until there is room.
+func WithAsyncEviction(workers, queue int) Option {
+	return func(o *options) {
+		if workers < 1 {
+			workers = 1
+		}
+		if queue < 0 {
+			queue = 0
+		}
+		o.evictWorkers = workers
+		o.evictQueue = queue
+	}
+}
+
+// WithPanicHandler is called with the recovered value when an OnEvicted
+// listener panics. Panicking listeners never crash the caller or the janitor,
+// without a handler the panic is discarded.
+func WithPanicHandler(f func(recovered any)) Option {
+	return func(o *options) {
+		o.onPanic = f
+	}
+}
+
+// WithSnapshot restores the cache from the snapshot file path when it is
+// created, skipping items that have expired in the meantime. If interval is
+// positive the cache is saved to path every interval in the background, and
+// once more by Close. A missing file is not an error.
+func WithSnapshot(path string, interval time.Duration) Option {
+	return func(o *options) {
+		o.snapshotPath = path
+		o.snapshotInterval = interval
+	}
+}
+
+// WithSnapshotHook is called after every restore (op is OpLoad) and save (op
+// is OpSave) done for WithSnapshot, with a nil error on success.
+func WithSnapshotHook(f func(op Op, path string, err error)) Option {
+	return func(o *options) {
+		o.onSnapshot = f
+	}
+}
+
+// WithWAL appends every Set, Add, Replace, Delete, Increment, Decrement,
+// UpdateExpiration, Flush and Load to the write-ahead log at path, synced
+// according to policy. The log is replayed when the cache is created, which
+// makes it the source of truth: a snapshot given to WithSnapshot is still
+// saved, but not restored. Use CompactWAL to keep the log from growing
+// without bound, and WALError to check for write errors.
+func WithWAL(path string, policy SyncPolicy) Option {
+	return func(o *options) {
+		o.walPath = path
+		o.walPolicy = policy
+	}
+}
+
+// WithCapacity limits the cache to n items. Adding a new key to a full cache
+// first evicts an expired item or, failing that, the item expiring soonest
+// among a small random sample. Evicted items are passed to the OnEvicted
+// listeners and published as EventEvict, unless a disk tier attached with
+// SetDiskTier takes them. Items given to NewFrom are not evicted until a new
+// key is added.
+func WithCapacity(n int) Option {
+	return func(o *options) {
+		o.capacity = n
+	}
+}
+
+// WithInvalidation keeps the replicas of a cache in sync through t. Every key
+// changed by Set, Add, Replace, Update, Increment, Decrement, Delete, Load
+// and the like, and every Flush, is published to the other replicas, which
+// drop the key (or all keys) so their next Get misses instead of returning a
+// stale value. Keys dropped that way are passed to the OnEvicted listeners
+// and published as EventDelete like with Delete, but are not published to
+// the replicas again. Writes of a key on two replicas at about the same time
+// drop each other's values. Items given to NewFrom are not published. Keys
+// are gob-encoded.
+//
+// Messages are published in the background, shortly after the change; Close
+// publishes the remaining ones and unsubscribes from t, which is left open.
+func WithInvalidation(t Transport) Option {
+	return func(o *options) {
+		o.transport = t
+	}
+}
+
+// WithInvalidationErrorHandler is called when an invalidation could not be
+// published or a received message could not be decoded.
+func WithInvalidationErrorHandler(f func(err error)) Option {
+	return func(o *options) {
+		o.onInvalidError = f
+	}
+}
+
+// WithJanitor deletes the expired items every cleanupInterval on j, shared
+// with other caches, instead of a goroutine of the cache's own. Use
+// DefaultJanitor to share one goroutine across the program. Has no effect if
+// cleanupInterval is not positive.
+func WithJanitor(j *Janitor) Option {
+	return func(o *options) {
+		o.janitor = j
+	}
+}
+
+// WithPreciseExpiration deletes every item as soon as it expires, instead of
+// on the next run of the janitor, so the OnEvicted listeners, EventExpire
+// subscribers and callbacks of SetWithCallback learn of it within
+// milliseconds. A single timer is set to the earliest expiration; setting an
+// item costs a heap push, and keeps its old deadline queued until it passes
+// or the queue is compacted. Works with or without a cleanupInterval, and
+// applies to the namespaces of the cache too.
+func WithPreciseExpiration() Option {
+	return func(o *options) {
+		o.precise = true
+	}
+}
@@ -0,0 +1,62 @@
+// Package otelcache turns go-cache tracing callbacks into OpenTelemetry spans.
+//
+// It lives in its own module so that go-cache itself does not depend on
+// OpenTelemetry.
+package otelcache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Akvicor/go-cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Akvicor/go-cache/otelcache"
+
+// Tracer implements cache.Tracer on top of an OpenTelemetry TracerProvider.
+type Tracer struct {
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+// NewTracer returns a cache.Tracer creating spans from tp. Every span carries
+// a "cache.name" attribute set to name. The cache API does not take a
+// context, so the spans are started as roots.
+func NewTracer(tp trace.TracerProvider, name string) *Tracer {
+	return &Tracer{
+		tracer: tp.Tracer(instrumentationName),
+		attrs:  []attribute.KeyValue{attribute.String("cache.name", name)},
+	}
+}
+
+// Start implements cache.Tracer.
+func (t *Tracer) Start(op cache.Op, key any) cache.Span {
+	attrs := append([]attribute.KeyValue{attribute.String("cache.operation", string(op))}, t.attrs...)
+	if key != nil {
+		attrs = append(attrs, attribute.String("cache.key", fmt.Sprint(key)))
+	}
+	_, span := t.tracer.Start(context.Background(), "go-cache."+string(op),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...))
+	return spanAdapter{span}
+}
+
+type spanAdapter struct {
+	span trace.Span
+}
+
+// End implements cache.Span.
+func (s spanAdapter) End(e cache.SpanEnd) {
+	s.span.SetAttributes(
+		attribute.String("cache.outcome", string(e.Outcome)),
+		attribute.Int("cache.items", e.Items),
+	)
+	if e.Err != nil {
+		s.span.RecordError(e.Err)
+		s.span.SetStatus(codes.Error, e.Err.Error())
+	}
+	s.span.End()
+}
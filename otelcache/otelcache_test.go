@@ -0,0 +1,84 @@
+package otelcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Akvicor/go-cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attr(s tracetest.SpanStub, k attribute.Key) string {
+	for _, a := range s.Attributes {
+		if a.Key == k {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracer(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(context.Background())
+
+	tc := cache.New[string, int](cache.DefaultExpiration, 0)
+	tc.SetTracer(NewTracer(tp, "users"))
+	tc.Set("a", 1, cache.DefaultExpiration)
+	tc.Get("a")
+	tc.Get("b")
+	tc.Delete("a")
+	tc.DeleteExpired()
+
+	spans := exp.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans, want 5", len(spans))
+	}
+	want := []struct{ name, key, outcome string }{
+		{"go-cache.set", "a", "ok"},
+		{"go-cache.get", "a", "hit"},
+		{"go-cache.get", "b", "miss"},
+		{"go-cache.delete", "a", "ok"},
+		{"go-cache.delete_expired", "", "ok"},
+	}
+	for i, w := range want {
+		s := spans[i]
+		if s.Name != w.name {
+			t.Errorf("span %d: name %q, want %q", i, s.Name, w.name)
+		}
+		if got := attr(s, "cache.key"); got != w.key {
+			t.Errorf("span %d: key %q, want %q", i, got, w.key)
+		}
+		if got := attr(s, "cache.outcome"); got != w.outcome {
+			t.Errorf("span %d: outcome %q, want %q", i, got, w.outcome)
+		}
+		if got := attr(s, "cache.name"); got != "users" {
+			t.Errorf("span %d: cache name %q", i, got)
+		}
+	}
+}
+
+func TestTracerError(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	tc := cache.New[string, any](cache.DefaultExpiration, 0)
+	tc.Set("chan", make(chan int), cache.DefaultExpiration)
+	tc.SetTracer(NewTracer(tp, "broken"))
+	if err := tc.SaveFile(t.TempDir() + "/cache.dat"); err == nil {
+		t.Fatal("Save of a chan didn't fail")
+	}
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Error("save span status is not Error")
+	}
+	if len(spans[0].Events) == 0 {
+		t.Error("save error was not recorded")
+	}
+}
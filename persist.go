@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// MergeStrategy decides which item wins when Load finds a key that already
+// holds an unexpired item. Loaded items that have already expired are always
+// skipped.
+type MergeStrategy int
+
+const (
+	// MergeKeepExisting Keep the item already in the cache
+	MergeKeepExisting MergeStrategy = iota
+	// MergeOverwrite Replace the item in the cache with the loaded one
+	MergeOverwrite
+	// MergeKeepNewer Keep the item that expires last. Items without expiration
+	// count as the newest.
+	MergeKeepNewer
+)
+
+// mergeWins reports whether the loaded item li replaces the current item ci
+func mergeWins[V any](s MergeStrategy, ci Item[V], found bool, li Item[V], now int64) bool {
+	if li.Expiration > 0 && now > li.Expiration {
+		return false
+	}
+	if !found || (ci.Expiration > 0 && now > ci.Expiration) {
+		return true
+	}
+	switch s {
+	case MergeOverwrite:
+		return true
+	case MergeKeepNewer:
+		if ci.Expiration == 0 {
+			return false
+		}
+		return li.Expiration == 0 || li.Expiration > ci.Expiration
+	}
+	return false
+}
+
+// MergeRecords merges src into dst the way Load merges a snapshot into a
+// cache holding dst: a key of src replaces the record in dst according to s,
+// and expired records of src are skipped. The records of dst keep their
+// order and new keys are appended in the order of src.
+func MergeRecords[K comparable, V any](dst, src []Record[K, V], s MergeStrategy) []Record[K, V] {
+	index := make(map[K]int, len(dst))
+	for i, r := range dst {
+		index[r.Key] = i
+	}
+	now := time.Now().UnixNano()
+	for _, r := range src {
+		li := Item[V]{Value: r.Value, Expiration: r.Expiration, Hit: r.Hit}
+		i, found := index[r.Key]
+		var ci Item[V]
+		if found {
+			ci = Item[V]{Value: dst[i].Value, Expiration: dst[i].Expiration, Hit: dst[i].Hit}
+		}
+		if !mergeWins(s, ci, found, li, now) {
+			continue
+		}
+		if found {
+			dst[i] = r
+			continue
+		}
+		index[r.Key] = len(dst)
+		dst = append(dst, r)
+	}
+	return dst
+}
+
+// EncodeError reports the item that could not be serialized.
+type EncodeError struct {
+	Key any
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("cache: encoding item %v: %v", e.Key, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// isInterface reports whether V is an interface type, whose dynamic types
+// have to be registered with gob
+func isInterface[V any]() bool {
+	return reflect.TypeFor[V]().Kind() == reflect.Interface
+}
+
+// gobRegister registers the dynamic type of v, turning panics into an error
+func gobRegister[K comparable, V any](k K, v V) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = &EncodeError{Key: k, Err: fmt.Errorf("registering type %T with gob: %v", v, x)}
+		}
+	}()
+	if any(v) != nil {
+		gob.Register(v)
+	}
+	return nil
+}
+
+// Save Write the cache's items (using Gob) to an io.Writer.
+//
+// Only values stored in an interface typed cache (e.g. Any[K, any]) have
+// their dynamic types registered with gob; concrete value types are encoded
+// without touching the global gob registry. If an item can't be encoded the
+// error is an *EncodeError naming its key.
+//
+// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
+// documentation for NewFrom().)
+func (c *cache[K, V]) Save(w io.Writer) error {
+	return c.SaveWith(w, GobCodec[K, V]{})
+}
+
+// SaveWith Write the cache's unexpired items to an io.Writer using codec.
+func (c *cache[K, V]) SaveWith(w io.Writer, codec Codec[K, V]) (err error) {
+	_, err = c.save(codec.NewEncoder(w))
+	return err
+}
+
+// persistChunk is the number of items copied or stored per lock acquisition
+// while saving or loading
+const persistChunk = 1024
+
+// save encodes the unexpired items and returns how many were written.
+//
+// Only the keys are copied while holding the lock for the whole cache. The
+// items are then copied out persistChunk at a time and encoded without
+// holding the lock, so writers are only blocked briefly. The result is not a
+// point-in-time snapshot: items added after save started are skipped, items
+// changed or removed in the meantime are saved in their current state or not
+// at all.
+func (c *cache[K, V]) save(enc Encoder[K, V]) (n int, err error) {
+	sp := c.trace(OpSave)
+	defer func() {
+		sp.end(OutcomeOK, err, n)
+	}()
+	c.mu.RLock()
+	keys := make([]K, 0, len(c.items))
+	for k := range c.items {
+		keys = append(keys, k)
+	}
+	c.mu.RUnlock()
+	recs := make([]Record[K, V], 0, min(len(keys), persistChunk))
+	for len(keys) > 0 {
+		chunk := keys[:min(len(keys), persistChunk)]
+		keys = keys[len(chunk):]
+		recs = recs[:0]
+		now := time.Now().UnixNano()
+		c.mu.RLock()
+		for _, k := range chunk {
+			v, found := c.items[k]
+			// "Inlining" of Expired
+			if !found || (v.Expiration > 0 && now > v.Expiration) {
+				continue
+			}
+			recs = append(recs, Record[K, V]{Key: k, Value: v.Value, Expiration: v.Expiration, Hit: v.Hit})
+		}
+		c.mu.RUnlock()
+		for _, r := range recs {
+			if err = enc.Encode(r); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Save the cache's items to the given filename as a (Gob-encoded) snapshot,
+// creating the file if it doesn't exist, and atomically replacing it if it
+// does. See SaveFileWith.
+//
+// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
+// documentation for NewFrom().)
+func (c *cache[K, V]) SaveFile(fname string) error {
+	return c.SaveFileWith(fname, GobCodec[K, V]{})
+}
+
+// Add (Gob-serialized) cache items from an io.Reader, excluding any items with
+// keys that already exist (and haven't expired) in the current cache. The
+// single gob-encoded map written by Save of earlier versions is read as well.
+//
+// A stream has no checksum, so all of it is decoded, and held in memory,
+// before any item is added: a damaged stream leaves the cache unchanged.
+// Snapshot files, loaded with LoadFile, are checksummed instead and added a
+// chunk at a time.
+//
+// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
+// documentation for NewFrom().)
+func (c *cache[K, V]) Load(r io.Reader) error {
+	return c.LoadMerge(r, MergeKeepExisting)
+}
+
+// LoadMerge Add (Gob-serialized) cache items from an io.Reader, resolving keys
+// that already exist in the current cache with the given strategy.
+func (c *cache[K, V]) LoadMerge(r io.Reader, s MergeStrategy) error {
+	return c.LoadMergeWith(r, GobCodec[K, V]{}, s)
+}
+
+// LoadWith Add cache items decoded with codec from an io.Reader, excluding any
+// items with keys that already exist (and haven't expired) in the current
+// cache.
+func (c *cache[K, V]) LoadWith(r io.Reader, codec Codec[K, V]) error {
+	return c.LoadMergeWith(r, codec, MergeKeepExisting)
+}
+
+// LoadMergeWith Add cache items decoded with codec from an io.Reader,
+// resolving keys that already exist in the current cache with the given
+// strategy. The whole stream is decoded before any item is added, so a
+// stream that fails to decode leaves the cache unchanged.
+func (c *cache[K, V]) LoadMergeWith(r io.Reader, codec Codec[K, V], s MergeStrategy) error {
+	_, _, err := c.load(codec.NewDecoder(r), s)
+	return err
+}
+
+// load decodes records until io.EOF and, if all of them decode, adds them
+// persistChunk at a time. It returns the number of items added and the
+// number of records decoded.
+func (c *cache[K, V]) load(dec Decoder[K, V], s MergeStrategy) (n, records int, err error) {
+	sp := c.trace(OpLoad)
+	defer func() {
+		sp.end(OutcomeOK, err, n)
+	}()
+	recs, err := decodeAll(dec)
+	if err != nil {
+		return 0, len(recs), err
+	}
+	return c.mergeAll(recs, s), len(recs), nil
+}
+
+// decodeChunks decodes records until io.EOF and passes them to f
+// persistChunk at a time, reusing the slice. It returns the number of records
+// decoded.
+func decodeChunks[K comparable, V any](dec Decoder[K, V], f func([]Record[K, V])) (int, error) {
+	recs := make([]Record[K, V], 0, persistChunk)
+	n := 0
+	for {
+		rec, err := dec.Decode()
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if err == nil {
+			recs = append(recs, rec)
+			n++
+		}
+		if len(recs) > 0 && (len(recs) == persistChunk || err == io.EOF) {
+			f(recs)
+			clear(recs)
+			recs = recs[:0]
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+	}
+}
+
+// decodeAll decodes records until io.EOF. On error it returns the records
+// decoded before it.
+func decodeAll[K comparable, V any](dec Decoder[K, V]) ([]Record[K, V], error) {
+	var recs []Record[K, V]
+	for {
+		rec, err := dec.Decode()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// mergeAll adds recs persistChunk at a time, so that readers and writers are
+// not blocked for the whole load, and returns how many were added
+func (c *cache[K, V]) mergeAll(recs []Record[K, V], s MergeStrategy) (n int) {
+	for len(recs) > 0 {
+		chunk := recs[:min(len(recs), persistChunk)]
+		n += c.merge(chunk, s)
+		recs = recs[len(chunk):]
+	}
+	return n
+}
+
+// merge adds recs according to s and returns how many were added
+func (c *cache[K, V]) merge(recs []Record[K, V], s MergeStrategy) int {
+	if len(recs) == 0 {
+		return 0
+	}
+	n := 0
+	var evs []Event[K, V]
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	for _, r := range recs {
+		v := Item[V]{Value: r.Value, Expiration: r.Expiration, Hit: r.Hit}
+		ov, found := c.items[r.Key]
+		if mergeWins(s, ov, found, v, now) {
+			evs = c.store(r.Key, v, evs)
+			n++
+		}
+	}
+	c.mu.Unlock()
+	c.publish(evs)
+	return n
+}
+
+// Load and add cache items from the snapshot written to the given filename by
+// SaveFile or SaveFileWith with a built-in codec, excluding any items with
+// keys that already exist in the current cache.
+//
+// NOTE: This method is deprecated in favor of c.Items() and NewFrom() (see the
+// documentation for NewFrom().)
+func (c *cache[K, V]) LoadFile(fname string) error {
+	return c.LoadFileMerge(fname, MergeKeepExisting)
+}
+
+// LoadFileMerge Load and add cache items from the given snapshot file,
+// resolving keys that already exist in the current cache with the given
+// strategy. Snapshots with a bad checksum or another codec are refused with
+// a *SnapshotError before any item is added. The records are then decoded
+// and added a chunk at a time, so a body that doesn't decode, e.g. because V
+// changed, is reported after the records before it were added. Files written
+// by Save, or by SaveFile of earlier versions, which have no snapshot header,
+// are read as gob streams.
+func (c *cache[K, V]) LoadFileMerge(fname string, s MergeStrategy) error {
+	return c.loadSnapshot(fname, nil, s)
+}
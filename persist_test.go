@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"testing"
+	"time"
+)
+
+type persistConcrete struct {
+	Name string
+}
+
+func TestSaveConcreteDoesNotRegister(t *testing.T) {
+	tc := New[string, persistConcrete](DefaultExpiration, 0)
+	tc.Set("a", persistConcrete{"a"}, DefaultExpiration)
+	fp := &bytes.Buffer{}
+	if err := tc.Save(fp); err != nil {
+		t.Fatal(err)
+	}
+	// Panics if Save registered persistConcrete under its default name
+	gob.RegisterName("go-cache-test.persistConcrete", persistConcrete{})
+
+	oc := New[string, persistConcrete](DefaultExpiration, 0)
+	if err := oc.Load(fp); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := oc.Get("a"); v.Name != "a" {
+		t.Error("a was not loaded:", v)
+	}
+}
+
+func testMergeSource(t *testing.T) *bytes.Buffer {
+	src := New[string, int](DefaultExpiration, 0)
+	src.Set("shorter", 1, 1*time.Minute)
+	src.Set("longer", 1, 1*time.Hour)
+	src.Set("forever", 1, NoExpiration)
+	src.Set("new", 1, DefaultExpiration)
+	src.Set("expired", 1, 1*time.Millisecond)
+	fp := &bytes.Buffer{}
+	if err := src.Save(fp); err != nil {
+		t.Fatal(err)
+	}
+	<-time.After(5 * time.Millisecond)
+	return fp
+}
+
+func testMergeTarget() *Any[string, int] {
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("shorter", 2, 1*time.Hour)
+	tc.Set("longer", 2, 1*time.Minute)
+	tc.Set("forever", 2, 1*time.Hour)
+	return tc
+}
+
+func TestLoadMerge(t *testing.T) {
+	tests := []struct {
+		s    MergeStrategy
+		want map[string]int
+	}{
+		{MergeKeepExisting, map[string]int{"shorter": 2, "longer": 2, "forever": 2, "new": 1}},
+		{MergeOverwrite, map[string]int{"shorter": 1, "longer": 1, "forever": 1, "new": 1}},
+		{MergeKeepNewer, map[string]int{"shorter": 2, "longer": 1, "forever": 1, "new": 1}},
+	}
+	for _, tt := range tests {
+		tc := testMergeTarget()
+		if err := tc.LoadMerge(testMergeSource(t), tt.s); err != nil {
+			t.Fatal(err)
+		}
+		items := tc.Items()
+		if len(items) != len(tt.want) {
+			t.Errorf("strategy %d: got %d items, want %d", tt.s, len(items), len(tt.want))
+		}
+		for k, v := range tt.want {
+			if items[k].Value != v {
+				t.Errorf("strategy %d: %s is %d, want %d", tt.s, k, items[k].Value, v)
+			}
+		}
+		if _, found := tc.items["expired"]; found {
+			t.Errorf("strategy %d: expired item was loaded", tt.s)
+		}
+	}
+}
+
+// TestMergeRecords checks that MergeRecords agrees with LoadMerge
+func TestMergeRecords(t *testing.T) {
+	tests := []MergeStrategy{MergeKeepExisting, MergeOverwrite, MergeKeepNewer}
+	for _, s := range tests {
+		var dst []Record[string, int]
+		for k, v := range testMergeTarget().Items() {
+			dst = append(dst, Record[string, int]{Key: k, Value: v.Value, Expiration: v.Expiration})
+		}
+		var src []Record[string, int]
+		dec := GobCodec[string, int]{}.NewDecoder(testMergeSource(t))
+		for {
+			r, err := dec.Decode()
+			if err != nil {
+				break
+			}
+			src = append(src, r)
+		}
+		tc := testMergeTarget()
+		if err := tc.LoadMerge(testMergeSource(t), s); err != nil {
+			t.Fatal(err)
+		}
+		want := tc.Items()
+		got := MergeRecords(dst, src, s)
+		if len(got) != len(want) {
+			t.Errorf("strategy %d: got %d records, want %d", s, len(got), len(want))
+		}
+		for _, r := range got {
+			if want[r.Key].Value != r.Value {
+				t.Errorf("strategy %d: %s is %d, want %d", s, r.Key, r.Value, want[r.Key].Value)
+			}
+		}
+	}
+}
+
+func TestLoadFileMerge(t *testing.T) {
+	fname := t.TempDir() + "/cache.dat"
+	src := New[string, int](DefaultExpiration, 0)
+	src.Set("a", 1, DefaultExpiration)
+	if err := src.SaveFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 2, DefaultExpiration)
+	if err := tc.LoadFileMerge(fname, MergeOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tc.Get("a"); v != 1 {
+		t.Error("a was not overwritten:", v)
+	}
+}
+
+func TestSaveDoesNotBlockWriters(t *testing.T) {
+	tc := New[int, int](DefaultExpiration, 0)
+	for i := 0; i < 5*persistChunk; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	// An encoder that writes while the save is in progress would deadlock if
+	// the lock were held during encoding
+	enc := &testWritingEncoder{tc: tc}
+	n, err := tc.save(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5*persistChunk {
+		t.Error("unexpected number of saved items:", n)
+	}
+	if _, found := tc.Get(-1); !found {
+		t.Error("write during save was lost")
+	}
+}
+
+type testWritingEncoder struct {
+	tc   *Any[int, int]
+	done bool
+}
+
+func (e *testWritingEncoder) Encode(r Record[int, int]) error {
+	if !e.done {
+		e.tc.Set(-1, -1, DefaultExpiration)
+		e.done = true
+	}
+	return nil
+}
+
+type testSliceDecoder struct {
+	recs []Record[int, int]
+}
+
+func (d *testSliceDecoder) Decode() (Record[int, int], error) {
+	if len(d.recs) == 0 {
+		return Record[int, int]{}, io.EOF
+	}
+	r := d.recs[0]
+	d.recs = d.recs[1:]
+	return r, nil
+}
+
+func TestDecodeChunks(t *testing.T) {
+	dec := &testSliceDecoder{}
+	for i := 0; i < 2*persistChunk+1; i++ {
+		dec.recs = append(dec.recs, Record[int, int]{Key: i, Value: i})
+	}
+	var sizes []int
+	next := 0
+	n, err := decodeChunks[int, int](dec, func(recs []Record[int, int]) {
+		sizes = append(sizes, len(recs))
+		for _, r := range recs {
+			if r.Key != next {
+				t.Fatal("record out of order:", r.Key, next)
+			}
+			next++
+		}
+	})
+	if err != nil || n != 2*persistChunk+1 {
+		t.Fatal(n, err)
+	}
+	if len(sizes) != 3 || sizes[0] != persistChunk || sizes[1] != persistChunk || sizes[2] != 1 {
+		t.Error("unexpected chunks:", sizes)
+	}
+}
+
+func TestLoadStreams(t *testing.T) {
+	dec := &testSliceDecoder{}
+	for i := 0; i < 2*persistChunk+1; i++ {
+		dec.recs = append(dec.recs, Record[int, int]{Key: i, Value: i})
+	}
+	tc := New[int, int](DefaultExpiration, 0)
+	ch := tc.Subscribe(nil, 3*persistChunk, OverflowDrop)
+	n, records, err := tc.load(dec, MergeKeepExisting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2*persistChunk+1 || records != n || tc.ItemCount() != n {
+		t.Error("unexpected counts:", n, records, tc.ItemCount())
+	}
+	if len(ch) != n {
+		t.Error("unexpected number of events:", len(ch))
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 65507
+
+// maxReadBackoff limits the pause after consecutive read errors
+const maxReadBackoff = time.Second
+
+// Multicast sends messages to a UDP multicast group and receives the
+// messages sent to it, including its own. Every message is a single
+// datagram: messages may be lost or reordered, and messages larger than
+// 65507 bytes, in practice those larger than the MTU, can't be sent.
+type Multicast struct {
+	subscribers
+	in  *net.UDPConn
+	out *net.UDPConn
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// ListenMulticast joins the multicast group address, e.g. "239.0.0.1:7946",
+// on the network interface ifi, or on the default interface if ifi is nil.
+func ListenMulticast(address string, ifi *net.Interface) (*Multicast, error) {
+	group, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	in, err := net.ListenMulticastUDP("udp", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	in.SetReadBuffer(1 << 20)
+	out, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	m := &Multicast{in: in, out: out, done: make(chan struct{})}
+	m.wg.Add(1)
+	go m.receive()
+	return m, nil
+}
+
+// Publish sends msg to the group.
+func (m *Multicast) Publish(msg []byte) error {
+	if len(msg) > maxDatagram {
+		return fmt.Errorf("pubsub: message of %d bytes too large for a datagram", len(msg))
+	}
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	_, err := m.out.Write(msg)
+	return err
+}
+
+// receive delivers the datagrams read until Close. Read errors are retried
+// after a pause that doubles with every consecutive error.
+func (m *Multicast) receive() {
+	defer m.wg.Done()
+	buf := make([]byte, maxDatagram)
+	var backoff time.Duration
+	for {
+		n, _, err := m.in.ReadFromUDP(buf)
+		if err != nil {
+			backoff = min(max(2*backoff, time.Millisecond), maxReadBackoff)
+			select {
+			case <-m.done:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		m.deliver(buf[:n])
+	}
+}
+
+// Close leaves the group.
+func (m *Multicast) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	m.mu.Unlock()
+	err := m.in.Close()
+	m.out.Close()
+	m.wg.Wait()
+	return err
+}
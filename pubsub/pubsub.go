@@ -0,0 +1,64 @@
+// Package pubsub provides transports for the invalidation messages of
+// cache.WithInvalidation: an in-memory bus for replicas in one process, UDP
+// multicast for replicas on one network, and TCP for replicas that know each
+// other's addresses.
+package pubsub
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by the transports after Close.
+var ErrClosed = errors.New("pubsub: transport closed")
+
+// subscribers holds the handlers of a transport
+type subscribers struct {
+	mu   sync.RWMutex
+	next int
+	fs   map[int]func(msg []byte)
+}
+
+// Subscribe calls f with every message received until cancel is called.
+func (s *subscribers) Subscribe(f func(msg []byte)) (cancel func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fs == nil {
+		s.fs = map[int]func(msg []byte){}
+	}
+	id := s.next
+	s.next++
+	s.fs[id] = f
+	return func() {
+		s.mu.Lock()
+		delete(s.fs, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+// deliver passes msg to every handler
+func (s *subscribers) deliver(msg []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.fs {
+		f(msg)
+	}
+}
+
+// MemoryBus delivers every message to all of its subscribers, including the
+// publisher, on the goroutine of Publish. Caches sharing a bus behave like
+// replicas in separate processes.
+type MemoryBus struct {
+	subscribers
+}
+
+// NewMemoryBus returns a bus without subscribers.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish passes msg to the subscribers.
+func (b *MemoryBus) Publish(msg []byte) error {
+	b.deliver(msg)
+	return nil
+}
@@ -0,0 +1,169 @@
+package pubsub
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// recorder collects the messages it receives
+type recorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recorder) receive(msg []byte) {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, string(msg))
+	r.mu.Unlock()
+}
+
+// wait waits until n messages were received and returns them
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		msgs := append([]string(nil), r.msgs...)
+		r.mu.Unlock()
+		if len(msgs) >= n {
+			return msgs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d of %d messages", len(msgs), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	var r1, r2 recorder
+	bus.Subscribe(r1.receive)
+	cancel, _ := bus.Subscribe(r2.receive)
+	bus.Publish([]byte("a"))
+	cancel()
+	bus.Publish([]byte("b"))
+	if msgs := r1.wait(t, 2); msgs[0] != "a" || msgs[1] != "b" {
+		t.Error("unexpected messages:", msgs)
+	}
+	if msgs := r2.wait(t, 1); len(msgs) != 1 {
+		t.Error("cancelled subscriber received:", msgs)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	a, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := ListenTCP("127.0.0.1:0", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a.SetPeers(b.Addr().String())
+	var ra, rb recorder
+	a.Subscribe(ra.receive)
+	b.Subscribe(rb.receive)
+	if err = a.Publish([]byte("from a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Publish([]byte("from b")); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Publish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := rb.wait(t, 1); msgs[0] != "from a" {
+		t.Error("b received:", msgs)
+	}
+	if msgs := ra.wait(t, 2); msgs[0] != "from b" || msgs[1] != "" {
+		t.Error("a received:", msgs)
+	}
+
+	// An unreachable peer is reported and retried with the next message
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	a.SetPeers(b.Addr().String(), addr)
+	if err = a.Publish([]byte("x")); err == nil {
+		t.Error("unreachable peer not reported")
+	}
+	rb.wait(t, 2)
+	a.Close()
+	if err = a.Publish([]byte("y")); err != ErrClosed {
+		t.Error("publish after Close:", err)
+	}
+}
+
+func TestMulticast(t *testing.T) {
+	a, err := ListenMulticast("239.255.77.77:17946", nil)
+	if err != nil {
+		t.Skip("multicast not available:", err)
+	}
+	defer a.Close()
+	b, err := ListenMulticast("239.255.77.77:17946", nil)
+	if err != nil {
+		t.Skip("multicast not available:", err)
+	}
+	defer b.Close()
+	var r recorder
+	b.Subscribe(r.receive)
+	if err = a.Publish([]byte("hello")); err != nil {
+		t.Skip("multicast not available:", err)
+	}
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		n := len(r.msgs)
+		r.mu.Unlock()
+		if n > 0 {
+			if msgs := r.wait(t, 1); msgs[0] != "hello" {
+				t.Error("received:", msgs)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Skip("multicast datagrams are not looped back on this host")
+}
+
+// TestCacheOverTCP keeps two caches in sync over TCP
+func TestCacheOverTCP(t *testing.T) {
+	ta, err := ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ta.Close()
+	tb, err := ListenTCP("127.0.0.1:0", ta.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Close()
+	ta.SetPeers(tb.Addr().String())
+
+	a := cache.New[string, string](cache.NoExpiration, 0, cache.WithInvalidation(ta))
+	defer a.Close()
+	items := map[string]cache.Item[string]{"user:1": {Value: "old"}}
+	b := cache.NewFrom[string, string](cache.NoExpiration, 0, items, cache.WithInvalidation(tb))
+	defer b.Close()
+	a.Set("user:1", "new", cache.NoExpiration)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, found := b.Get("user:1"); !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("user:1 not invalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, _ := a.Get("user:1"); v != "new" {
+		t.Error("a holds", v)
+	}
+}
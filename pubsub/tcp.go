@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxFrame is the largest message accepted over TCP
+const maxFrame = 64 << 20
+
+// dialTimeout limits connecting to a peer
+const dialTimeout = 5 * time.Second
+
+// TCP sends every message to a list of peers over TCP and receives the
+// messages the peers send to its listener. Every message is a frame of its
+// length as a big endian uint32 followed by its bytes. Connections to peers
+// are opened on the first Publish and reopened after an error; a message
+// published while a peer can't be reached is lost for that peer.
+type TCP struct {
+	subscribers
+	l net.Listener
+
+	mu     sync.Mutex
+	peers  map[string]net.Conn // connections to the peers, nil until dialed
+	in     map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// ListenTCP listens for peers on the TCP address addr and publishes to
+// peers, given as host:port.
+func ListenTCP(addr string, peers ...string) (*TCP, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCP{l: l, in: map[net.Conn]struct{}{}}
+	t.SetPeers(peers...)
+	t.wg.Add(1)
+	go t.accept()
+	return t, nil
+}
+
+// Addr returns the address of the listener.
+func (t *TCP) Addr() net.Addr {
+	return t.l.Addr()
+}
+
+// SetPeers replaces the peers messages are published to.
+func (t *TCP) SetPeers(peers ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old := t.peers
+	t.peers = make(map[string]net.Conn, len(peers))
+	for _, p := range peers {
+		t.peers[p] = old[p]
+		delete(old, p)
+	}
+	for _, conn := range old {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// Publish sends msg to every peer. It returns the errors of the peers that
+// could not be reached.
+func (t *TCP) Publish(msg []byte) error {
+	if len(msg) > maxFrame {
+		return fmt.Errorf("pubsub: message of %d bytes too large", len(msg))
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg)))
+	frame = append(frame, msg...)
+	errs := t.dial()
+	// Writes to all peers are serialized so frames never interleave
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	for p, conn := range t.peers {
+		if conn == nil {
+			// Not reachable: reported by dial
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			// Dialed again with the next message
+			conn.Close()
+			t.peers[p] = nil
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dial connects to the peers without a connection, in parallel and without
+// holding t.mu, so an unreachable peer doesn't block the other publishers.
+// It returns the errors of the peers that could not be reached.
+func (t *TCP) dial() []error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	var addrs []string
+	for p, conn := range t.peers {
+		if conn == nil {
+			addrs = append(addrs, p)
+		}
+	}
+	t.mu.Unlock()
+	if len(addrs) == 0 {
+		return nil
+	}
+	conns := make([]net.Conn, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, p := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conns[i], errs[i] = net.DialTimeout("tcp", p, dialTimeout)
+		}()
+	}
+	wg.Wait()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, p := range addrs {
+		conn := conns[i]
+		if conn == nil {
+			continue
+		}
+		// Keep the connection unless the peer was removed, the transport
+		// closed or another Publish connected first
+		if old, ok := t.peers[p]; t.closed || !ok || old != nil {
+			conn.Close()
+			continue
+		}
+		t.peers[p] = conn
+	}
+	return slices.DeleteFunc(errs, func(err error) bool { return err == nil })
+}
+
+func (t *TCP) accept() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.l.Accept()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.in[conn] = struct{}{}
+		t.wg.Add(1)
+		t.mu.Unlock()
+		go t.receive(conn)
+	}
+}
+
+// receive delivers the frames read from conn until it fails
+func (t *TCP) receive(conn net.Conn) {
+	defer t.wg.Done()
+	defer func() {
+		conn.Close()
+		t.mu.Lock()
+		delete(t.in, conn)
+		t.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	var buf []byte
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > maxFrame {
+			return
+		}
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		t.deliver(buf)
+	}
+}
+
+// Close stops listening and closes all connections.
+func (t *TCP) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	err := t.l.Close()
+	for _, conn := range t.peers {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	for conn := range t.in {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	return err
+}
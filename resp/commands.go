@@ -0,0 +1,364 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+var (
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errOverflow   = errors.New("ERR increment or decrement would overflow")
+	errInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+	errSyntax     = errors.New("ERR syntax error")
+	// Redis has no integer-only store; WRONGTYPE is its closest error
+	errIntegerStore = errors.New("WRONGTYPE this server holds integers only")
+)
+
+// arity is the minimum number of arguments of each command, including its
+// name
+var arity = map[string]int{
+	"get": 2, "set": 3, "del": 2, "exists": 2,
+	"expire": 3, "pexpire": 3, "ttl": 2, "pttl": 2, "persist": 2,
+	"incr": 2, "decr": 2, "incrby": 3, "decrby": 3, "incrbyfloat": 3,
+	"keys": 2, "scan": 2, "flushdb": 1, "flushall": 1, "info": 1,
+	"ping": 1, "echo": 2, "select": 2, "dbsize": 1, "client": 1, "quit": 1,
+}
+
+// dispatch runs the command and writes the reply. It reports whether the
+// connection should be closed.
+func (s *Server) dispatch(args [][]byte, w *writer) (quit bool) {
+	name := strings.ToLower(string(args[0]))
+	n, known := arity[name]
+	if !known {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if len(args) < n {
+		w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	switch name {
+	case "get":
+		if v, found := s.c.get(string(args[1])); found {
+			w.bulk(v)
+		} else {
+			w.null()
+		}
+	case "set":
+		s.set(args[1:], w)
+	case "del":
+		var n int64
+		for _, k := range args[1:] {
+			if s.c.remove(string(k)) {
+				n++
+			}
+		}
+		w.integer(n)
+	case "exists":
+		var n int64
+		for _, k := range args[1:] {
+			if _, found := s.c.expiration(string(k)); found {
+				n++
+			}
+		}
+		w.integer(n)
+	case "expire", "pexpire":
+		s.expire(args[1:], name == "pexpire", w)
+	case "ttl", "pttl":
+		s.ttl(string(args[1]), name == "pttl", w)
+	case "persist":
+		e, found := s.c.expiration(string(args[1]))
+		if !found || e == 0 || s.c.UpdateExpiration(string(args[1]), cache.NoExpiration) != nil {
+			w.integer(0)
+			return false
+		}
+		w.integer(1)
+	case "incr", "decr", "incrby", "decrby":
+		s.incr(name, args[1:], w)
+	case "incrbyfloat":
+		s.incrFloat(args[1:], w)
+	case "keys":
+		keys, _ := s.c.keys()
+		matched := keys[:0]
+		for _, k := range keys {
+			if match(string(args[1]), k) {
+				matched = append(matched, k)
+			}
+		}
+		writeKeys(w, matched)
+	case "scan":
+		s.scan(args[1:], w)
+	case "flushdb", "flushall":
+		s.c.Flush()
+		w.status("OK")
+	case "dbsize":
+		w.integer(int64(s.c.ItemCount()))
+	case "info":
+		s.info(args[1:], w)
+	case "ping":
+		if len(args) > 1 {
+			w.bulk(args[1])
+		} else {
+			w.status("PONG")
+		}
+	case "echo":
+		w.bulk(args[1])
+	case "select":
+		// There is a single database
+		if string(args[1]) != "0" {
+			w.error("ERR DB index is out of range")
+			return false
+		}
+		w.status("OK")
+	case "client":
+		// Accepted for clients that set their name when connecting
+		w.status("OK")
+	case "quit":
+		w.status("OK")
+		return true
+	}
+	return false
+}
+
+// set handles SET key value [EX seconds|PX milliseconds] [NX|XX]
+func (s *Server) set(args [][]byte, w *writer) {
+	k, v := string(args[0]), args[1]
+	d := cache.NoExpiration
+	var nx, xx, expire bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if expire || i+1 == len(args) {
+				w.error(errSyntax.Error())
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				w.error(errNotInteger.Error())
+				return
+			}
+			if n <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			d = time.Duration(n) * time.Millisecond
+			if opt == "EX" {
+				d *= 1000
+			}
+			expire = true
+		default:
+			w.error(errSyntax.Error())
+			return
+		}
+	}
+	if nx && xx {
+		w.error(errSyntax.Error())
+		return
+	}
+	mode := setAlways
+	if nx {
+		mode = setNX
+	} else if xx {
+		mode = setXX
+	}
+	ok, err := s.c.set(k, v, d, mode)
+	switch {
+	case err != nil:
+		w.error(err.Error())
+	case !ok:
+		w.null()
+	default:
+		w.status("OK")
+	}
+}
+
+// expire handles EXPIRE and PEXPIRE. A non-positive timeout deletes the key.
+func (s *Server) expire(args [][]byte, millis bool, w *writer) {
+	k := string(args[0])
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		w.error(errNotInteger.Error())
+		return
+	}
+	if n <= 0 {
+		if s.c.remove(k) {
+			w.integer(1)
+		} else {
+			w.integer(0)
+		}
+		return
+	}
+	d := time.Duration(n) * time.Millisecond
+	if !millis {
+		d *= 1000
+	}
+	if s.c.UpdateExpiration(k, d) != nil {
+		w.integer(0)
+		return
+	}
+	w.integer(1)
+}
+
+// ttl replies -2 if the key doesn't exist and -1 if it doesn't expire
+func (s *Server) ttl(k string, millis bool, w *writer) {
+	exp, found := s.c.expiration(k)
+	e := time.Unix(0, exp)
+	switch {
+	case !found:
+		w.integer(-2)
+	case exp == 0:
+		w.integer(-1)
+	case millis:
+		w.integer(max(time.Until(e).Milliseconds(), 0))
+	default:
+		// Rounded like Redis
+		w.integer(max((time.Until(e).Milliseconds()+500)/1000, 0))
+	}
+}
+
+// incr handles INCR, DECR, INCRBY and DECRBY. A missing key is created
+// without expiration, starting from 0.
+func (s *Server) incr(name string, args [][]byte, w *writer) {
+	delta := int64(1)
+	if name == "incrby" || name == "decrby" {
+		var err error
+		if delta, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			w.error(errNotInteger.Error())
+			return
+		}
+	}
+	if name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			w.error("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+	n, err := s.c.incr(string(args[0]), delta)
+	if err != nil {
+		w.error(err.Error())
+		return
+	}
+	w.integer(n)
+}
+
+// incrFloat handles INCRBYFLOAT
+func (s *Server) incrFloat(args [][]byte, w *writer) {
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		w.error(errNotFloat.Error())
+		return
+	}
+	v, err := s.c.incrFloat(string(args[0]), delta)
+	if err != nil {
+		w.error(err.Error())
+		return
+	}
+	w.bulk(v)
+}
+
+func writeKeys(w *writer, keys []string) {
+	w.array(len(keys))
+	for _, k := range keys {
+		w.bulk([]byte(k))
+	}
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]. The cursor is the
+// position in the sorted keys, so keys added or removed between calls may be
+// missed or returned twice, as Redis allows.
+func (s *Server) scan(args [][]byte, w *writer) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 63)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i++ {
+		if i+1 == len(args) {
+			w.error(errSyntax.Error())
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				w.error(errNotInteger.Error())
+				return
+			}
+			if count < 1 {
+				w.error(errSyntax.Error())
+				return
+			}
+		default:
+			w.error(errSyntax.Error())
+			return
+		}
+		i++
+	}
+	keys, _ := s.c.keys()
+	start := int(min(cursor, uint64(len(keys))))
+	end := min(start+count, len(keys))
+	var matched []string
+	for _, k := range keys[start:end] {
+		if match(pattern, k) {
+			matched = append(matched, k)
+		}
+	}
+	next := uint64(end)
+	if end == len(keys) {
+		next = 0
+	}
+	w.array(2)
+	w.bulk(strconv.AppendUint(nil, next, 10))
+	writeKeys(w, matched)
+}
+
+// info handles INFO [section], supporting the server, stats and keyspace
+// sections
+func (s *Server) info(args [][]byte, w *writer) {
+	section := "all"
+	if len(args) > 0 {
+		section = strings.ToLower(string(args[0]))
+	}
+	all := section == "all" || section == "default" || section == "everything"
+	var b strings.Builder
+	if all || section == "server" {
+		b.WriteString("# Server\r\n")
+		b.WriteString("redis_version:7.0.0\r\n")
+		b.WriteString("redis_mode:standalone\r\n")
+		fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(s.started).Seconds()))
+		b.WriteString("\r\n")
+	}
+	if all || section == "stats" {
+		st := s.c.Stats()
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "keyspace_hits:%d\r\n", st.Hits)
+		fmt.Fprintf(&b, "keyspace_misses:%d\r\n", st.Misses)
+		fmt.Fprintf(&b, "expired_keys:%d\r\n", st.Expirations)
+		fmt.Fprintf(&b, "evicted_keys:%d\r\n", st.Evictions)
+		b.WriteString("\r\n")
+	}
+	if all || section == "keyspace" {
+		b.WriteString("# Keyspace\r\n")
+		keys, expires := s.c.keys()
+		if len(keys) > 0 {
+			fmt.Fprintf(&b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", len(keys), expires)
+		}
+	}
+	w.bulk([]byte(b.String()))
+}
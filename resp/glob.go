@@ -0,0 +1,84 @@
+package resp
+
+// match reports whether s matches the Redis glob pattern: * matches any
+// sequence, ? any single byte, [abc], [^abc] and [a-z] a set of bytes, and a
+// backslash escapes the next byte. Patterns come from clients, so instead of
+// recursing on every * it only backtracks to the last one, which bounds the
+// work by len(pattern)*len(s).
+func match(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0 // pattern after the last *, and where s resumes if it fails
+	for i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				p++
+				star, next = p, i
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern[p+1:], s[i]); ok {
+					p += 1 + end
+					i++
+					continue
+				}
+			default:
+				n := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, n = pattern[p+1], 2
+				}
+				if c == s[i] {
+					p += n
+					i++
+					continue
+				}
+			}
+		}
+		// Let the last * swallow one more byte
+		if star < 0 {
+			return false
+		}
+		next++
+		p, i = star, next
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class at the start of p, which follows
+// the '['. It returns the length of the class including the closing ']'.
+func matchClass(p string, c byte) (int, bool) {
+	i := 0
+	negate := false
+	if i < len(p) && p[i] == '^' {
+		negate = true
+		i++
+	}
+	matched := false
+	for i < len(p) && p[i] != ']' {
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = p[i]
+		}
+		hi := lo
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			hi = p[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	// An unterminated class extends to the end of the pattern, like Redis
+	return min(i+1, len(p)), matched != negate
+}
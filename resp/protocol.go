@@ -0,0 +1,142 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// protocolError is a malformed request. The connection is closed after
+// reporting it, like Redis does.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "resp: protocol error: " + string(e)
+}
+
+const (
+	maxArgs    = 1 << 20
+	maxBulkLen = 512 << 20
+	maxInline  = 64 << 10
+	// Lengths sent by the client are only trusted up to bulkChunk: larger
+	// bulk strings and argument lists grow as their data arrives, so
+	// announcing a length doesn't reserve memory
+	bulkChunk = 64 << 10
+)
+
+// readLine reads a line without its "\r\n"
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		b, more, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b...)
+		if len(line) > maxInline {
+			return nil, protocolError("too big inline request")
+		}
+		if !more {
+			return line, nil
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an
+// inline command separated by spaces.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([][]byte, 0, min(max(n, 0), bulkChunk/8))
+	for i := 0; i < n; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError("expected '$', got '" + string(line[:min(len(line), 1)]) + "'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		b, err := readBulk(r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(b, []byte("\r\n")) {
+			return nil, protocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, b[:size])
+	}
+	return args, nil
+}
+
+// readBulk reads the n bytes of a bulk string and its "\r\n"
+func readBulk(r *bufio.Reader, n int) ([]byte, error) {
+	if n <= bulkChunk {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(bulkChunk)
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writer writes RESP2 replies
+type writer struct {
+	*bufio.Writer
+}
+
+func (w *writer) status(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) error(s string) {
+	w.WriteByte('-')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func (w *writer) bulk(b []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *writer) array(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
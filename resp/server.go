@@ -0,0 +1,97 @@
+// Package resp serves a cache over the Redis RESP2 protocol, so that Redis
+// clients can talk to an in-process cache in local development and tests.
+//
+// The supported commands are GET, SET with EX, PX, NX and XX, DEL, EXISTS,
+// EXPIRE, PEXPIRE, TTL, PTTL, PERSIST, INCR, DECR, INCRBY, DECRBY,
+// INCRBYFLOAT, KEYS, SCAN, FLUSHDB, FLUSHALL, INFO, PING, ECHO, SELECT 0 and
+// QUIT.
+//
+// NewServer serves an Any[string, []byte], holding strings; counters are kept
+// as decimal strings like Redis does, and integer arithmetic is checked for
+// overflow with cache.AddChecked. NewNumberServer serves a
+// Number[string, int64], holding only integers: SET refuses other values,
+// INCRBY and DECRBY use its checked arithmetic, and INCRBYFLOAT is refused.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+	"github.com/Akvicor/go-cache/internal/netserver"
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server serves a cache over RESP2.
+type Server struct {
+	c       store
+	started time.Time
+	srv     *netserver.Server
+}
+
+// NewServer returns a server for the strings of c.
+func NewServer(c *cache.Any[string, []byte]) *Server {
+	return newServer(anyStore{c})
+}
+
+// NewNumberServer returns a server for the integer counters of c.
+func NewNumberServer(c *cache.Number[string, int64]) *Server {
+	return newServer(numberStore{c})
+}
+
+func newServer(c store) *Server {
+	s := &Server{c: c, started: time.Now()}
+	s.srv = netserver.New(s.serveConn, ErrServerClosed)
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	return s.srv.ListenAndServe(addr)
+}
+
+// Serve accepts connections on l until Close is called, serving each on its
+// own goroutine. It always returns a non-nil error; ErrServerClosed after
+// Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.srv.Serve(l)
+}
+
+// Close stops all listeners, closes all connections and waits for their
+// goroutines. The cache is left open.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := &writer{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				w.error("ERR Protocol error: " + string(perr))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if quit := s.dispatch(args, w); quit {
+			w.Flush()
+			return
+		}
+		// Flush only once the client has sent everything it pipelined
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
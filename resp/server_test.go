@@ -0,0 +1,291 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T) (*cache.Any[string, []byte], *testClient) {
+	t.Helper()
+	c := cache.New[string, []byte](cache.NoExpiration, 0)
+	return c, serve(t, NewServer(c))
+}
+
+// serve serves s on a local listener and returns a client connected to it
+func serve(t *testing.T, s *Server) *testClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Error("Serve returned", err)
+		}
+	})
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode returns the command as an array of bulk strings
+func encode(cmd string) string {
+	args := strings.Fields(cmd)
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	return b.String()
+}
+
+// raw sends req and checks that the reply is want
+func (c *testClient) raw(req, want string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	got := make([]byte, 0, len(want))
+	for len(got) < len(want) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatalf("%q: read %q, then %v", req, got, err)
+		}
+		got = append(got, b)
+	}
+	if string(got) != want {
+		c.t.Errorf("%q: got %q, want %q", req, got, want)
+	}
+}
+
+// do sends the space separated command and checks that the reply is want
+func (c *testClient) do(cmd, want string) {
+	c.t.Helper()
+	c.raw(encode(cmd), want)
+}
+
+func TestStrings(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("PING", "+PONG\r\n")
+	cl.do("SET foo bar", "+OK\r\n")
+	cl.do("GET foo", "$3\r\nbar\r\n")
+	cl.do("GET missing", "$-1\r\n")
+	cl.do("SET foo baz NX", "$-1\r\n")
+	cl.do("SET missing x XX", "$-1\r\n")
+	cl.do("set foo qux xx", "+OK\r\n")
+	cl.do("SET new v NX", "+OK\r\n")
+	if v, _ := c.Get("foo"); string(v) != "qux" {
+		t.Errorf("cache holds %q", v)
+	}
+	cl.do("EXISTS foo new missing foo", ":3\r\n")
+	cl.do("DEL foo missing", ":1\r\n")
+	cl.do("EXISTS foo", ":0\r\n")
+	cl.do("SET foo x NX XX", "-ERR syntax error\r\n")
+	cl.do("SET foo x EX", "-ERR syntax error\r\n")
+	cl.do("SET foo x EX 0", "-ERR invalid expire time in 'set' command\r\n")
+	cl.do("GET", "-ERR wrong number of arguments for 'get' command\r\n")
+	cl.do("BOGUS", "-ERR unknown command 'BOGUS'\r\n")
+	// Inline commands and pipelining
+	cl.raw("SET inline 1\r\nGET inline\r\n", "+OK\r\n$1\r\n1\r\n")
+	// Empty and binary values
+	cl.raw("*3\r\n$3\r\nSET\r\n$5\r\nempty\r\n$0\r\n\r\n", "+OK\r\n")
+	cl.do("GET empty", "$0\r\n\r\n")
+	cl.raw("*3\r\n$3\r\nSET\r\n$3\r\nbin\r\n$4\r\na\r\nb\r\n", "+OK\r\n")
+	cl.do("GET bin", "$4\r\na\r\nb\r\n")
+	cl.do("QUIT", "+OK\r\n")
+}
+
+func TestExpire(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("SET foo bar", "+OK\r\n")
+	cl.do("TTL foo", ":-1\r\n")
+	cl.do("TTL missing", ":-2\r\n")
+	cl.do("EXPIRE foo 100", ":1\r\n")
+	cl.do("TTL foo", ":100\r\n")
+	cl.do("EXPIRE missing 100", ":0\r\n")
+	cl.do("PERSIST foo", ":1\r\n")
+	cl.do("PERSIST foo", ":0\r\n")
+	cl.do("TTL foo", ":-1\r\n")
+	cl.do("SET foo bar EX 50", "+OK\r\n")
+	cl.do("TTL foo", ":50\r\n")
+	// SET without EX clears the expiration
+	cl.do("SET foo bar XX", "+OK\r\n")
+	cl.do("TTL foo", ":-1\r\n")
+	cl.do("SET short x PX 20", "+OK\r\n")
+	time.Sleep(40 * time.Millisecond)
+	cl.do("GET short", "$-1\r\n")
+	cl.do("EXPIRE foo 0", ":1\r\n")
+	cl.do("EXISTS foo", ":0\r\n")
+	if len(c.Items()) != 0 {
+		t.Error("items left:", c.Items())
+	}
+}
+
+func TestIncr(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("INCR n", ":1\r\n")
+	cl.do("INCRBY n 41", ":42\r\n")
+	cl.do("DECRBY n 50", ":-8\r\n")
+	cl.do("DECR n", ":-9\r\n")
+	cl.do("GET n", "$2\r\n-9\r\n")
+	cl.do("INCRBY n x", "-ERR value is not an integer or out of range\r\n")
+	cl.do("SET max 9223372036854775807", "+OK\r\n")
+	cl.do("INCR max", "-ERR increment or decrement would overflow\r\n")
+	cl.do("GET max", "$19\r\n9223372036854775807\r\n")
+	cl.do("SET s abc", "+OK\r\n")
+	cl.do("INCR s", "-ERR value is not an integer or out of range\r\n")
+	cl.do("INCRBYFLOAT f 1.5", "$3\r\n1.5\r\n")
+	cl.do("INCRBYFLOAT f 2", "$3\r\n3.5\r\n")
+	cl.do("INCRBYFLOAT n 0.5", "$4\r\n-8.5\r\n")
+	cl.do("INCRBYFLOAT s 1", "-ERR value is not a valid float\r\n")
+	cl.do("SET big 1e308", "+OK\r\n")
+	cl.do("INCRBYFLOAT big 1e308", "-ERR increment would produce NaN or Infinity\r\n")
+	// The expiration is kept
+	cl.do("SET e 1 EX 100", "+OK\r\n")
+	cl.do("INCR e", ":2\r\n")
+	cl.do("TTL e", ":100\r\n")
+	if v, _ := c.Get("f"); string(v) != "3.5" {
+		t.Errorf("cache holds %q", v)
+	}
+}
+
+func TestKeysAndScan(t *testing.T) {
+	_, cl := startServer(t)
+	for _, k := range []string{"user:1", "user:2", "user:10", "order:1", "a/b"} {
+		cl.do("SET "+k+" x", "+OK\r\n")
+	}
+	cl.do("KEYS user:?", "*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n")
+	cl.do("KEYS *", "*5\r\n$3\r\na/b\r\n$7\r\norder:1\r\n$6\r\nuser:1\r\n$7\r\nuser:10\r\n$6\r\nuser:2\r\n")
+	cl.do("KEYS a*", "*1\r\n$3\r\na/b\r\n")
+	cl.do("SCAN 0 COUNT 2", "*2\r\n$1\r\n2\r\n*2\r\n$3\r\na/b\r\n$7\r\norder:1\r\n")
+	cl.do("SCAN 2 COUNT 2 MATCH *0", "*2\r\n$1\r\n4\r\n*1\r\n$7\r\nuser:10\r\n")
+	cl.do("SCAN 4 COUNT 2", "*2\r\n$1\r\n0\r\n*1\r\n$6\r\nuser:2\r\n")
+	cl.do("SCAN 0 COUNT", "-ERR syntax error\r\n")
+	cl.do("DBSIZE", ":5\r\n")
+	cl.do("FLUSHDB", "+OK\r\n")
+	cl.do("KEYS *", "*0\r\n")
+}
+
+func TestInfo(t *testing.T) {
+	_, cl := startServer(t)
+	cl.do("SET a 1 EX 100", "+OK\r\n")
+	cl.do("SET b 2", "+OK\r\n")
+	cl.do("GET a", "$1\r\n1\r\n")
+	cl.do("GET c", "$-1\r\n")
+	want := "# Stats\r\nkeyspace_hits:1\r\nkeyspace_misses:1\r\nexpired_keys:0\r\nevicted_keys:0\r\n\r\n"
+	cl.do("INFO stats", "$"+strconv.Itoa(len(want))+"\r\n"+want+"\r\n")
+	want = "# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n"
+	cl.do("INFO keyspace", "$"+strconv.Itoa(len(want))+"\r\n"+want+"\r\n")
+}
+
+func TestProtocolError(t *testing.T) {
+	_, cl := startServer(t)
+	cl.raw("*1\r\n+PING\r\n", "-ERR Protocol error: expected '$', got '+'\r\n")
+	if _, err := cl.r.ReadByte(); err == nil {
+		t.Error("connection not closed")
+	}
+}
+
+func TestReadCommandLargeBulk(t *testing.T) {
+	// An announced length alone doesn't allocate it
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readCommand(bufio.NewReader(strings.NewReader("*2\r\n$536870912\r\nabc")))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Error("short bulk string:", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+		t.Error("allocated", n, "bytes for a 3 byte bulk string")
+	}
+
+	big := strings.Repeat("x", 3*bulkChunk+1)
+	args, err := readCommand(bufio.NewReader(strings.NewReader("*2\r\n$3\r\nSET\r\n$" + strconv.Itoa(len(big)) + "\r\n" + big + "\r\n")))
+	if err != nil || len(args) != 2 || string(args[1]) != big {
+		t.Error("large bulk string not read:", len(args), err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"**a", "bba", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*b", "abab", true},
+		{"[", "", false},
+		{`a\`, `a\`, true},
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false},
+	} {
+		if got := match(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("match(%q, %q) = %v", tc.pattern, tc.s, got)
+		}
+	}
+}
+
+func TestDelExistsDontCount(t *testing.T) {
+	c, cl := startServer(t)
+	cl.do("SET a 1", "+OK\r\n")
+	cl.do("EXISTS a b", ":1\r\n")
+	cl.do("DEL a b", ":1\r\n")
+	cl.do("DEL a", ":0\r\n")
+	if st := c.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Errorf("EXISTS and DEL counted %d hits and %d misses", st.Hits, st.Misses)
+	}
+}
+
+func TestNumberServer(t *testing.T) {
+	c := cache.NewNumber[string, int64](cache.NoExpiration, 0)
+	cl := serve(t, NewNumberServer(c))
+	cl.do("SET n 5", "+OK\r\n")
+	cl.do("GET n", "$1\r\n5\r\n")
+	cl.do("SET s abc", "-ERR value is not an integer or out of range\r\n")
+	cl.do("SET n 6 NX", "$-1\r\n")
+	cl.do("INCRBY n 10", ":15\r\n")
+	cl.do("DECR m", ":-1\r\n")
+	cl.do("INCRBY n 9223372036854775807", "-ERR increment or decrement would overflow\r\n")
+	cl.do("DECRBY n -9223372036854775808", "-ERR decrement would overflow\r\n")
+	cl.do("INCRBYFLOAT n 1.5", "-WRONGTYPE this server holds integers only\r\n")
+	cl.do("EXPIRE n 100", ":1\r\n")
+	cl.do("TTL n", ":100\r\n")
+	cl.do("KEYS *", "*2\r\n$1\r\nm\r\n$1\r\nn\r\n")
+	cl.do("DEL n", ":1\r\n")
+	if v, found := c.Get("m"); !found || v != -1 {
+		t.Error("m is", v, found)
+	}
+}
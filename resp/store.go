@@ -0,0 +1,220 @@
+package resp
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+)
+
+// setMode is the condition of SET
+type setMode int
+
+const (
+	setAlways setMode = iota
+	setNX             // only if the key doesn't exist
+	setXX             // only if the key exists
+)
+
+// store is the cache behind a server, holding either byte strings or
+// integers
+type store interface {
+	// get returns the value of k as a string, counting a hit or a miss
+	get(k string) ([]byte, bool)
+	// set stores v under k, reporting false if mode was not met
+	set(k string, v []byte, d time.Duration, mode setMode) (bool, error)
+	// incr adds delta to the integer stored under k, creating it from 0
+	incr(k string, delta int64) (int64, error)
+	// incrFloat adds delta to the number stored under k, creating it from 0
+	incrFloat(k string, delta float64) ([]byte, error)
+	// remove deletes k and reports whether it existed
+	remove(k string) bool
+	// expiration returns the expiration of k without counting a hit
+	expiration(k string) (e int64, found bool)
+	// keys returns the sorted keys of the unexpired items and how many of
+	// them expire
+	keys() (keys []string, expires int)
+
+	UpdateExpiration(k string, d time.Duration) error
+	Flush()
+	ItemCount() int
+	Stats() cache.Stats
+}
+
+// sortedKeys returns the sorted keys of items and how many of them expire
+func sortedKeys[V any](items map[string]cache.Item[V]) ([]string, int) {
+	keys := make([]string, 0, len(items))
+	expires := 0
+	for k, item := range items {
+		keys = append(keys, k)
+		if item.Expiration > 0 {
+			expires++
+		}
+	}
+	sort.Strings(keys)
+	return keys, expires
+}
+
+// anyStore keeps values as byte strings; counters are decimal strings like
+// in Redis
+type anyStore struct {
+	*cache.Any[string, []byte]
+}
+
+func (s anyStore) get(k string) ([]byte, bool) {
+	return s.Get(k)
+}
+
+func (s anyStore) set(k string, v []byte, d time.Duration, mode setMode) (bool, error) {
+	switch mode {
+	case setNX:
+		return s.Add(k, v, d) == nil, nil
+	case setXX:
+		return s.Replace(k, v, d) == nil, nil
+	}
+	s.Set(k, v, d)
+	return true, nil
+}
+
+func (s anyStore) incr(k string, delta int64) (int64, error) {
+	var n int64
+	_, err := s.update(k, func(old []byte) ([]byte, error) {
+		n = 0
+		if old != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+		}
+		var err error
+		if n, err = cache.AddChecked(n, delta); err != nil {
+			return nil, errOverflow
+		}
+		return strconv.AppendInt(nil, n, 10), nil
+	})
+	return n, err
+}
+
+func (s anyStore) incrFloat(k string, delta float64) ([]byte, error) {
+	return s.update(k, func(old []byte) ([]byte, error) {
+		f := 0.0
+		if old != nil {
+			var err error
+			if f, err = strconv.ParseFloat(string(old), 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, errNotFloat
+			}
+		}
+		f += delta
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errInfinity
+		}
+		return strconv.AppendFloat(nil, f, 'f', -1, 64), nil
+	})
+}
+
+// update atomically replaces the value of k with f(old), keeping its
+// expiration. If k doesn't exist, it is added with f(nil) and no expiration.
+// The errors of f are returned as they are.
+func (s anyStore) update(k string, f func(old []byte) ([]byte, error)) ([]byte, error) {
+	for {
+		var ferr error
+		v, err := s.Update(k, func(old []byte) ([]byte, error) {
+			v, err := f(old)
+			ferr = err
+			return v, err
+		})
+		if ferr != nil {
+			return nil, ferr
+		}
+		if err == nil {
+			return v, nil
+		}
+		// Not found: create it, unless another client just did
+		v, ferr = f(nil)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if s.Add(k, v, cache.NoExpiration) == nil {
+			return v, nil
+		}
+	}
+}
+
+func (s anyStore) remove(k string) bool {
+	_, found := s.Remove(k)
+	return found
+}
+
+func (s anyStore) expiration(k string) (int64, bool) {
+	item, found := s.Peek(k)
+	return item.Expiration, found
+}
+
+func (s anyStore) keys() ([]string, int) {
+	return sortedKeys(s.Items())
+}
+
+// numberStore keeps int64 counters, refusing values that are not integers
+type numberStore struct {
+	*cache.Number[string, int64]
+}
+
+func (s numberStore) get(k string) ([]byte, bool) {
+	n, found := s.Get(k)
+	if !found {
+		return nil, false
+	}
+	return strconv.AppendInt(nil, n, 10), true
+}
+
+func (s numberStore) set(k string, v []byte, d time.Duration, mode setMode) (bool, error) {
+	n, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return false, errNotInteger
+	}
+	switch mode {
+	case setNX:
+		return s.Add(k, n, d) == nil, nil
+	case setXX:
+		return s.Replace(k, n, d) == nil, nil
+	}
+	s.Set(k, n, d)
+	return true, nil
+}
+
+func (s numberStore) incr(k string, delta int64) (int64, error) {
+	for {
+		n, err := s.IncrementChecked(k, delta)
+		if errors.Is(err, cache.ErrOverflow) {
+			return 0, errOverflow
+		}
+		if err == nil {
+			return n, nil
+		}
+		// Not found: create it, unless another client just did
+		if s.Add(k, delta, cache.NoExpiration) == nil {
+			return delta, nil
+		}
+	}
+}
+
+func (s numberStore) incrFloat(k string, delta float64) ([]byte, error) {
+	return nil, errIntegerStore
+}
+
+func (s numberStore) remove(k string) bool {
+	_, found := s.Remove(k)
+	return found
+}
+
+func (s numberStore) expiration(k string) (int64, bool) {
+	item, found := s.Peek(k)
+	return item.Expiration, found
+}
+
+func (s numberStore) keys() ([]string, int) {
+	return sortedKeys(s.Items())
+}
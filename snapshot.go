@@ -0,0 +1,416 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Snapshot files written by SaveFile start with a header and end with a
+// trailer protecting the codec stream in between:
+//
+//	magic    [8]byte  "GOCACHE\x00"
+//	version  uint16
+//	codec    uint8 length + name
+//	count    uint64   number of records
+//	created  int64    Unix nanoseconds
+//	body     codec stream
+//	length   uint64   length of body
+//	crc      uint32   CRC-32 (IEEE) of body followed by the header
+//
+// All integers are big endian. The checksum of version 1 snapshots covers
+// only the body; they are still read.
+
+// SnapshotVersion is the snapshot format version written by SaveFile.
+const SnapshotVersion = 2
+
+var snapshotMagic = [8]byte{'G', 'O', 'C', 'A', 'C', 'H', 'E', 0}
+
+const (
+	snapshotTrailerLen = 8 + 4
+	snapshotCountPos   = 8 + 2 + 1 // offset of count, after the codec name
+)
+
+var (
+	// ErrSnapshotMagic The file is not a snapshot written by SaveFile
+	ErrSnapshotMagic = errors.New("not a cache snapshot")
+	// ErrSnapshotVersion The snapshot was written by an unsupported format version
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+	// ErrSnapshotCodec The snapshot was written with a different codec
+	ErrSnapshotCodec = errors.New("snapshot codec mismatch")
+	// ErrSnapshotCorrupt The snapshot is truncated or its checksum doesn't match
+	ErrSnapshotCorrupt = errors.New("snapshot corrupted")
+)
+
+// SnapshotError is returned when a snapshot file is refused. Err is one of
+// the ErrSnapshot* errors.
+type SnapshotError struct {
+	Path   string
+	Err    error
+	Detail string
+}
+
+func (e *SnapshotError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("cache: %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("cache: %s: %v: %s", e.Path, e.Err, e.Detail)
+}
+
+func (e *SnapshotError) Unwrap() error {
+	return e.Err
+}
+
+// SnapshotInfo is the header of a snapshot file.
+type SnapshotInfo struct {
+	Version int
+	Codec   string
+	Count   int
+	Created time.Time
+}
+
+// writeSnapshotHeader writes the header and returns its length
+func writeSnapshotHeader(w io.Writer, codec string, count int, created time.Time) (int, error) {
+	if len(codec) > 255 {
+		return 0, fmt.Errorf("cache: codec name %q too long", codec)
+	}
+	b := make([]byte, 0, 8+2+1+len(codec)+8+8)
+	b = append(b, snapshotMagic[:]...)
+	b = binary.BigEndian.AppendUint16(b, SnapshotVersion)
+	b = append(b, byte(len(codec)))
+	b = append(b, codec...)
+	b = binary.BigEndian.AppendUint64(b, uint64(count))
+	b = binary.BigEndian.AppendUint64(b, uint64(created.UnixNano()))
+	return w.Write(b)
+}
+
+// readSnapshotHeader reads the header and returns it with its length
+func readSnapshotHeader(r io.Reader, path string) (SnapshotInfo, int, error) {
+	var info SnapshotInfo
+	var fixed [8 + 2 + 1]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return info, 0, &SnapshotError{Path: path, Err: ErrSnapshotMagic, Detail: err.Error()}
+	}
+	if [8]byte(fixed[:8]) != snapshotMagic {
+		return info, 0, &SnapshotError{Path: path, Err: ErrSnapshotMagic}
+	}
+	info.Version = int(binary.BigEndian.Uint16(fixed[8:10]))
+	if info.Version < 1 || info.Version > SnapshotVersion {
+		return info, 0, &SnapshotError{Path: path, Err: ErrSnapshotVersion, Detail: fmt.Sprintf("version %d", info.Version)}
+	}
+	rest := make([]byte, int(fixed[10])+8+8)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return info, 0, &SnapshotError{Path: path, Err: ErrSnapshotCorrupt, Detail: "truncated header"}
+	}
+	n := int(fixed[10])
+	info.Codec = string(rest[:n])
+	info.Count = int(binary.BigEndian.Uint64(rest[n : n+8]))
+	info.Created = time.Unix(0, int64(binary.BigEndian.Uint64(rest[n+8:])))
+	return info, len(fixed) + len(rest), nil
+}
+
+// ReadSnapshotInfo returns the header of the snapshot file fname.
+func ReadSnapshotInfo(fname string) (SnapshotInfo, error) {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer fp.Close()
+	info, _, err := readSnapshotHeader(bufio.NewReader(fp), fname)
+	return info, err
+}
+
+// countingWriter counts and checksums the bytes written through it
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	crc uint32
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// writeSnapshot writes a complete snapshot to fp, with the records written
+// by encode
+func writeSnapshot[K comparable, V any](fp *os.File, codec Codec[K, V], encode func(Encoder[K, V]) (int, error)) error {
+	bw := bufio.NewWriter(fp)
+	created := time.Now()
+	if _, err := writeSnapshotHeader(bw, codec.Name(), 0, created); err != nil {
+		return err
+	}
+	body := &countingWriter{w: bw}
+	n, err := encode(codec.NewEncoder(body))
+	if err != nil {
+		return err
+	}
+	// The number of records is only known once they are written, so the
+	// header is checksummed after the body
+	var header bytes.Buffer
+	writeSnapshotHeader(&header, codec.Name(), n, created)
+	var trailer [snapshotTrailerLen]byte
+	binary.BigEndian.PutUint64(trailer[:8], uint64(body.n))
+	binary.BigEndian.PutUint32(trailer[8:], crc32.Update(body.crc, crc32.IEEETable, header.Bytes()))
+	if _, err = bw.Write(trailer[:]); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	pos := snapshotCountPos + len(codec.Name())
+	_, err = fp.WriteAt(header.Bytes()[pos:pos+8], int64(pos))
+	return err
+}
+
+// writeSnapshotFile atomically replaces fname with a snapshot of the records
+// written by encode
+func writeSnapshotFile[K comparable, V any](fname string, codec Codec[K, V], encode func(Encoder[K, V]) (int, error)) (err error) {
+	fp, err := createTemp(fname, 0o666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fp.Close()
+			os.Remove(fp.Name())
+		}
+	}()
+	if err = writeSnapshot(fp, codec, encode); err != nil {
+		return err
+	}
+	if err = fp.Sync(); err != nil {
+		return err
+	}
+	if err = fp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(fp.Name(), fname); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(fname))
+	return nil
+}
+
+// createTemp creates a new file next to fname, to be renamed over it. Unlike
+// os.CreateTemp, which always uses mode 0600, the file gets the mode of fname
+// if it exists, or perm less the umask like os.Create.
+func createTemp(fname string, perm os.FileMode) (*os.File, error) {
+	st, statErr := os.Stat(fname)
+	for {
+		fp, err := os.OpenFile(fname+".tmp-"+strconv.FormatUint(rand.Uint64(), 36), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if statErr == nil {
+			if err = fp.Chmod(st.Mode().Perm()); err != nil {
+				fp.Close()
+				os.Remove(fp.Name())
+				return nil, err
+			}
+		}
+		return fp, nil
+	}
+}
+
+// SaveFileWith Save the cache's items to the given filename as a snapshot
+// encoded with codec. The snapshot is written to a temporary file in the
+// same directory, synced and then renamed over fname, so fname always holds
+// either the previous or the new complete snapshot.
+func (c *cache[K, V]) SaveFileWith(fname string, codec Codec[K, V]) error {
+	return writeSnapshotFile(fname, codec, c.save)
+}
+
+// WriteSnapshotFile writes recs to fname as a snapshot encoded with codec,
+// replacing fname atomically like SaveFileWith. Expired records are written
+// as they are; Load skips them.
+func WriteSnapshotFile[K comparable, V any](fname string, codec Codec[K, V], recs []Record[K, V]) error {
+	return writeSnapshotFile(fname, codec, func(enc Encoder[K, V]) (int, error) {
+		for i, r := range recs {
+			if err := enc.Encode(r); err != nil {
+				return i, err
+			}
+		}
+		return len(recs), nil
+	})
+}
+
+// syncDir makes a rename in dir durable. Not every platform supports
+// syncing a directory, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// builtinCodec returns the codec of the given name shipped with this package
+func builtinCodec[K comparable, V any](name string) Codec[K, V] {
+	switch name {
+	case "gob":
+		return GobCodec[K, V]{}
+	case "json":
+		return JSONCodec[K, V]{}
+	}
+	return nil
+}
+
+// openSnapshot checks the snapshot fname and returns a reader positioned at
+// the start of its body, limited to the body. codec may be nil to accept any
+// of the built-in codecs.
+func openSnapshot[K comparable, V any](fname string, codec Codec[K, V]) (*os.File, io.Reader, Codec[K, V], SnapshotInfo, error) {
+	fp, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, nil, SnapshotInfo{}, err
+	}
+	fail := func(err error) (*os.File, io.Reader, Codec[K, V], SnapshotInfo, error) {
+		fp.Close()
+		return nil, nil, nil, SnapshotInfo{}, err
+	}
+	st, err := fp.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	info, hlen, err := readSnapshotHeader(bufio.NewReader(fp), fname)
+	if err != nil {
+		return fail(err)
+	}
+	if codec == nil {
+		codec = builtinCodec[K, V](info.Codec)
+		if codec == nil {
+			return fail(&SnapshotError{Path: fname, Err: ErrSnapshotCodec, Detail: fmt.Sprintf("no built-in codec %q", info.Codec)})
+		}
+	} else if codec.Name() != info.Codec {
+		return fail(&SnapshotError{Path: fname, Err: ErrSnapshotCodec, Detail: fmt.Sprintf("file uses %q, not %q", info.Codec, codec.Name())})
+	}
+	bodyLen := st.Size() - int64(hlen) - snapshotTrailerLen
+	if bodyLen < 0 {
+		return fail(&SnapshotError{Path: fname, Err: ErrSnapshotCorrupt, Detail: "truncated"})
+	}
+	var trailer [snapshotTrailerLen]byte
+	if _, err = fp.ReadAt(trailer[:], int64(hlen)+bodyLen); err != nil {
+		return fail(err)
+	}
+	if int64(binary.BigEndian.Uint64(trailer[:8])) != bodyLen {
+		return fail(&SnapshotError{Path: fname, Err: ErrSnapshotCorrupt, Detail: "length mismatch"})
+	}
+	h := crc32.NewIEEE()
+	if _, err = io.Copy(h, io.NewSectionReader(fp, int64(hlen), bodyLen)); err != nil {
+		return fail(err)
+	}
+	if info.Version >= 2 {
+		if _, err = io.Copy(h, io.NewSectionReader(fp, 0, int64(hlen))); err != nil {
+			return fail(err)
+		}
+	}
+	if h.Sum32() != binary.BigEndian.Uint32(trailer[8:]) {
+		return fail(&SnapshotError{Path: fname, Err: ErrSnapshotCorrupt, Detail: "checksum mismatch"})
+	}
+	return fp, bufio.NewReader(io.NewSectionReader(fp, int64(hlen), bodyLen)), codec, info, nil
+}
+
+// LoadFileMergeWith Load and add cache items from the snapshot fname written
+// with codec, resolving keys that already exist in the current cache with the
+// given strategy. Like LoadFileMerge, snapshots with a bad checksum or
+// another codec are refused with a *SnapshotError before any item is added.
+func (c *cache[K, V]) LoadFileMergeWith(fname string, codec Codec[K, V], s MergeStrategy) error {
+	return c.loadSnapshot(fname, codec, s)
+}
+
+// LoadFileWith Load and add cache items from the snapshot fname written with
+// codec, excluding any items with keys that already exist in the current
+// cache.
+func (c *cache[K, V]) LoadFileWith(fname string, codec Codec[K, V]) error {
+	return c.loadSnapshot(fname, codec, MergeKeepExisting)
+}
+
+// loadSnapshot verifies the snapshot, then decodes and adds its records
+// persistChunk at a time. A file without a snapshot header, such as one
+// written by Save or by SaveFile before snapshots had a header, is read as a
+// plain stream encoded with codec, or with gob if codec is nil.
+func (c *cache[K, V]) loadSnapshot(fname string, codec Codec[K, V], s MergeStrategy) (err error) {
+	sp := c.trace(OpLoad)
+	n := 0
+	defer func() {
+		sp.end(OutcomeOK, err, n)
+	}()
+	_, err = readSnapshot(fname, codec, func(recs []Record[K, V]) {
+		n += c.merge(recs, s)
+	})
+	if errors.Is(err, ErrSnapshotMagic) {
+		if recs, ok := readPlainFile(fname, codec); ok {
+			n, err = c.mergeAll(recs, s), nil
+		}
+	}
+	return err
+}
+
+// readPlainFile returns the records of the non-empty file fname holding a
+// stream encoded with codec, or with gob if codec is nil, and reports whether
+// the whole file decoded. Without a checksum the whole file has to decode
+// before any record can be trusted.
+func readPlainFile[K comparable, V any](fname string, codec Codec[K, V]) ([]Record[K, V], bool) {
+	if codec == nil {
+		codec = GobCodec[K, V]{}
+	}
+	fp, err := os.Open(fname)
+	if err != nil {
+		return nil, false
+	}
+	defer fp.Close()
+	if st, err := fp.Stat(); err != nil || st.Size() == 0 {
+		return nil, false
+	}
+	recs, err := decodeAll(codec.NewDecoder(bufio.NewReader(fp)))
+	return recs, err == nil
+}
+
+// ReadSnapshotFile returns the header and all records of the snapshot fname,
+// including expired ones, e.g. to inspect or rewrite it without a cache.
+// codec may be nil to use the built-in codec named in the header. Corrupted
+// or incompatible snapshots are refused with a *SnapshotError.
+func ReadSnapshotFile[K comparable, V any](fname string, codec Codec[K, V]) (SnapshotInfo, []Record[K, V], error) {
+	var recs []Record[K, V]
+	info, err := readSnapshot(fname, codec, func(chunk []Record[K, V]) {
+		recs = append(recs, chunk...)
+	})
+	if err != nil {
+		return info, nil, err
+	}
+	return info, recs, nil
+}
+
+// readSnapshot verifies the checksum of the snapshot fname, then decodes its
+// records and passes them to f persistChunk at a time. f must not keep the
+// slice. A body failing to decode or holding fewer or more records than the
+// header says is reported after f saw the records before the failure.
+func readSnapshot[K comparable, V any](fname string, codec Codec[K, V], f func([]Record[K, V])) (SnapshotInfo, error) {
+	fp, body, codec, info, err := openSnapshot(fname, codec)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer fp.Close()
+	n, err := decodeChunks(codec.NewDecoder(body), f)
+	if err != nil {
+		return info, &SnapshotError{Path: fname, Err: ErrSnapshotCorrupt, Detail: err.Error()}
+	}
+	if n != info.Count {
+		return info, &SnapshotError{Path: fname, Err: ErrSnapshotCorrupt, Detail: fmt.Sprintf("%d records, header says %d", n, info.Count)}
+	}
+	return info, nil
+}
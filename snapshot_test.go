@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func testSnapshotFile(t *testing.T) (string, *Any[string, int]) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, 1*time.Hour)
+	if err := tc.SaveFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	return fname, tc
+}
+
+func TestSnapshotFile(t *testing.T) {
+	start := time.Now()
+	fname, _ := testSnapshotFile(t)
+	info, err := ReadSnapshotInfo(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != SnapshotVersion || info.Codec != "gob" || info.Count != 2 {
+		t.Error("unexpected snapshot info:", info)
+	}
+	if info.Created.Before(start) || info.Created.After(time.Now()) {
+		t.Error("unexpected creation time:", info.Created)
+	}
+
+	oc := New[string, int](DefaultExpiration, 0)
+	if err := oc.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if oc.ItemCount() != 2 {
+		t.Error("unexpected item count:", oc.ItemCount())
+	}
+}
+
+func TestSnapshotFileJSON(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	if err := tc.SaveFileWith(fname, JSONCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	// LoadFile detects the built-in codec from the header
+	oc := New[string, int](DefaultExpiration, 0)
+	if err := oc.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := oc.Get("a"); v != 1 {
+		t.Error("a was not loaded:", v)
+	}
+	err := oc.LoadFileWith(fname, GobCodec[string, int]{})
+	if !errors.Is(err, ErrSnapshotCodec) {
+		t.Error("loading with the wrong codec didn't fail with ErrSnapshotCodec:", err)
+	}
+}
+
+func TestSnapshotFileCorrupt(t *testing.T) {
+	fname, _ := testSnapshotFile(t)
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := ReadSnapshotInfo(fname)
+	hlen := 8 + 2 + 1 + len(info.Codec) + 8 + 8
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrSnapshotMagic},
+		{"magic", append([]byte("NOTCACHE"), data[8:]...), ErrSnapshotMagic},
+		{"version", append(append(append([]byte{}, data[:8]...), 0, 99), data[10:]...), ErrSnapshotVersion},
+		{"truncated", data[:len(data)-5], ErrSnapshotCorrupt},
+		{"flipped", func() []byte {
+			b := append([]byte{}, data...)
+			b[hlen+3] ^= 0xff
+			return b
+		}(), ErrSnapshotCorrupt},
+		{"created", func() []byte {
+			b := append([]byte{}, data...)
+			b[hlen-1] ^= 0xff
+			return b
+		}(), ErrSnapshotCorrupt},
+	}
+	for _, tt := range tests {
+		bad := filepath.Join(t.TempDir(), tt.name)
+		if err := os.WriteFile(bad, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		oc := New[string, int](DefaultExpiration, 0)
+		err := oc.LoadFile(bad)
+		var se *SnapshotError
+		if !errors.Is(err, tt.want) || !errors.As(err, &se) || se.Path != bad {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if oc.ItemCount() != 0 {
+			t.Errorf("%s: items were loaded from a refused snapshot", tt.name)
+		}
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	fname, _ := testSnapshotFile(t)
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := ReadSnapshotInfo(fname)
+	hlen := 8 + 2 + 1 + len(info.Codec) + 8 + 8
+	// Version 1 checksums only the body
+	binary.BigEndian.PutUint16(data[8:], 1)
+	body := data[hlen : len(data)-snapshotTrailerLen]
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(body))
+	if err = os.WriteFile(fname, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oc := New[string, int](DefaultExpiration, 0)
+	if err = oc.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if oc.ItemCount() != info.Count {
+		t.Error("items loaded from a version 1 snapshot:", oc.ItemCount())
+	}
+}
+
+func TestSaveFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions")
+	}
+	dir := t.TempDir()
+	probe, err := os.Create(filepath.Join(dir, "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	st, _ := os.Stat(probe.Name())
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+
+	// A new snapshot gets the mode of os.Create
+	fname := filepath.Join(dir, "cache.snap")
+	if err = tc.SaveFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Stat(fname); got.Mode() != st.Mode() {
+		t.Errorf("new snapshot has mode %v, want %v", got.Mode(), st.Mode())
+	}
+	// A replaced snapshot keeps its mode
+	if err = os.Chmod(fname, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err = tc.SaveFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Stat(fname); got.Mode().Perm() != 0o640 {
+		t.Errorf("replaced snapshot has mode %v, want 0640", got.Mode())
+	}
+}
+
+func TestSaveFileAtomic(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	tc := New[string, any](DefaultExpiration, 0)
+	tc.Set("a", "a", DefaultExpiration)
+	if err := tc.SaveFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(fname)
+
+	tc.Set("chan", make(chan int), DefaultExpiration)
+	if err := tc.SaveFile(fname); err == nil {
+		t.Fatal("SaveFile of a chan didn't fail")
+	}
+	after, _ := os.ReadFile(fname)
+	if string(before) != string(after) {
+		t.Error("failed SaveFile modified the existing snapshot")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(fname))
+	if len(entries) != 1 {
+		t.Error("temporary file was left behind:", entries)
+	}
+}
+
+func TestReadWriteSnapshotFile(t *testing.T) {
+	fname, _ := testSnapshotFile(t)
+	info, recs, err := ReadSnapshotFile[string, int](fname, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != "gob" || len(recs) != 2 {
+		t.Fatal("unexpected snapshot:", info, recs)
+	}
+	// Expired records are read and written as they are
+	recs = append(recs, Record[string, int]{Key: "expired", Value: 3, Expiration: 1})
+	if err = WriteSnapshotFile[string, int](fname, JSONCodec[string, int]{}, recs); err != nil {
+		t.Fatal(err)
+	}
+	info, recs, err = ReadSnapshotFile[string, int](fname, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != "json" || info.Count != 3 || len(recs) != 3 {
+		t.Fatal("unexpected snapshot:", info, recs)
+	}
+	_, _, err = ReadSnapshotFile[string, int](fname, GobCodec[string, int]{})
+	if !errors.Is(err, ErrSnapshotCodec) {
+		t.Error("codec mismatch not detected:", err)
+	}
+	oc := New[string, int](DefaultExpiration, 0)
+	if err = oc.LoadFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if oc.ItemCount() != 2 {
+		t.Error("unexpected item count:", oc.ItemCount())
+	}
+}
+
+func TestLoadFileCountMismatch(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.snap")
+	err := writeSnapshotFile(fname, GobCodec[string, int]{}, func(enc Encoder[string, int]) (int, error) {
+		for i, k := range []string{"a", "b", "c"} {
+			if err := enc.Encode(Record[string, int]{Key: k, Value: i}); err != nil {
+				return 0, err
+			}
+		}
+		return 4, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := New[string, int](DefaultExpiration, 0)
+	var se *SnapshotError
+	if err := tc.LoadFile(fname); !errors.As(err, &se) || !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatal("count mismatch not refused:", err)
+	}
+	// The checksum held, so the records were added as they were decoded
+	if tc.ItemCount() != 3 {
+		t.Error("unexpected item count:", tc.ItemCount())
+	}
+	if _, recs, err := ReadSnapshotFile[string, int](fname, nil); !errors.Is(err, ErrSnapshotCorrupt) || recs != nil {
+		t.Error("count mismatch not refused by ReadSnapshotFile:", err, recs)
+	}
+}
@@ -0,0 +1,57 @@
+package cache
+
+import "sync/atomic"
+
+// Stats is a point-in-time copy of the cache counters returned by Stats().
+// The cache doesn't load missing items itself; the loader calls, errors and
+// latency of a cluster.Group are counted in its GroupStats.
+type Stats struct {
+	Hits        uint64 // lookups that found an unexpired item
+	Misses      uint64 // lookups that found nothing or an expired item
+	Sets        uint64 // items written by Set, Add, Replace, SetMax and SetMin
+	Deletes     uint64 // items removed by Delete
+	Expirations uint64 // expired items removed by DeleteExpired or to make room
+	Evictions   uint64 // unexpired items removed to stay within the capacity
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 if there were no lookups.
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// stats holds the live counters. Every field is updated atomically so the
+// counters can be bumped without holding c.mu.
+type stats struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	sets        atomic.Uint64
+	deletes     atomic.Uint64
+	expirations atomic.Uint64
+	evictions   atomic.Uint64
+}
+
+// Stats returns a copy of the cache counters.
+func (c *cache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Sets:        c.stats.sets.Load(),
+		Deletes:     c.stats.deletes.Load(),
+		Expirations: c.stats.expirations.Load(),
+		Evictions:   c.stats.evictions.Load(),
+	}
+}
+
+// ResetStats sets all cache counters back to zero.
+func (c *cache[K, V]) ResetStats() {
+	c.stats.hits.Store(0)
+	c.stats.misses.Store(0)
+	c.stats.sets.Store(0)
+	c.stats.deletes.Store(0)
+	c.stats.expirations.Store(0)
+	c.stats.evictions.Store(0)
+}
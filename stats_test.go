@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("expired", 3, 1*time.Millisecond)
+	if err := tc.Add("c", 3, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Add("c", 4, DefaultExpiration); err == nil {
+		t.Error("Add of an existing item didn't fail")
+	}
+
+	tc.Get("a")
+	tc.GetWithExpiration("b")
+	tc.Get("missing")
+	<-time.After(5 * time.Millisecond)
+	tc.Get("expired")
+
+	tc.Delete("a")
+	tc.Delete("missing")
+	tc.DeleteExpired()
+
+	s := tc.Stats()
+	if s.Sets != 4 {
+		t.Error("Sets is not 4:", s.Sets)
+	}
+	if s.Hits != 2 {
+		t.Error("Hits is not 2:", s.Hits)
+	}
+	if s.Misses != 2 {
+		t.Error("Misses is not 2:", s.Misses)
+	}
+	if s.Deletes != 1 {
+		t.Error("Deletes is not 1:", s.Deletes)
+	}
+	if s.Expirations != 1 {
+		t.Error("Expirations is not 1:", s.Expirations)
+	}
+	if r := s.HitRatio(); r != 0.5 {
+		t.Error("HitRatio is not 0.5:", r)
+	}
+
+	tc.ResetStats()
+	if s := tc.Stats(); s != (Stats{}) {
+		t.Error("Stats were not reset:", s)
+	}
+}
+
+func TestStatsNumber(t *testing.T) {
+	tc := NewNumber[string, int](DefaultExpiration, 0)
+	tc.SetMax("a", 1, DefaultExpiration)
+	tc.SetMin("a", 0, DefaultExpiration)
+	tc.Get("a")
+	if s := tc.Stats(); s.Sets != 2 || s.Hits != 1 {
+		t.Error("unexpected stats:", s)
+	}
+}
+
+func TestStatsGetWithHitExpired(t *testing.T) {
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.Set("a", 1, time.Millisecond)
+	<-time.After(5 * time.Millisecond)
+	if _, _, found := tc.GetWithHit("a"); found {
+		t.Error("GetWithHit found an expired item")
+	}
+	if s := tc.Stats(); s.Hits != 0 || s.Misses != 1 {
+		t.Error("expired item not counted as a miss:", s)
+	}
+}
+
+func BenchmarkCacheGetStats(b *testing.B) {
+	tc := New[string, string](DefaultExpiration, 0)
+	tc.Set("foo", "bar", DefaultExpiration)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tc.Get("foo")
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Backend is a second level store behind an in-process cache, e.g. Redis or
+// memcached. Get returns the item with its absolute expiration, like Items.
+// cachetest.MemoryBackend keeps the items in memory, for tests.
+type Backend[K comparable, V any] interface {
+	Get(k K) (Item[V], bool, error)
+	// Set stores v under k for ttl, or without expiration if ttl <= 0.
+	Set(k K, v V, ttl time.Duration) error
+	Delete(k K) error
+}
+
+// WritePolicy decides when writes to a Tiered cache reach the backend.
+type WritePolicy int
+
+const (
+	// WriteThrough Write to the backend first and then to L1. The write fails
+	// if the backend fails.
+	WriteThrough WritePolicy = iota
+	// WriteBack Write to L1 and queue the write for the backend, which is
+	// written to in the background. The last write per key wins.
+	WriteBack
+)
+
+// TieredOption configures a Tiered cache.
+type TieredOption func(*tieredOptions)
+
+type tieredOptions struct {
+	interval time.Duration
+	onError  func(key any, err error)
+}
+
+// WithWriteBackInterval sets how often queued writes are sent to the backend
+// with WriteBack. The default is one second.
+func WithWriteBackInterval(d time.Duration) TieredOption {
+	return func(o *tieredOptions) {
+		o.interval = d
+	}
+}
+
+// WithBackendErrorHandler is called when a queued write fails. The write is
+// retried with the next batch unless the key was written again meanwhile.
+func WithBackendErrorHandler(f func(key any, err error)) TieredOption {
+	return func(o *tieredOptions) {
+		o.onError = f
+	}
+}
+
+// pendingWrite is a write queued for the backend. A zero expiration means no
+// expiration.
+type pendingWrite[V any] struct {
+	value      V
+	expiration int64
+	delete     bool
+	seq        uint64
+}
+
+// backendRead tracks the Gets of a key reading the backend. writes counts
+// the writes of the key meanwhile: a Get only copies its item into L1 if
+// there was none, so it can't overwrite a newer value with an older one.
+type backendRead struct {
+	readers int
+	writes  uint64
+}
+
+// Tiered composes an in-process cache (L1) with a Backend (L2). Reads are
+// served from L1 and fall through to the backend on a miss, populating L1
+// with the backend's item. Writes go to both levels according to the
+// WritePolicy.
+type Tiered[K comparable, V any] struct {
+	l1     *Any[K, V]
+	l2     Backend[K, V]
+	policy WritePolicy
+
+	mu      sync.Mutex
+	pending map[K]pendingWrite[V]
+	seq     uint64
+	reads   map[K]*backendRead
+	onError func(key any, err error)
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewTiered returns a cache that uses l1 in front of l2. With WriteBack a
+// goroutine sends queued writes to l2 until Close is called.
+func NewTiered[K comparable, V any](l1 *Any[K, V], l2 Backend[K, V], policy WritePolicy, opts ...TieredOption) *Tiered[K, V] {
+	o := tieredOptions{interval: time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	t := &Tiered[K, V]{
+		l1:      l1,
+		l2:      l2,
+		policy:  policy,
+		pending: map[K]pendingWrite[V]{},
+		reads:   map[K]*backendRead{},
+		onError: o.onError,
+	}
+	if policy == WriteBack {
+		t.stop = make(chan struct{})
+		t.wg.Add(1)
+		go t.run(o.interval)
+	}
+	return t
+}
+
+// L1 returns the in-process cache.
+func (t *Tiered[K, V]) L1() *Any[K, V] {
+	return t.l1
+}
+
+// expiration returns the absolute expiration for d, like Set
+func (t *Tiered[K, V]) expiration(d time.Duration) int64 {
+	if d == DefaultExpiration {
+		d = t.l1.defaultExpiration
+	}
+	if d > 0 {
+		return time.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+// l1Duration returns how long an item expiring at e may be kept in L1: the
+// time it has left, but no longer than the default expiration of L1. It
+// returns false if the item has expired.
+func (t *Tiered[K, V]) l1Duration(e int64) (time.Duration, bool) {
+	if e == 0 {
+		return DefaultExpiration, true
+	}
+	d := time.Until(time.Unix(0, e))
+	if d <= 0 {
+		return 0, false
+	}
+	if def := t.l1.defaultExpiration; def > 0 && def < d {
+		return DefaultExpiration, true
+	}
+	return d, true
+}
+
+// Get an item from L1, or from the backend if L1 doesn't hold it. Items
+// found in the backend are added to L1, unless the key was written while
+// the backend was read.
+func (t *Tiered[K, V]) Get(k K) (V, bool, error) {
+	if v, found := t.l1.Get(k); found {
+		return v, true, nil
+	}
+	var zero V
+	t.mu.Lock()
+	// Written or deleted with WriteBack, but the backend doesn't know yet
+	if p, queued := t.pending[k]; queued {
+		t.mu.Unlock()
+		if p.delete || (p.expiration > 0 && time.Now().UnixNano() > p.expiration) {
+			return zero, false, nil
+		}
+		return p.value, true, nil
+	}
+	r := t.reads[k]
+	if r == nil {
+		r = &backendRead{}
+		t.reads[k] = r
+	}
+	r.readers++
+	writes := r.writes
+	t.mu.Unlock()
+
+	item, found, err := t.l2.Get(k)
+	d, ok := t.l1Duration(item.Expiration)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.readers--
+	if r.readers == 0 {
+		delete(t.reads, k)
+	}
+	if err != nil || !found || !ok {
+		return zero, false, err
+	}
+	// Under t.mu, so a write can't come between the check and the copy
+	if r.writes == writes {
+		t.l1.Set(k, item.Value, d)
+	}
+	return item.Value, true, nil
+}
+
+// written tells the Gets reading k from the backend that it was written,
+// t.mu held
+func (t *Tiered[K, V]) written(k K) {
+	if r := t.reads[k]; r != nil {
+		r.writes++
+	}
+}
+
+// write marks k as written for the Gets reading it from the backend
+func (t *Tiered[K, V]) write(k K) {
+	t.mu.Lock()
+	t.written(k)
+	t.mu.Unlock()
+}
+
+// Set an item in both levels. If the duration is 0 (DefaultExpiration), the
+// default expiration time of L1 is used. If it is -1 (NoExpiration), the item
+// never expires. With WriteThrough the item is removed from L1 if the backend
+// fails, so L1 never holds a value the backend rejected.
+//
+// The backend, or the queue of WriteBack, is written before L1, so a
+// concurrent Get missing L1 finds the new value there.
+func (t *Tiered[K, V]) Set(k K, v V, d time.Duration) error {
+	e := t.expiration(d)
+	if t.policy == WriteBack {
+		t.queue(k, pendingWrite[V]{value: v, expiration: e})
+		t.l1.Set(k, v, d)
+		return nil
+	}
+	err := t.l2.Set(k, v, ttl(e))
+	t.write(k)
+	if err != nil {
+		t.l1.Delete(k)
+		return err
+	}
+	t.l1.Set(k, v, d)
+	return nil
+}
+
+// Delete an item from both levels. Like Set, the backend or the queue goes
+// first, so a concurrent Get can't copy the item back into L1. With
+// WriteThrough both levels keep the item if the backend fails.
+func (t *Tiered[K, V]) Delete(k K) error {
+	if t.policy == WriteBack {
+		t.queue(k, pendingWrite[V]{delete: true})
+		t.l1.Delete(k)
+		return nil
+	}
+	if err := t.l2.Delete(k); err != nil {
+		return err
+	}
+	t.write(k)
+	t.l1.Delete(k)
+	return nil
+}
+
+// ttl returns the time left until e for the backend, or NoExpiration
+func ttl(e int64) time.Duration {
+	if e == 0 {
+		return NoExpiration
+	}
+	return max(time.Until(time.Unix(0, e)), time.Nanosecond)
+}
+
+func (t *Tiered[K, V]) queue(k K, p pendingWrite[V]) {
+	t.mu.Lock()
+	t.seq++
+	p.seq = t.seq
+	t.pending[k] = p
+	t.written(k)
+	t.mu.Unlock()
+}
+
+// Pending returns the number of writes queued for the backend.
+func (t *Tiered[K, V]) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// Sync sends the queued writes to the backend. Writes that fail stay queued
+// and are passed to the error handler; the errors are joined and returned.
+// Writes stay queued until the backend has them, so Get never reads a value
+// from the backend that is about to be overwritten.
+func (t *Tiered[K, V]) Sync() error {
+	t.mu.Lock()
+	batch := make(map[K]pendingWrite[V], len(t.pending))
+	for k, p := range t.pending {
+		batch[k] = p
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	now := time.Now().UnixNano()
+	for k, p := range batch {
+		var err error
+		switch {
+		case p.delete:
+			err = t.l2.Delete(k)
+		case p.expiration > 0 && now > p.expiration:
+			// Expired before it was written, the backend must not keep an older value
+			err = t.l2.Delete(k)
+		default:
+			err = t.l2.Set(k, p.value, ttl(p.expiration))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if t.onError != nil {
+				t.onError(k, err)
+			}
+			continue
+		}
+		t.mu.Lock()
+		// Keep the write if the key was written again meanwhile
+		if cur, found := t.pending[k]; found && cur.seq == p.seq {
+			delete(t.pending, k)
+		}
+		t.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (t *Tiered[K, V]) run(interval time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Sync()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background writer and sends the remaining queued writes
+// to the backend. L1 and the backend are left open.
+func (t *Tiered[K, V]) Close() error {
+	if t.stop == nil {
+		return nil
+	}
+	t.once.Do(func() {
+		close(t.stop)
+	})
+	t.wg.Wait()
+	return t.Sync()
+}
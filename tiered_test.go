@@ -0,0 +1,260 @@
+package cache_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akvicor/go-cache"
+	"github.com/Akvicor/go-cache/cachetest"
+)
+
+func TestTieredWriteThrough(t *testing.T) {
+	l2 := cachetest.NewMemoryBackend[string, int]()
+	tc := cache.NewTiered(cache.New[string, int](time.Minute, 0), l2, cache.WriteThrough)
+	defer tc.Close()
+
+	if err := tc.Set("a", 1, cache.DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if item, found, _ := l2.Get("a"); !found || item.Value != 1 || item.Expiration == 0 {
+		t.Error("write didn't reach the backend with its expiration:", item, found)
+	}
+
+	// Reads fall through to the backend and populate L1
+	l2.Set("b", 2, time.Hour)
+	if v, found, err := tc.Get("b"); err != nil || !found || v != 2 {
+		t.Fatalf("b is %d, %v, %v", v, found, err)
+	}
+	_, e, found := tc.L1().GetWithExpiration("b")
+	if !found {
+		t.Fatal("b was not added to L1")
+	}
+	if time.Until(e) > time.Minute {
+		t.Error("L1 keeps b longer than its default expiration:", time.Until(e))
+	}
+	calls := l2.Calls()
+	tc.Get("b")
+	if l2.Calls() != calls {
+		t.Error("hit in L1 went to the backend")
+	}
+
+	fail := errors.New("down")
+	l2.Fail(fail)
+	if err := tc.Set("a", 3, cache.DefaultExpiration); !errors.Is(err, fail) {
+		t.Error("Set didn't return the backend error:", err)
+	}
+	if _, found := tc.L1().Get("a"); found {
+		t.Error("L1 kept an item the backend rejected")
+	}
+	if _, _, err := tc.Get("a"); !errors.Is(err, fail) {
+		t.Error("Get didn't return the backend error:", err)
+	}
+	if err := tc.Delete("b"); !errors.Is(err, fail) {
+		t.Error("Delete didn't return the backend error:", err)
+	}
+	if _, found := tc.L1().Get("b"); !found {
+		t.Error("failed Delete removed b from L1")
+	}
+	l2.Fail(nil)
+	if v, found, err := tc.Get("b"); v != 2 || !found || err != nil {
+		t.Errorf("b is %d, %v, %v after a failed Delete", v, found, err)
+	}
+
+	if err := tc.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := tc.Get("b"); found {
+		t.Error("deleted item is still found")
+	}
+	if _, found := tc.L1().Get("b"); found {
+		t.Error("deleted item is still in L1")
+	}
+}
+
+func TestTieredWriteBack(t *testing.T) {
+	l2 := cachetest.NewMemoryBackend[string, int]()
+	var failed []any
+	tc := cache.NewTiered(cache.New[string, int](cache.NoExpiration, 0), l2, cache.WriteBack,
+		cache.WithWriteBackInterval(time.Hour),
+		cache.WithBackendErrorHandler(func(key any, err error) { failed = append(failed, key) }))
+
+	l2.Set("old", 1, cache.NoExpiration)
+	tc.Set("a", 1, cache.DefaultExpiration)
+	tc.Set("a", 2, cache.DefaultExpiration)
+	tc.Set("b", 3, time.Hour)
+	tc.Delete("old")
+	if l2.Calls() != 1 {
+		t.Error("writes reached the backend before Sync:", l2.Calls())
+	}
+	if _, found, _ := tc.Get("old"); found {
+		t.Error("item deleted in L1 was read back from the backend")
+	}
+	tc.L1().Delete("a")
+	if v, found, _ := tc.Get("a"); !found || v != 2 {
+		t.Error("queued write was not served:", v, found)
+	}
+	if tc.Pending() != 3 {
+		t.Error("expected 3 pending writes, got", tc.Pending())
+	}
+
+	l2.Fail(errors.New("down"))
+	if err := tc.Sync(); err == nil {
+		t.Error("Sync didn't report the backend errors")
+	}
+	if len(failed) != 3 || tc.Pending() != 3 {
+		t.Errorf("failed writes were not kept: %v, %d pending", failed, tc.Pending())
+	}
+	l2.Fail(nil)
+
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tc.Pending() != 0 {
+		t.Error("Close left pending writes:", tc.Pending())
+	}
+	if item, found, _ := l2.Get("a"); !found || item.Value != 2 || item.Expiration != 0 {
+		t.Error("last write of a didn't reach the backend:", item, found)
+	}
+	if item, found, _ := l2.Get("b"); !found || item.Expiration == 0 {
+		t.Error("b reached the backend without its expiration:", item, found)
+	}
+	if _, found, _ := l2.Get("old"); found {
+		t.Error("delete didn't reach the backend")
+	}
+}
+
+func TestSetWithCallbackTiered(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]int{}
+	l1 := cache.New[string, int](cache.NoExpiration, 0)
+	tc := cache.NewTiered(l1, cachetest.NewMemoryBackend[string, int](), cache.WriteThrough)
+	defer tc.Close()
+	l1.SetWithCallback("a", 1, cache.DefaultExpiration, func(k string, v int) {
+		mu.Lock()
+		got[k] = v
+		mu.Unlock()
+	})
+	if err := tc.Set("a", 2, cache.DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if v, ok := got["a"]; !ok || v != 2 || len(got) != 1 {
+		t.Error("callback not called once by Tiered.Delete:", got)
+	}
+}
+
+// blockingBackend holds the first Get after reading the item, until release
+// is closed
+type blockingBackend struct {
+	*cachetest.MemoryBackend[string, int]
+	once    sync.Once
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Get(k string) (cache.Item[int], bool, error) {
+	item, found, err := b.MemoryBackend.Get(k)
+	b.once.Do(func() {
+		close(b.reading)
+		<-b.release
+	})
+	return item, found, err
+}
+
+func TestTieredGetDuringWrite(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy cache.WritePolicy
+		write  func(tc *cache.Tiered[string, int]) error
+		want   int
+		found  bool
+	}{
+		{"write-through set", cache.WriteThrough, func(tc *cache.Tiered[string, int]) error {
+			return tc.Set("a", 2, cache.DefaultExpiration)
+		}, 2, true},
+		{"write-through delete", cache.WriteThrough, func(tc *cache.Tiered[string, int]) error {
+			return tc.Delete("a")
+		}, 0, false},
+		{"write-back set", cache.WriteBack, func(tc *cache.Tiered[string, int]) error {
+			return tc.Set("a", 2, cache.DefaultExpiration)
+		}, 2, true},
+		{"write-back delete", cache.WriteBack, func(tc *cache.Tiered[string, int]) error {
+			return tc.Delete("a")
+		}, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l2 := &blockingBackend{
+				MemoryBackend: cachetest.NewMemoryBackend[string, int](),
+				reading:       make(chan struct{}),
+				release:       make(chan struct{}),
+			}
+			l2.Set("a", 1, cache.NoExpiration)
+			tc := cache.NewTiered(cache.New[string, int](cache.NoExpiration, 0), l2, tt.policy,
+				cache.WithWriteBackInterval(time.Hour))
+			defer tc.Close()
+
+			// The Get has read the old value when the write happens
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tc.Get("a")
+			}()
+			<-l2.reading
+			if err := tt.write(tc); err != nil {
+				t.Fatal(err)
+			}
+			close(l2.release)
+			<-done
+
+			if v, found := tc.L1().Get("a"); found && v != tt.want {
+				t.Error("Get copied the old value into L1:", v)
+			}
+			if v, found, err := tc.Get("a"); err != nil || found != tt.found || v != tt.want {
+				t.Errorf("got %d, %v, %v after the write", v, found, err)
+			}
+		})
+	}
+}
+
+func TestTieredConcurrent(t *testing.T) {
+	for _, policy := range []cache.WritePolicy{cache.WriteThrough, cache.WriteBack} {
+		l2 := cachetest.NewMemoryBackend[int, int]()
+		tc := cache.NewTiered(cache.New[int, int](cache.NoExpiration, 0), l2, policy,
+			cache.WithWriteBackInterval(time.Millisecond))
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// One writer per key, Gets of all of them
+				for n := 0; n < 500; n++ {
+					switch n % 3 {
+					case 0:
+						tc.Set(i, n, cache.DefaultExpiration)
+					case 1:
+						tc.Delete(i)
+					default:
+						tc.Get(n % 8)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if err := tc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Once quiet, L1 agrees with the backend
+		for k := 0; k < 8; k++ {
+			item, inL2, _ := l2.Get(k)
+			if v, inL1 := tc.L1().Get(k); inL1 && (!inL2 || v != item.Value) {
+				t.Errorf("policy %d: L1 holds %d for %d, the backend %d, %v", policy, v, k, item.Value, inL2)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import "time"
+
+// Op names a traced cache operation.
+type Op string
+
+const (
+	OpGet           Op = "get" // Get and its GetWith variants
+	OpSet           Op = "set" // Set, SetMax and SetMin
+	OpAdd           Op = "add"
+	OpReplace       Op = "replace"
+	OpUpdate        Op = "update" // Update, UpdateMax and UpdateMin
+	OpIncrement     Op = "increment"
+	OpDecrement     Op = "decrement"
+	OpDelete        Op = "delete" // Delete and Remove
+	OpDeleteExpired Op = "delete_expired"
+	OpSave          Op = "save"
+	OpLoad          Op = "load"   // loading a dump or snapshot
+	OpLoader        Op = "loader" // a read-through loader, e.g. of cluster.Group
+)
+
+// Outcome is the result of a traced operation.
+type Outcome string
+
+const (
+	OutcomeHit   Outcome = "hit"   // Get found an unexpired item
+	OutcomeMiss  Outcome = "miss"  // Get found nothing or an expired item
+	OutcomeOK    Outcome = "ok"    // the operation succeeded
+	OutcomeError Outcome = "error" // the operation failed, see SpanEnd.Err
+)
+
+// SpanEnd carries the result of a traced operation.
+type SpanEnd struct {
+	Outcome  Outcome
+	Err      error
+	Duration time.Duration
+	// Items is the number of items affected: 1 for a write or a loaded
+	// value, 1 or 0 for a Delete depending on whether the key was there, and
+	// the count of DeleteExpired, Save and Load.
+	Items int
+}
+
+// Tracer receives start/end callbacks around cache operations. Start is
+// called with the key of the operation, or nil for operations that are not
+// about a single key (DeleteExpired, Save, Load).
+type Tracer interface {
+	Start(op Op, key any) Span
+}
+
+// Span is returned by Tracer.Start and ended once the operation finished.
+type Span interface {
+	End(SpanEnd)
+}
+
+// SetTracer installs t on the cache. Passing nil removes the tracer.
+func (c *cache[K, V]) SetTracer(t Tracer) {
+	if t == nil {
+		c.tracer.Store(nil)
+		return
+	}
+	c.tracer.Store(&t)
+}
+
+// traceSpan is a started span together with its start time
+type traceSpan struct {
+	span  Span
+	start time.Time
+}
+
+// trace starts a span for an operation without a key. It returns nil if no
+// tracer is installed.
+func (c *cache[K, V]) trace(op Op) *traceSpan {
+	t := c.tracer.Load()
+	if t == nil {
+		return nil
+	}
+	return &traceSpan{span: (*t).Start(op, nil), start: time.Now()}
+}
+
+// traceKey starts a span for an operation on k. The key is only boxed if a
+// tracer is installed.
+func (c *cache[K, V]) traceKey(op Op, k K) *traceSpan {
+	t := c.tracer.Load()
+	if t == nil {
+		return nil
+	}
+	return &traceSpan{span: (*t).Start(op, k), start: time.Now()}
+}
+
+// end ends the span, nil-safe
+func (s *traceSpan) end(outcome Outcome, err error, n int) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		outcome = OutcomeError
+	}
+	s.span.End(SpanEnd{
+		Outcome:  outcome,
+		Err:      err,
+		Duration: time.Since(s.start),
+		Items:    n,
+	})
+}
+
+// endLookup ends the span of a lookup
+func (s *traceSpan) endLookup(found bool) {
+	if s == nil {
+		return
+	}
+	if found {
+		s.end(OutcomeHit, nil, 0)
+	} else {
+		s.end(OutcomeMiss, nil, 0)
+	}
+}
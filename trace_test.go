@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testTraceRecord struct {
+	op  Op
+	key any
+	end SpanEnd
+}
+
+type testTracer struct {
+	mu      sync.Mutex
+	records []testTraceRecord
+}
+
+type testSpan struct {
+	t   *testTracer
+	op  Op
+	key any
+}
+
+func (t *testTracer) Start(op Op, key any) Span {
+	return &testSpan{t, op, key}
+}
+
+func (s *testSpan) End(e SpanEnd) {
+	s.t.mu.Lock()
+	s.t.records = append(s.t.records, testTraceRecord{s.op, s.key, e})
+	s.t.mu.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	tr := &testTracer{}
+	tc := New[string, int](DefaultExpiration, 0)
+	tc.SetTracer(tr)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("expired", 2, 1*time.Millisecond)
+	tc.Get("a")
+	tc.Get("b")
+	<-time.After(5 * time.Millisecond)
+	tc.DeleteExpired()
+	fp := &bytes.Buffer{}
+	if err := tc.Save(fp); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Load(fp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		op      Op
+		key     any
+		outcome Outcome
+		items   int
+	}{
+		{OpSet, "a", OutcomeOK, 1},
+		{OpSet, "expired", OutcomeOK, 1},
+		{OpGet, "a", OutcomeHit, 0},
+		{OpGet, "b", OutcomeMiss, 0},
+		{OpDeleteExpired, nil, OutcomeOK, 1},
+		{OpSave, nil, OutcomeOK, 1},
+		{OpLoad, nil, OutcomeOK, 0},
+	}
+	if len(tr.records) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(tr.records), len(want))
+	}
+	for i, w := range want {
+		r := tr.records[i]
+		if r.op != w.op || r.key != w.key || r.end.Outcome != w.outcome || r.end.Items != w.items {
+			t.Errorf("span %d: got %v %v %v %d, want %v %v %v %d", i,
+				r.op, r.key, r.end.Outcome, r.end.Items, w.op, w.key, w.outcome, w.items)
+		}
+		if r.end.Duration < 0 {
+			t.Errorf("span %d has a negative duration", i)
+		}
+	}
+
+	tc.SetTracer(nil)
+	tc.Get("a")
+	if len(tr.records) != len(want) {
+		t.Error("span recorded after the tracer was removed")
+	}
+}
+
+func TestTracerOps(t *testing.T) {
+	tr := &testTracer{}
+	tc := NewNumber[string, int](DefaultExpiration, 0)
+	tc.SetTracer(tr)
+	tc.Add("a", 1, DefaultExpiration)
+	tc.Add("a", 1, DefaultExpiration)
+	tc.Replace("a", 2, DefaultExpiration)
+	tc.Replace("b", 2, DefaultExpiration)
+	tc.Increment("a", 3)
+	tc.Decrement("b", 1)
+	tc.GetWithExpiration("a")
+	tc.GetWithHit("b")
+	tc.GetWithHitExpiration("a")
+	tc.Update("a", func(v int) (int, error) { return v + 1, nil })
+	tc.UpdateMax("b", 1)
+	tc.SetMin("a", 1, DefaultExpiration)
+	tc.IncrementChecked("a", 1)
+	tc.DecrementChecked("b", 1)
+	tc.Delete("a")
+	tc.Remove("a")
+
+	want := []struct {
+		op      Op
+		outcome Outcome
+		items   int
+	}{
+		{OpAdd, OutcomeOK, 1},
+		{OpAdd, OutcomeError, 0},
+		{OpReplace, OutcomeOK, 1},
+		{OpReplace, OutcomeError, 0},
+		{OpIncrement, OutcomeOK, 1},
+		{OpDecrement, OutcomeError, 0},
+		{OpGet, OutcomeHit, 0},
+		{OpGet, OutcomeMiss, 0},
+		{OpGet, OutcomeHit, 0},
+		{OpUpdate, OutcomeOK, 1},
+		{OpUpdate, OutcomeError, 0},
+		{OpSet, OutcomeOK, 1},
+		{OpIncrement, OutcomeOK, 1},
+		{OpDecrement, OutcomeError, 0},
+		{OpDelete, OutcomeOK, 1},
+		{OpDelete, OutcomeOK, 0},
+	}
+	if len(tr.records) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(tr.records), len(want))
+	}
+	for i, w := range want {
+		r := tr.records[i]
+		if r.op != w.op || r.end.Outcome != w.outcome || r.end.Items != w.items {
+			t.Errorf("span %d: got %v %v %d, want %v %v %d", i,
+				r.op, r.end.Outcome, r.end.Items, w.op, w.outcome, w.items)
+		}
+		if (w.outcome == OutcomeError) != (r.end.Err != nil) {
+			t.Errorf("span %d: error %v", i, r.end.Err)
+		}
+	}
+}
+
+func TestTracerSaveError(t *testing.T) {
+	tr := &testTracer{}
+	tc := New[string, any](DefaultExpiration, 0)
+	tc.Set("chan", make(chan bool), DefaultExpiration)
+	tc.SetTracer(tr)
+	if err := tc.Save(&bytes.Buffer{}); err == nil {
+		t.Fatal("Save of a chan didn't fail")
+	}
+	r := tr.records[len(tr.records)-1]
+	if r.op != OpSave || r.end.Outcome != OutcomeError || r.end.Err == nil {
+		t.Error("unexpected save span:", r)
+	}
+}
@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the write-ahead log is synced to disk.
+type SyncPolicy int
+
+const (
+	// SyncEverySecond Sync the log once per second. A crash loses at most the
+	// last second of writes.
+	SyncEverySecond SyncPolicy = iota
+	// SyncAlways Sync the log after every write. Writes are durable once the
+	// method returns, at the cost of an fsync per write.
+	SyncAlways
+	// SyncNever Leave syncing to the operating system.
+	SyncNever
+)
+
+type walOp uint8
+
+const (
+	walSet walOp = iota + 1
+	walDelete
+	walIncrement
+	walDecrement
+	walExpire
+	walFlush
+)
+
+// walRecord is a single logged mutation. Value holds the new value for
+// walSet and the delta for walIncrement and walDecrement.
+type walRecord[K comparable, V any] struct {
+	Op         walOp
+	Key        K
+	Value      V
+	Expiration int64
+}
+
+// Every record is framed as
+//
+//	length  uint32  length of payload
+//	crc     uint32  CRC-32 (IEEE) of payload
+//	payload         gob-encoded walRecord
+//
+// A frame that is cut short or fails its checksum marks the end of the log;
+// it is the remainder of a write interrupted by a crash.
+const walFrameHeader = 4 + 4
+
+// wal is the append-only write-ahead log of a cache. Appends happen while the
+// cache lock is held, so the log order matches the order of the mutations.
+type wal[K comparable, V any] struct {
+	path     string
+	policy   SyncPolicy
+	register bool
+
+	mu    sync.Mutex
+	fp    *os.File
+	buf   bytes.Buffer
+	tail  *bytes.Buffer // records appended while CompactWAL writes the new log
+	dirty bool
+	err   error
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	compactMu sync.Mutex // serializes CompactWAL
+}
+
+// encodeWALRecord appends the framed record to buf
+func encodeWALRecord[K comparable, V any](buf *bytes.Buffer, r walRecord[K, V], register bool) error {
+	if register {
+		if err := gobRegister(r.Key, r.Value); err != nil {
+			return err
+		}
+	}
+	start := buf.Len()
+	buf.Write(make([]byte, walFrameHeader))
+	if err := gob.NewEncoder(buf).Encode(&r); err != nil {
+		buf.Truncate(start)
+		return &EncodeError{Key: r.Key, Err: err}
+	}
+	b := buf.Bytes()[start:]
+	payload := b[walFrameHeader:]
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(payload))
+	return nil
+}
+
+// append writes r to the log. Errors are kept and reported by WALError.
+func (w *wal[K, V]) append(r walRecord[K, V]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fp == nil {
+		return
+	}
+	w.buf.Reset()
+	if err := encodeWALRecord(&w.buf, r, w.register); err != nil {
+		w.setErr(err)
+		return
+	}
+	if _, err := w.fp.Write(w.buf.Bytes()); err != nil {
+		w.setErr(err)
+		return
+	}
+	if w.tail != nil {
+		w.tail.Write(w.buf.Bytes())
+	}
+	switch w.policy {
+	case SyncAlways:
+		w.setErr(w.fp.Sync())
+	case SyncEverySecond:
+		w.dirty = true
+	}
+}
+
+// setErr keeps the first error. w.mu must be held.
+func (w *wal[K, V]) setErr(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// replay reads the log from fp and calls apply for every record. A torn
+// record at the end is cut off. It returns the offset after the last valid
+// record.
+func (w *wal[K, V]) replay(fp *os.File, apply func(walRecord[K, V])) (int64, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	br := bufio.NewReader(fp)
+	var off int64
+	var hdr [walFrameHeader]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, nil
+			}
+			return off, err
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		// A length past the end of the file is a torn or corrupted header,
+		// not a reason to allocate up to 4 GiB
+		if int64(n) > size-off-walFrameHeader {
+			return off, nil
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, nil
+			}
+			return off, err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:8]) {
+			return off, nil
+		}
+		var r walRecord[K, V]
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&r); err != nil {
+			return off, fmt.Errorf("cache: decoding write-ahead log record at offset %d: %w", off, err)
+		}
+		apply(r)
+		off += walFrameHeader + int64(n)
+	}
+}
+
+// open replays the log into c and opens it for appending. c.mu must be held.
+func (w *wal[K, V]) open(c *cache[K, V]) error {
+	fp, err := os.OpenFile(w.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	off, err := w.replay(fp, func(r walRecord[K, V]) {
+		c.replayWAL(r, now)
+	})
+	if err == nil {
+		err = fp.Truncate(off)
+	}
+	if err == nil {
+		_, err = fp.Seek(off, io.SeekStart)
+	}
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	w.mu.Lock()
+	w.fp = fp
+	w.mu.Unlock()
+	if w.policy == SyncEverySecond {
+		w.stop = make(chan struct{})
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	return nil
+}
+
+// syncLoop syncs the log once per second if it was written to
+func (w *wal[K, V]) syncLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.fp != nil {
+				w.setErr(w.fp.Sync())
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// close syncs and closes the log
+func (w *wal[K, V]) close() {
+	if w.stop != nil {
+		close(w.stop)
+		w.wg.Wait()
+		w.stop = nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fp == nil {
+		return
+	}
+	if w.policy != SyncNever {
+		w.setErr(w.fp.Sync())
+	}
+	w.setErr(w.fp.Close())
+	w.fp = nil
+}
+
+// replayWAL applies a logged mutation without logging it again
+func (c *cache[K, V]) replayWAL(r walRecord[K, V], now int64) {
+	switch r.Op {
+	case walSet:
+		if r.Expiration > 0 && now > r.Expiration {
+			delete(c.items, r.Key)
+			return
+		}
+		c.items[r.Key] = Item[V]{Value: r.Value, Expiration: r.Expiration}
+	case walDelete:
+		delete(c.items, r.Key)
+	case walIncrement, walDecrement:
+		item, found := c.items[r.Key]
+		if !found || c.addValue == nil {
+			return
+		}
+		item.Value = c.addValue(item.Value, r.Value, r.Op == walDecrement)
+		c.items[r.Key] = item
+	case walExpire:
+		item, found := c.items[r.Key]
+		if !found {
+			return
+		}
+		if r.Expiration > 0 && now > r.Expiration {
+			delete(c.items, r.Key)
+			return
+		}
+		item.Expiration = r.Expiration
+		c.items[r.Key] = item
+	case walFlush:
+		c.items = map[K]Item[V]{}
+	}
+}
+
+// logWAL appends r to the write-ahead log if there is one. c.mu must be held.
+func (c *cache[K, V]) logWAL(r walRecord[K, V]) {
+	if c.wal != nil {
+		c.wal.append(r)
+	}
+}
+
+// WALError returns the first error that occurred while writing the
+// write-ahead log, or nil. Mutations keep being applied in memory after an
+// error, but may no longer be durable.
+func (c *cache[K, V]) WALError() error {
+	if c.wal == nil {
+		return nil
+	}
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+	return c.wal.err
+}
+
+// ErrNoWAL is returned by CompactWAL if the cache has no write-ahead log.
+var ErrNoWAL = errors.New("cache: no write-ahead log configured")
+
+// CompactWAL rewrites the write-ahead log as a snapshot of the unexpired items,
+// dropping the history of overwritten and deleted keys. The items are encoded
+// under the read lock; the new log is written without it, and writers are
+// only blocked while the records logged in the meantime are appended to it.
+func (c *cache[K, V]) CompactWAL() (err error) {
+	w := c.wal
+	if w == nil {
+		return ErrNoWAL
+	}
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+
+	var buf bytes.Buffer
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	w.mu.Lock()
+	if w.fp == nil {
+		err = os.ErrClosed
+	}
+	for k, v := range c.items {
+		if err != nil {
+			break
+		}
+		if v.Expiration > 0 && now > v.Expiration {
+			continue
+		}
+		err = encodeWALRecord(&buf, walRecord[K, V]{Op: walSet, Key: k, Value: v.Value, Expiration: v.Expiration}, w.register)
+	}
+	if err == nil {
+		// Keep the records logged from now on for the new log
+		w.tail = &bytes.Buffer{}
+	}
+	w.mu.Unlock()
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		w.mu.Lock()
+		w.tail = nil
+		w.mu.Unlock()
+	}()
+
+	tmp, err := createTemp(w.path, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fp == nil {
+		return os.ErrClosed
+	}
+	if _, err = tmp.Write(w.tail.Bytes()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), w.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(w.path))
+	// Keep appending to the new log
+	w.fp.Close()
+	w.fp = tmp
+	w.dirty = false
+	return nil
+}
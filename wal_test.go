@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWAL(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncEverySecond, SyncAlways, SyncNever} {
+		fname := filepath.Join(t.TempDir(), "cache.wal")
+		tc := NewNumber[string, int](NoExpiration, 0, WithWAL(fname, policy))
+		tc.Set("a", 1, DefaultExpiration)
+		tc.Set("b", 2, DefaultExpiration)
+		tc.Set("c", 3, DefaultExpiration)
+		tc.Set("gone", 4, time.Millisecond)
+		tc.Increment("a", 10)
+		tc.Decrement("b", 5)
+		tc.Delete("c")
+		if err := tc.UpdateExpiration("b", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		tc.Close()
+		if err := tc.WALError(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+
+		tc = NewNumber[string, int](NoExpiration, 0, WithWAL(fname, policy))
+		if v, found := tc.Get("a"); !found || v != 11 {
+			t.Errorf("policy %d: a is %d, %v; want 11", policy, v, found)
+		}
+		if v, e, found := tc.GetWithExpiration("b"); !found || v != -3 || e.IsZero() {
+			t.Errorf("policy %d: b is %d, %v, %v; want -3 with expiration", policy, v, e, found)
+		}
+		if _, found := tc.Get("c"); found {
+			t.Errorf("policy %d: deleted c was replayed", policy)
+		}
+		if _, found := tc.Get("gone"); found {
+			t.Errorf("policy %d: expired item was replayed", policy)
+		}
+		tc.Flush()
+		tc.Set("d", 5, DefaultExpiration)
+		tc.Close()
+
+		tc = NewNumber[string, int](NoExpiration, 0, WithWAL(fname, policy))
+		if n := tc.ItemCount(); n != 1 {
+			t.Errorf("policy %d: %d items after Flush, want 1", policy, n)
+		}
+		tc.Close()
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.wal")
+	tc := New[string, string](NoExpiration, 0, WithWAL(fname, SyncNever))
+	tc.Set("a", "x", DefaultExpiration)
+	tc.Set("b", "y", DefaultExpiration)
+	tc.Close()
+
+	st, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(fname, st.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	tc = New[string, string](NoExpiration, 0, WithWAL(fname, SyncNever))
+	if err = tc.WALError(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := tc.Get("a"); !found {
+		t.Error("a is missing")
+	}
+	if _, found := tc.Get("b"); found {
+		t.Error("torn record for b was replayed")
+	}
+	tc.Set("c", "z", DefaultExpiration)
+	tc.Close()
+
+	tc = New[string, string](NoExpiration, 0, WithWAL(fname, SyncNever))
+	defer tc.Close()
+	if v, found := tc.Get("c"); !found || v != "z" {
+		t.Errorf("c is %q, %v after appending to a truncated log", v, found)
+	}
+}
+
+func TestWALCorruptLength(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.wal")
+	tc := New[string, string](NoExpiration, 0, WithWAL(fname, SyncNever))
+	tc.Set("a", "x", DefaultExpiration)
+	tc.Close()
+	st, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A torn frame claiming almost 4 GiB
+	fp.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, 1, 2, 3})
+	fp.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	tc = New[string, string](NoExpiration, 0, WithWAL(fname, SyncNever))
+	defer tc.Close()
+	runtime.ReadMemStats(&after)
+	if err = tc.WALError(); err != nil {
+		t.Fatal(err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 64<<20 {
+		t.Error("replay allocated", n, "bytes")
+	}
+	if v, found := tc.Get("a"); !found || v != "x" {
+		t.Errorf("a is %q, %v", v, found)
+	}
+	if st2, _ := os.Stat(fname); st2.Size() != st.Size() {
+		t.Error("torn frame not cut off:", st2.Size(), st.Size())
+	}
+}
+
+func TestCompactWAL(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.wal")
+	tc := NewNumber[string, int](NoExpiration, 0, WithWAL(fname, SyncAlways))
+	for i := 0; i < 100; i++ {
+		tc.Set("a", i, DefaultExpiration)
+	}
+	tc.Set("b", 1, DefaultExpiration)
+	before, _ := os.Stat(fname)
+	if err := tc.CompactWAL(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(fname)
+	if after.Size() >= before.Size() {
+		t.Errorf("log grew from %d to %d bytes", before.Size(), after.Size())
+	}
+	tc.Increment("b", 1)
+	tc.Close()
+
+	tc = NewNumber[string, int](NoExpiration, 0, WithWAL(fname, SyncAlways))
+	defer tc.Close()
+	if v, _ := tc.Get("a"); v != 99 {
+		t.Errorf("a is %d, want 99", v)
+	}
+	if v, _ := tc.Get("b"); v != 2 {
+		t.Errorf("b is %d, want 2", v)
+	}
+
+	if err := New[string, int](NoExpiration, 0).CompactWAL(); err != ErrNoWAL {
+		t.Errorf("CompactWAL without log returned %v", err)
+	}
+}
+
+func TestCompactWALConcurrentWrites(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.wal")
+	tc := NewNumber[int, int](NoExpiration, 0, WithWAL(fname, SyncNever))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			tc.Set(i, i, DefaultExpiration)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := tc.CompactWAL(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	tc.Close()
+
+	tc = NewNumber[int, int](NoExpiration, 0, WithWAL(fname, SyncNever))
+	defer tc.Close()
+	if n := tc.ItemCount(); n != 2000 {
+		t.Errorf("%d items restored, want 2000", n)
+	}
+}
+
+func TestWALReplayWithJanitor(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cache.wal")
+	tc := New[int, int](NoExpiration, 0, WithWAL(fname, SyncNever))
+	for i := 0; i < 1000; i++ {
+		tc.Set(i, i, time.Hour)
+	}
+	tc.Close()
+	j := NewJanitor()
+	tc = New[int, int](NoExpiration, time.Nanosecond, WithWAL(fname, SyncNever), WithJanitor(j))
+	defer tc.Close()
+	if n := tc.ItemCount(); n != 1000 {
+		t.Errorf("%d items restored, want 1000", n)
+	}
+}
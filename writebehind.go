@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SinkEntry is the state of a key written to a Sink. Deleted is set if the
+// key is no longer in the cache because it was deleted, flushed, evicted,
+// expired or dropped by an invalidation from another replica. Items moved to
+// the disk tier are still in the cache.
+type SinkEntry[K comparable, V number] struct {
+	Key     K
+	Value   V
+	Deleted bool
+}
+
+// Sink receives the keys of a Number cache that changed since they were last
+// written, e.g. to store them in a database. Every key appears at most once
+// per batch, with its value at the time the batch was built.
+type Sink[K comparable, V number] interface {
+	Write(batch []SinkEntry[K, V]) error
+}
+
+// WriteBehindOption configures the write-behind of SetSink.
+type WriteBehindOption func(*writeBehindOptions)
+
+type writeBehindOptions struct {
+	interval time.Duration
+	batch    int
+	attempts int
+	backoff  time.Duration
+	onError  func(err error)
+}
+
+// WithSinkInterval sets how often changed keys are written. The default is
+// one second.
+func WithSinkInterval(d time.Duration) WriteBehindOption {
+	return func(o *writeBehindOptions) {
+		o.interval = d
+	}
+}
+
+// WithSinkBatchSize sets the maximum number of keys per Write. The default
+// is 100.
+func WithSinkBatchSize(n int) WriteBehindOption {
+	return func(o *writeBehindOptions) {
+		if n < 1 {
+			n = 1
+		}
+		o.batch = n
+	}
+}
+
+// WithSinkRetry tries a failing Write up to attempts times, waiting backoff
+// before the first retry and doubling the wait before every following one.
+// The default is 3 attempts with a backoff of 100ms.
+func WithSinkRetry(attempts int, backoff time.Duration) WriteBehindOption {
+	return func(o *writeBehindOptions) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithSinkErrorHandler is called when a batch could not be written after all
+// attempts. Its keys stay dirty and are written with the next flush.
+func WithSinkErrorHandler(f func(err error)) WriteBehindOption {
+	return func(o *writeBehindOptions) {
+		o.onError = f
+	}
+}
+
+// writeBehind tracks the dirty keys of a cache and writes them to a sink
+type writeBehind[K comparable, V number] struct {
+	c    *cache[K, V]
+	sink Sink[K, V]
+	o    writeBehindOptions
+
+	mu    sync.Mutex
+	dirty map[K]struct{}
+	flush sync.Mutex // serializes flushes
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// SetSink writes every key changed by Set, Add, Replace, Increment,
+// Decrement, SetMax, SetMin, UpdateMax, UpdateMin, Delete, Flush or Load to
+// s in the background. Changes to the same key are coalesced until the next
+// flush, so a burst of Increments results in a single write of the final
+// value. Close writes the remaining changes. Calling SetSink again flushes
+// to the previous sink and replaces it; nil stops the write-behind. The sink
+// of a namespace is closed with its parent cache.
+func (c *Number[K, V]) SetSink(s Sink[K, V], opts ...WriteBehindOption) {
+	var w *writeBehind[K, V]
+	if s != nil {
+		w = &writeBehind[K, V]{
+			c:     c.cache,
+			sink:  s,
+			o:     writeBehindOptions{interval: time.Second, batch: 100, attempts: 3, backoff: 100 * time.Millisecond},
+			dirty: map[K]struct{}{},
+			stop:  make(chan struct{}),
+		}
+		for _, opt := range opts {
+			opt(&w.o)
+		}
+	}
+	c.mu.Lock()
+	old, _ := c.sink.(*writeBehind[K, V])
+	if w != nil {
+		c.sink = w
+		c.setMarkDirty(w.mark)
+	} else {
+		c.sink = nil
+		c.setMarkDirty(nil)
+	}
+	c.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	if w != nil {
+		w.wg.Add(1)
+		go w.run()
+		// Stop the goroutine if the cache is dropped without Close. A
+		// namespace is returned in a new wrapper by every call of Namespace,
+		// which must not stop it, so it is left to its parent.
+		if !c.namespaced {
+			runtime.SetFinalizer(c, nil)
+			runtime.SetFinalizer(c, stopJanitor)
+		}
+	}
+}
+
+// SyncSink writes the changed keys to the sink right away. It returns the
+// errors of the batches that failed after all attempts.
+func (c *Number[K, V]) SyncSink() error {
+	c.mu.RLock()
+	w, _ := c.sink.(*writeBehind[K, V])
+	c.mu.RUnlock()
+	if w == nil {
+		return nil
+	}
+	return w.sync()
+}
+
+// DirtyKeys returns the number of keys waiting to be written to the sink.
+func (c *Number[K, V]) DirtyKeys() int {
+	c.mu.RLock()
+	w, _ := c.sink.(*writeBehind[K, V])
+	c.mu.RUnlock()
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.dirty)
+}
+
+// mark records that k changed. Called with c.mu held.
+func (w *writeBehind[K, V]) mark(k K) {
+	w.mu.Lock()
+	w.dirty[k] = struct{}{}
+	w.mu.Unlock()
+}
+
+func (w *writeBehind[K, V]) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// sync writes the dirty keys in batches
+func (w *writeBehind[K, V]) sync() error {
+	w.flush.Lock()
+	defer w.flush.Unlock()
+	w.mu.Lock()
+	keys := make([]K, 0, len(w.dirty))
+	for k := range w.dirty {
+		keys = append(keys, k)
+	}
+	w.dirty = map[K]struct{}{}
+	w.mu.Unlock()
+
+	var errs []error
+	batch := make([]SinkEntry[K, V], 0, min(len(keys), w.o.batch))
+	for len(keys) > 0 {
+		chunk := keys[:min(len(keys), w.o.batch)]
+		keys = keys[len(chunk):]
+		batch = batch[:0]
+		var missing []int // in batch, of the keys not in memory
+		now := time.Now().UnixNano()
+		w.c.mu.RLock()
+		disk := w.c.disk
+		for _, k := range chunk {
+			v, found := w.c.items[k]
+			// "Inlining" of Expired
+			if !found || (v.Expiration > 0 && now > v.Expiration) {
+				if !found {
+					missing = append(missing, len(batch))
+				}
+				batch = append(batch, SinkEntry[K, V]{Key: k, Deleted: true})
+				continue
+			}
+			batch = append(batch, SinkEntry[K, V]{Key: k, Value: v.Value})
+		}
+		w.c.mu.RUnlock()
+		// Items evicted to the disk tier are still in the cache
+		if disk != nil {
+			for _, i := range missing {
+				if item, _, found := disk.peek(batch[i].Key); found {
+					batch[i] = SinkEntry[K, V]{Key: batch[i].Key, Value: item.Value}
+				}
+			}
+		}
+		if err := w.write(batch); err != nil {
+			errs = append(errs, err)
+			if w.o.onError != nil {
+				w.o.onError(err)
+			}
+			// Write them again with the next flush
+			w.mu.Lock()
+			for _, k := range chunk {
+				w.dirty[k] = struct{}{}
+			}
+			w.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// write passes batch to the sink, retrying with exponential backoff
+func (w *writeBehind[K, V]) write(batch []SinkEntry[K, V]) error {
+	backoff := w.o.backoff
+	var err error
+	for attempt := 0; attempt < w.o.attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = w.sink.Write(batch); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// close stops the background flushes and writes the remaining keys
+func (w *writeBehind[K, V]) close() {
+	close(w.stop)
+	w.wg.Wait()
+	w.sync()
+}
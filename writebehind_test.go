@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	mu      sync.Mutex
+	batches [][]SinkEntry[string, int]
+	fail    int // number of calls to fail
+	calls   int
+}
+
+func (s *testSink) Write(batch []SinkEntry[string, int]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("sink down")
+	}
+	s.batches = append(s.batches, append([]SinkEntry[string, int](nil), batch...))
+	return nil
+}
+
+func (s *testSink) entries() map[string]SinkEntry[string, int] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := map[string]SinkEntry[string, int]{}
+	for _, b := range s.batches {
+		for _, e := range b {
+			m[e.Key] = e
+		}
+	}
+	return m
+}
+
+func TestWriteBehind(t *testing.T) {
+	tc := NewNumber[string, int](NoExpiration, 0)
+	sink := &testSink{}
+	tc.SetSink(sink, WithSinkInterval(time.Hour), WithSinkBatchSize(2))
+
+	tc.Set("a", 0, DefaultExpiration)
+	for i := 0; i < 100; i++ {
+		tc.Increment("a", 1)
+	}
+	tc.Set("b", 5, DefaultExpiration)
+	tc.Set("c", 7, DefaultExpiration)
+	tc.Delete("c")
+	tc.Get("b") // reads don't make keys dirty
+	if n := tc.DirtyKeys(); n != 3 {
+		t.Error("expected 3 dirty keys, got", n)
+	}
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.batches) != 2 {
+		t.Error("expected 2 batches of at most 2 keys, got", len(sink.batches))
+	}
+	m := sink.entries()
+	if e := m["a"]; e.Value != 100 || e.Deleted {
+		t.Errorf("a was written as %+v", e)
+	}
+	if e := m["b"]; e.Value != 5 {
+		t.Errorf("b was written as %+v", e)
+	}
+	if e := m["c"]; !e.Deleted {
+		t.Errorf("c was written as %+v", e)
+	}
+	if n := tc.DirtyKeys(); n != 0 {
+		t.Error("keys still dirty after SyncSink:", n)
+	}
+
+	tc.Decrement("a", 10)
+	tc.Close()
+	if e := sink.entries()["a"]; e.Value != 90 {
+		t.Errorf("Close didn't write the last change, a is %+v", e)
+	}
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	tc := NewNumber[string, int](NoExpiration, 0)
+	defer tc.Close()
+	sink := &testSink{fail: 4}
+	var errs []error
+	tc.SetSink(sink, WithSinkInterval(time.Hour), WithSinkRetry(3, time.Millisecond),
+		WithSinkErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	tc.Set("a", 1, DefaultExpiration)
+	if err := tc.SyncSink(); err == nil {
+		t.Fatal("SyncSink didn't fail after 3 attempts")
+	}
+	if sink.calls != 3 || len(errs) != 1 {
+		t.Errorf("expected 3 attempts and 1 error, got %d and %d", sink.calls, len(errs))
+	}
+	if tc.DirtyKeys() != 1 {
+		t.Error("failed key is no longer dirty")
+	}
+	// One more failure, then the retry succeeds
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	if e := sink.entries()["a"]; e.Value != 1 {
+		t.Errorf("a was written as %+v", e)
+	}
+}
+
+func TestWriteBehindInterval(t *testing.T) {
+	tc := NewNumber[string, int](NoExpiration, 0)
+	defer tc.Close()
+	sink := &testSink{}
+	tc.SetSink(sink, WithSinkInterval(5*time.Millisecond))
+	tc.Set("a", 1, DefaultExpiration)
+	deadline := time.Now().Add(time.Second)
+	for len(sink.entries()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing was written in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriteBehindRemovals(t *testing.T) {
+	tc := NewNumber[string, int](NoExpiration, 0, WithCapacity(2))
+	sink := &testSink{}
+	tc.SetSink(sink, WithSinkInterval(time.Hour))
+	defer tc.Close()
+
+	tc.Set("expired", 1, time.Millisecond)
+	tc.Set("a", 2, DefaultExpiration)
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	tc.DeleteExpired()
+	tc.Set("b", 3, DefaultExpiration)
+	tc.Set("c", 4, DefaultExpiration) // evicts a or b
+	if err := tc.UpdateExpiration("c", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	m := sink.entries()
+	if !m["expired"].Deleted {
+		t.Error("expired key not written as deleted")
+	}
+	if !m["a"].Deleted && !m["b"].Deleted {
+		t.Error("evicted key not written as deleted:", m)
+	}
+	if e := m["c"]; e.Deleted || e.Value != 4 {
+		t.Error("unexpected entry for c:", e)
+	}
+
+	tc.dropAll()
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	if m := sink.entries(); !m["c"].Deleted {
+		t.Error("key dropped by an invalidation not written as deleted")
+	}
+}
+
+func TestWriteBehindDiskTier(t *testing.T) {
+	disk, err := OpenDiskTier[string, int](t.TempDir(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	tc := NewNumber[string, int](NoExpiration, 0, WithCapacity(1))
+	tc.SetDiskTier(disk)
+	sink := &testSink{}
+	tc.SetSink(sink, WithSinkInterval(time.Hour))
+	defer tc.Close()
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration) // moves a to disk
+	if err := tc.SyncSink(); err != nil {
+		t.Fatal(err)
+	}
+	if e := sink.entries()["a"]; e.Deleted || e.Value != 1 {
+		t.Error("item moved to the disk tier written as", e)
+	}
+}
+
+func TestWriteBehindNamespace(t *testing.T) {
+	tc := NewNumber[string, int](NoExpiration, 0)
+	sink := &testSink{}
+	// The wrapper returned by Namespace is dropped right away
+	tc.Namespace("ns", DefaultExpiration).SetSink(sink, WithSinkInterval(time.Hour))
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	ns := tc.Namespace("ns", DefaultExpiration)
+	ns.Set("a", 1, DefaultExpiration)
+	if ns.DirtyKeys() != 1 {
+		t.Fatal("write-behind of the namespace stopped when its wrapper was collected")
+	}
+	tc.Close()
+	if e, found := sink.entries()["a"]; !found || e.Value != 1 {
+		t.Error("closing the parent didn't flush the sink of the namespace:", e, found)
+	}
+}